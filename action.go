@@ -0,0 +1,153 @@
+package crud
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4"
+	"github.com/kmlixh/gom/v4/define"
+)
+
+// ActionContext 自定义操作的执行上下文
+type ActionContext struct {
+	*gin.Context
+	DB        *gom.DB           // 资源使用的数据库
+	Tx        *gom.Chain        // 本次请求的事务，处理函数返回错误时回滚
+	Entity    any               // 资源实体的新实例，BindBody 为 true 时已绑定请求体
+	Condition *define.Condition // 按 Conditions 解析出的条件，子资源已限定在父资源下，可能为 nil
+}
+
+// ActionFunc 自定义操作的处理函数，返回的数据作为响应的 data 输出
+type ActionFunc func(ctx *ActionContext) (any, error)
+
+// Action 资源上的自定义操作，如 POST /orders/cancel
+// 与内置接口共用资源的数据库、实体、条件解析、父资源校验和扩展配置，并注册到 API 文档
+// GET 的操作按 list 接口处理，其他方法的操作按 update 接口处理，使用其中间件和超时时间，未开放时不能添加
+type Action struct {
+	Name        string           // 接口名称
+	Method      string           // HTTP方法，默认为 POST
+	Path        string           // 路由路径，如 cancel
+	Description string           // 接口说明
+	Parameters  []ApiProperty    // 入参说明
+	Conditions  []ConditionParam // 解析为条件的请求参数，会自动加入入参说明
+	Response    APIResponse      // 响应说明，为空时为 CodeMsg 结构
+	BindBody    bool             // 是否将 JSON 请求体绑定到实体
+	Handler     ActionFunc       // 处理函数，在事务中执行
+}
+
+// DoAction 执行自定义操作，处理函数返回错误时回滚事务
+func DoAction(handler ActionFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db, ok := GetContextDatabase(c)
+		if !ok {
+			RenderErr2(c, 500, "can't find database")
+			return
+		}
+		i, ok := GetContextEntity(c)
+		if !ok {
+			RenderErr2(c, 500, "can't find data entity")
+			return
+		}
+		cnd, _ := getScopedCondition(c)
+		var data any
+		er := transaction(c, db, func(tx *gom.Chain) error {
+			var er error
+			data, er = handler(&ActionContext{Context: c, DB: db, Tx: tx, Entity: i, Condition: cnd})
+			return er
+		})
+		if er != nil {
+			if code, msg, ok := contextErrorCode(c, er); ok {
+				RenderErr2(c, code, msg)
+				return
+			}
+			RenderErrs(c, er)
+			return
+		}
+		invalidateCache(c, getTableName(i))
+		RenderOk(c, data)
+	}
+}
+
+// newActionEntity 为每个请求创建新的实体实例，bind 为 true 时绑定 JSON 请求体
+func newActionEntity(i any, bind bool) gin.HandlerFunc {
+	t := reflect.TypeOf(i)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return func(c *gin.Context) {
+		entity := reflect.New(t).Interface()
+		if bind {
+			DefaultUnMarshFunc(entity)(c)
+			return
+		}
+		SetContextEntity(entity)(c)
+	}
+}
+
+// AddAction 在资源上添加自定义操作，需要在 Register 之前调用
+// 添加的操作保存在 GenHandlerRegister 创建的共享列表中，Crud 按值传递后仍然可见
+func (h Crud) AddAction(action Action) error {
+	if h.actions == nil {
+		return errors.New("actions need a resource created by GenHandlerRegister")
+	}
+	if action.Path == "" {
+		return errors.New("action path could not be empty")
+	}
+	if action.Handler == nil {
+		return errors.New("action handler could not be nil")
+	}
+	if _, er := h.GetHandler(action.Path); er == nil {
+		return fmt.Errorf("handler [%s] already exists", action.Path)
+	}
+	var opts *Options
+	for _, handler := range h.Handlers {
+		if handler.Options != nil && handler.Options.entity != nil {
+			opts = handler.Options
+			break
+		}
+	}
+	if opts == nil {
+		return errors.New("resource has no entity, actions need a resource created by NewCrud2")
+	}
+	method := action.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	location := "body"
+	if method == http.MethodGet {
+		location = "query"
+	}
+	parameters := append(append([]ApiProperty{}, action.Parameters...), generateApiPropertys(action.Conditions, location, false)...)
+	response := action.Response
+	if response.Description == "" && response.Content == nil {
+		response = NewCodeMsgResponse(action.Name, 200, "ok")
+	}
+
+	handler := GetRouteHandler(action.Path, method, action.Name, action.Description, parameters, response,
+		SetContextDatabase(opts.db, opts.Router),
+		SetContextOptions(opts),
+		newActionEntity(opts.entity, action.BindBody),
+		SetConditionParamAsCnd(action.Conditions),
+		DoAction(action.Handler),
+	)
+	handler.Options = opts
+	handler.Operation = PathUpdate
+	if method == http.MethodGet {
+		handler.Operation = PathList
+	}
+	if !opts.operationEnabled(handler.Operation) {
+		return fmt.Errorf("action [%s] requires operation [%s], which is not enabled", action.Path, handler.Operation)
+	}
+	handlers := []RouteHandler{handler}
+	if opts.Parent != nil {
+		nestHandlers(handlers)
+	}
+	resolveHandlers(opts, handlers)
+	applyMiddlewares(opts, handlers)
+	applyTimeouts(opts, handlers)
+	*h.actions = append(*h.actions, handlers[0])
+	return nil
+}
@@ -0,0 +1,81 @@
+package crud
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4/define"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddAction(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	idParam := []ConditionParam{{QueryName: "idEq", ColName: "id", Operation: define.OpEq}}
+	orders, er := NewCrud2("action_orders", &Order{}, nil, []string{"id", "no"}, nil, nil, idParam, []string{"no"}, []string{"no"}, nil, idParam, nil)
+	assert.NoError(t, er)
+
+	assert.Error(t, orders.AddAction(Action{Path: "cancel"}))
+	// Crud 按值传递，副本上添加的操作同样可见
+	copied := orders.(Crud)
+	assert.NoError(t, copied.AddAction(Action{
+		Name:       "取消订单",
+		Path:       "cancel",
+		Conditions: idParam,
+		Handler: func(ctx *ActionContext) (any, error) {
+			return nil, nil
+		},
+	}))
+	assert.Error(t, orders.AddAction(Action{Path: "cancel", Handler: func(ctx *ActionContext) (any, error) { return nil, nil }}))
+	handler, er := orders.GetHandler("cancel")
+	assert.NoError(t, er)
+	assert.Equal(t, "POST", handler.HttpMethod)
+	assert.Equal(t, "idEq", handler.Parameters[0].Name)
+	assert.Equal(t, "body", handler.Parameters[0].Location)
+
+	r := gin.New()
+	assert.NoError(t, orders.Register(r.Group("/api")))
+	found := false
+	for _, route := range r.Routes() {
+		found = found || route.Method+" "+route.Path == "POST /api/action_orders/cancel"
+	}
+	assert.True(t, found)
+	docs := globalAPIRegistry.GetAPIsByGroup("action_orders")
+	assert.Equal(t, "action_orders/cancel", docs[len(docs)-1].Path)
+}
+
+func TestActionEntity(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/orders/cancel", strings.NewReader(`{"idEq":3,"no":"A1"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	SetContextOptions(NewOptions(&Order{}))(c)
+
+	// 绑定请求体后仍然可以从请求体解析条件
+	newActionEntity(&Order{}, true)(c)
+	SetConditionParamAsCnd([]ConditionParam{{QueryName: "idEq", ColName: "id", Operation: define.OpEq}})(c)
+	assert.False(t, c.IsAborted())
+	i, _ := GetContextEntity(c)
+	assert.Equal(t, "A1", i.(*Order).No)
+	cnd, ok := getContextCondition(c)
+	assert.True(t, ok)
+	assert.True(t, MatchCondition(cnd, map[string]any{"id": int64(3)}))
+}
+
+func TestRequestBodyFollowsRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/orders/cancel", strings.NewReader(`{"id":1}`))
+	body, er := requestBody(c)
+	assert.NoError(t, er)
+	assert.Equal(t, `{"id":1}`, string(body))
+	body, _ = requestBody(c)
+	assert.Equal(t, `{"id":1}`, string(body))
+
+	// 替换请求后读取新的请求体
+	c.Request = httptest.NewRequest("POST", "/orders/cancel", strings.NewReader(`{"id":2}`))
+	body, er = requestBody(c)
+	assert.NoError(t, er)
+	assert.Equal(t, `{"id":2}`, string(body))
+}
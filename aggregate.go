@@ -0,0 +1,401 @@
+package crud
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4"
+	"github.com/kmlixh/gom/v4/define"
+)
+
+// PathAggregate 分组统计接口的名称
+const PathAggregate DefaultRoutePath = "aggregate"
+
+// aggregateFuncs 支持的统计函数
+var aggregateFuncs = []string{"count", "sum", "avg", "min", "max"}
+
+// havingOps having 条件支持的比较操作，与查询条件的后缀一致
+var havingOps = map[string]string{"Eq": "=", "NotEq": "<>", "Gt": ">", "Ge": ">=", "Lt": "<", "Le": "<="}
+
+// AggregateOptions 分组统计接口的配置
+type AggregateOptions struct {
+	GroupBy    []string // 允许分组的列
+	Columns    []string // 允许 sum、avg、min、max 以及 count(列) 统计的列
+	TimeFields []string // 允许按时间分桶的列，不为空时开放 {prefix}/timeseries 接口
+	MaxBuckets int      // 返回的分组或时间桶数量上限，默认为 1000
+}
+
+// maxBuckets 分组或时间桶的数量上限
+func (o *AggregateOptions) maxBuckets() int {
+	if o.MaxBuckets <= 0 {
+		return 1000
+	}
+	return o.MaxBuckets
+}
+
+// WithAggregate 开放 {prefix}/aggregate 分组统计接口，查询条件与列表接口相同
+//
+//	GET /orders/aggregate?groupBy=status&metrics=count,sum:amount&having=count:Gt:10&sort=-sum_amount&limit=20&createdAtGe=2024-01-01
+//
+// 统计结果的名称为 count 或 函数_列名，having 和 sort 使用该名称，sort 也可以使用分组列，前缀 - 表示倒序
+// 配置了 TimeFields 时同时开放 {prefix}/timeseries 按时间分桶统计接口
+//
+//	GET /orders/timeseries?field=created_at&interval=day&tz=Asia/Shanghai&metric=count&from=2024-01-01&to=2024-02-01
+func WithAggregate(aggregate AggregateOptions) Option {
+	return func(o *Options) {
+		o.Aggregate = &aggregate
+	}
+}
+
+// aggregateMetric 一个统计项
+type aggregateMetric struct {
+	Func   string
+	Column string
+	Alias  string
+}
+
+// aggregateHaving 按统计结果过滤分组
+type aggregateHaving struct {
+	Metric aggregateMetric
+	Op     string
+	Value  float64
+}
+
+// aggregateSort 分组的排序
+type aggregateSort struct {
+	Key  string
+	Desc bool
+}
+
+// aggregateQuery 解析后的分组统计请求
+type aggregateQuery struct {
+	GroupBy []string
+	Metrics []aggregateMetric
+	Having  []aggregateHaving
+	Sort    []aggregateSort
+	Limit   int
+}
+
+// splitParam 按逗号拆分查询参数，忽略空值
+func splitParam(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// statColumns 统计接口可以使用的列，即列表接口中调用者可读的列，为空表示所有列
+func statColumns(c *gin.Context) ([]string, error) {
+	if schema, ok := GetContextTable(c); ok {
+		return tableReadColumns(c, schema)
+	}
+	i, _ := GetContextEntity(c)
+	return readableColumns(c, i, getSelectColumns(c))
+}
+
+// allowedColumn 将 json 名称或列名转换为列名，列需要在白名单中，readable 不为空时还需要在其中
+func allowedColumn(opts *Options, allowed []string, readable []string, name string) (string, bool) {
+	col := opts.columnName(name)
+	for _, item := range allowed {
+		if opts.columnName(item) == col {
+			return col, len(readable) == 0 || containsString(readable, col)
+		}
+	}
+	return col, false
+}
+
+// protectedColumn 列是否加密或需要对调用者脱敏，这类列的值不能用于分组和统计
+func protectedColumn(c *gin.Context, opts *Options, col string) bool {
+	if _, ok := opts.Encrypted[col]; ok {
+		return true
+	}
+	if _, ok := opts.Masks[col]; ok {
+		mask, er := shouldMask(c, opts)
+		return mask || er != nil
+	}
+	return false
+}
+
+// parseAggregateQuery 按白名单和调用者可读的列解析分组统计的请求参数，列可以使用 json 名称
+func parseAggregateQuery(c *gin.Context, opts *Options) (*aggregateQuery, error) {
+	aggregate := opts.Aggregate
+	readable, er := statColumns(c)
+	if er != nil {
+		return nil, er
+	}
+	query := &aggregateQuery{}
+	for _, name := range splitParam(c.Query("groupBy")) {
+		col, ok := allowedColumn(opts, aggregate.GroupBy, readable, name)
+		if !ok {
+			return nil, fmt.Errorf("column [%s] could not be grouped", name)
+		}
+		if protectedColumn(c, opts, col) {
+			return nil, fmt.Errorf("column [%s] is encrypted or masked and could not be grouped", name)
+		}
+		query.GroupBy = append(query.GroupBy, col)
+	}
+
+	metrics := splitParam(c.Query("metrics"))
+	if len(metrics) == 0 {
+		metrics = []string{"count"}
+	}
+	aliases := make(map[string]aggregateMetric)
+	for _, item := range metrics {
+		metric, er := parseAggregateMetric(c, item, opts, readable)
+		if er != nil {
+			return nil, er
+		}
+		if _, ok := aliases[metric.Alias]; !ok {
+			aliases[metric.Alias] = metric
+			query.Metrics = append(query.Metrics, metric)
+		}
+	}
+
+	for _, item := range splitParam(c.Query("having")) {
+		parts := strings.Split(item, ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("having [%s] should be metric:op:value", item)
+		}
+		metric, ok := aliases[parts[0]]
+		if !ok {
+			return nil, fmt.Errorf("having metric [%s] is not requested", parts[0])
+		}
+		if _, ok := havingOps[parts[1]]; !ok {
+			return nil, fmt.Errorf("having operation [%s] is not supported", parts[1])
+		}
+		value, er := strconv.ParseFloat(parts[2], 64)
+		if er != nil {
+			return nil, fmt.Errorf("having value [%s] should be a number", parts[2])
+		}
+		query.Having = append(query.Having, aggregateHaving{Metric: metric, Op: parts[1], Value: value})
+	}
+
+	for _, item := range splitParam(c.Query("sort")) {
+		sort := aggregateSort{Key: strings.TrimPrefix(item, "-"), Desc: strings.HasPrefix(item, "-")}
+		if _, ok := aliases[sort.Key]; !ok {
+			if !containsString(query.GroupBy, opts.columnName(sort.Key)) {
+				return nil, fmt.Errorf("could not sort by [%s]", sort.Key)
+			}
+			sort.Key = opts.columnName(sort.Key)
+		}
+		query.Sort = append(query.Sort, sort)
+	}
+
+	query.Limit = aggregate.maxBuckets()
+	if limit := c.Query("limit"); limit != "" {
+		n, er := strconv.Atoi(limit)
+		if er != nil || n <= 0 {
+			return nil, fmt.Errorf("limit [%s] should be a positive integer", limit)
+		}
+		if n < query.Limit {
+			query.Limit = n
+		}
+	}
+	return query, nil
+}
+
+// parseAggregateMetric 解析 count 或 函数:列名，列需要在白名单中且调用者可读，加密或脱敏的列只能 count
+func parseAggregateMetric(c *gin.Context, item string, opts *Options, readable []string) (aggregateMetric, error) {
+	fn, col, _ := strings.Cut(item, ":")
+	fn = strings.ToLower(fn)
+	if !containsString(aggregateFuncs, fn) {
+		return aggregateMetric{}, fmt.Errorf("aggregate [%s] is not supported", fn)
+	}
+	if col == "" {
+		if fn != "count" {
+			return aggregateMetric{}, fmt.Errorf("aggregate [%s] requires a column", fn)
+		}
+		return aggregateMetric{Func: fn, Alias: fn}, nil
+	}
+	name := col
+	col, ok := allowedColumn(opts, opts.Aggregate.Columns, readable, name)
+	if !ok || (fn != "count" && protectedColumn(c, opts, col)) {
+		return aggregateMetric{}, fmt.Errorf("aggregate [%s] is not allowed on column [%s]", fn, name)
+	}
+	return aggregateMetric{Func: fn, Column: col, Alias: fn + "_" + col}, nil
+}
+
+// quoteIdentifier 按数据库类型引用列名
+func quoteIdentifier(db *gom.DB, name string) string {
+	if db.Factory != nil && db.Factory.GetType() == "postgres" {
+		return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+	}
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// expression 统计项的 SQL 表达式
+func (m aggregateMetric) expression(db *gom.DB) string {
+	if m.Column == "" {
+		return "COUNT(*)"
+	}
+	return strings.ToUpper(m.Func) + "(" + quoteIdentifier(db, m.Column) + ")"
+}
+
+// build 生成分组统计的 SQL，列名均来自白名单，having 的阈值已解析为数字
+func (q *aggregateQuery) build(db *gom.DB, table string, cond *define.Condition) (string, []any) {
+	fields := make([]string, 0, len(q.GroupBy)+len(q.Metrics))
+	groups := make([]string, 0, len(q.GroupBy))
+	for _, col := range q.GroupBy {
+		fields = append(fields, col)
+		groups = append(groups, quoteIdentifier(db, col))
+	}
+	for _, metric := range q.Metrics {
+		fields = append(fields, metric.expression(db)+" AS "+quoteIdentifier(db, metric.Alias))
+	}
+	conds := make([]*define.Condition, 0, 1)
+	if cond != nil {
+		conds = append(conds, cond)
+	}
+	sqlStr, args := db.Factory.BuildSelect(table, fields, conds, "", 0, 0)
+
+	var sb strings.Builder
+	sb.WriteString(sqlStr)
+	if len(groups) > 0 {
+		sb.WriteString(" GROUP BY " + strings.Join(groups, ", "))
+	}
+	if len(q.Having) > 0 {
+		having := make([]string, 0, len(q.Having))
+		for _, h := range q.Having {
+			having = append(having, h.Metric.expression(db)+" "+havingOps[h.Op]+" "+strconv.FormatFloat(h.Value, 'f', -1, 64))
+		}
+		sb.WriteString(" HAVING " + strings.Join(having, " AND "))
+	}
+	if len(q.Sort) > 0 {
+		orders := make([]string, 0, len(q.Sort))
+		for _, s := range q.Sort {
+			order := quoteIdentifier(db, s.Key)
+			if s.Desc {
+				order += " DESC"
+			}
+			orders = append(orders, order)
+		}
+		sb.WriteString(" ORDER BY " + strings.Join(orders, ", "))
+	}
+	sb.WriteString(" LIMIT " + strconv.Itoa(q.Limit))
+	return sb.String(), args
+}
+
+// numericValue 将数据库返回的统计结果转换为数字
+func numericValue(v any) any {
+	v = normalizeValue(v)
+	if s, ok := v.(string); ok {
+		if n, er := strconv.ParseInt(s, 10, 64); er == nil {
+			return n
+		}
+		if f, er := strconv.ParseFloat(s, 64); er == nil {
+			return f
+		}
+	}
+	return v
+}
+
+// DoAggregate 分组统计，查询条件来自列表接口的条件参数
+func DoAggregate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db, ok := GetContextReadDatabase(c)
+		if !ok {
+			RenderErr2(c, 500, "can't find database")
+			return
+		}
+		opts, ok := GetContextOptions(c)
+		if !ok || opts.Aggregate == nil {
+			RenderErr2(c, 500, "aggregate is not configured")
+			return
+		}
+		table, ok := contextTableName(c)
+		if !ok {
+			RenderErr2(c, 500, "can't find data entity")
+			return
+		}
+		query, er := parseAggregateQuery(c, opts)
+		if er != nil {
+			RenderErr2(c, 400, er.Error())
+			return
+		}
+		cond, _ := getScopedCondition(c)
+		sqlStr, args := query.build(db, table, cond)
+		var result *define.Result
+		if er := readTransaction(c, db, func(tx *gom.Chain) error {
+			result = tx.RawQuery(sqlStr, args...)
+			return nil
+		}); er != nil {
+			renderDBError(c, 500, er)
+			return
+		}
+		if result.Error != nil {
+			renderDBError(c, 500, result.Error)
+			return
+		}
+		buckets := make([]map[string]any, 0, len(result.Data))
+		for _, row := range result.Data {
+			bucket := make(map[string]any, len(row))
+			for k, v := range row {
+				bucket[k] = normalizeValue(v)
+			}
+			for _, metric := range query.Metrics {
+				bucket[metric.Alias] = numericValue(row[metric.Alias])
+			}
+			buckets = append(buckets, bucket)
+		}
+		RenderOk(c, buckets)
+	}
+}
+
+// aggregateHandlers 按列表接口生成分组统计、时间分桶和分面统计接口，共用列表接口的查询条件、鉴权和租户处理
+func aggregateHandlers(opts *Options, handlers []RouteHandler) []RouteHandler {
+	if opts.Aggregate == nil && opts.Facets == nil {
+		return handlers
+	}
+	for _, handler := range handlers {
+		if handler.Path != string(PathList) {
+			continue
+		}
+		if opts.Aggregate != nil {
+			handlers = append(handlers, aggregateHandler(opts, handler))
+			if len(opts.Aggregate.TimeFields) > 0 {
+				handlers = append(handlers, timeseriesHandler(opts, handler))
+			}
+		}
+		if opts.Facets != nil {
+			handlers = append(handlers, facetsHandler(opts, handler))
+		}
+		return handlers
+	}
+	return handlers
+}
+
+// aggregateHandler 按列表接口生成分组统计接口
+func aggregateHandler(opts *Options, list RouteHandler) RouteHandler {
+	funcs := append(append([]gin.HandlerFunc{}, list.Handlers[:len(list.Handlers)-1]...), DoAggregate())
+	parameters := append(append([]ApiProperty{}, list.Parameters...),
+		ApiProperty{Name: "groupBy", Type: "string", Description: "分组列，多个用逗号分隔，可选: " + strings.Join(opts.Aggregate.GroupBy, ","), Location: "query"},
+		ApiProperty{Name: "metrics", Type: "string", Description: "统计项，count 或 函数:列名，函数为 " + strings.Join(aggregateFuncs, "、") + "，默认为 count", Location: "query"},
+		ApiProperty{Name: "having", Type: "string", Description: "按统计结果过滤分组，格式为 统计项:操作:数值，操作为 Eq、NotEq、Gt、Ge、Lt、Le", Location: "query"},
+		ApiProperty{Name: "sort", Type: "string", Description: "排序，统计项或分组列，前缀 - 表示倒序", Location: "query"},
+		ApiProperty{Name: "limit", Type: "integer", Description: "返回的分组数量", Location: "query"},
+	)
+	resp := NewCodeMsgResponse("分组统计结果", 200, "ok")
+	resp.Content["data"] = MediaType{Schema: &ApiProperty{Type: "array", Description: "分组列和统计项"}}
+	return withOperation(GetRouteHandler(string(PathAggregate), "GET", opts.Resource+"分组统计", "按条件分组统计"+opts.Resource, parameters, resp, funcs...), PathList)
+}
+
+// timeseriesHandler 按列表接口生成时间分桶统计接口
+func timeseriesHandler(opts *Options, list RouteHandler) RouteHandler {
+	funcs := append(append([]gin.HandlerFunc{}, list.Handlers[:len(list.Handlers)-1]...), DoTimeseries())
+	parameters := append(append([]ApiProperty{}, list.Parameters...),
+		ApiProperty{Name: "field", Type: "string", Required: true, Description: "分桶的时间列，可选: " + strings.Join(opts.Aggregate.TimeFields, ","), Location: "query"},
+		ApiProperty{Name: "interval", Type: "string", Description: "分桶粒度，" + strings.Join(timeIntervals, "、") + "，默认为 day", Location: "query"},
+		ApiProperty{Name: "tz", Type: "string", Description: "时区，如 Asia/Shanghai，默认为 UTC", Location: "query"},
+		ApiProperty{Name: "metric", Type: "string", Description: "统计项，count 或 函数:列名，多个用逗号分隔，默认为 count", Location: "query"},
+		ApiProperty{Name: "from", Type: "string", Description: "开始时间(包含)，默认为截至 to 的 30 个桶", Location: "query"},
+		ApiProperty{Name: "to", Type: "string", Description: "结束时间(不包含)，默认为当前时间", Location: "query"},
+	)
+	resp := NewCodeMsgResponse("时间分桶统计结果", 200, "ok")
+	resp.Content["data"] = MediaType{Schema: &ApiProperty{Type: "array", Description: "bucket 为桶的起点，其余为统计项，没有数据的桶已补齐"}}
+	return withOperation(GetRouteHandler(string(PathTimeseries), "GET", opts.Resource+"时间分桶统计", "按时间分桶统计"+opts.Resource, parameters, resp, funcs...), PathList)
+}
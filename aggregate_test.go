@@ -0,0 +1,85 @@
+package crud
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4"
+	"github.com/kmlixh/gom/v4/define"
+	"github.com/kmlixh/gom/v4/factory/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAggregateQuery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	opts := &Options{}
+	WithAggregate(AggregateOptions{GroupBy: []string{"status"}, Columns: []string{"amount"}, MaxBuckets: 50})(opts)
+	parse := func(query string) (*aggregateQuery, error) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/orders/aggregate?"+query, nil)
+		SetContextOptions(opts)(c)
+		return parseAggregateQuery(c, opts)
+	}
+
+	q, er := parse("groupBy=status&metrics=count,sum:amount&having=count:Gt:10&sort=-sum_amount&limit=100")
+	assert.NoError(t, er)
+	assert.Equal(t, 50, q.Limit)
+	db := &gom.DB{Factory: &mysql.Factory{}}
+	sqlStr, args := q.build(db, "orders", define.Eq("region", "eu"))
+	assert.Equal(t, "SELECT `status`, COUNT(*) AS `count`, SUM(`amount`) AS `sum_amount` FROM `orders` WHERE `region` = ? GROUP BY `status` HAVING COUNT(*) > 10 ORDER BY `sum_amount` DESC LIMIT 50", sqlStr)
+	assert.Equal(t, []any{"eu"}, args)
+
+	_, er = parse("groupBy=customer")
+	assert.EqualError(t, er, "column [customer] could not be grouped")
+	_, er = parse("metrics=sum:discount")
+	assert.EqualError(t, er, "aggregate [sum] is not allowed on column [discount]")
+	_, er = parse("having=sum_amount:Gt:1")
+	assert.EqualError(t, er, "having metric [sum_amount] is not requested")
+	_, er = parse("sort=region")
+	assert.EqualError(t, er, "could not sort by [region]")
+}
+
+func TestAggregateProtectedColumns(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	opts := NewOptions(&Order{},
+		WithAggregate(AggregateOptions{GroupBy: []string{"customer_id", "no"}, Columns: []string{"id", "no"}}),
+		WithMask("no", MaskName),
+		WithFieldPermissions(FieldPermission{Field: "customer_id", Readable: []string{"admin"}}))
+	parse := func(query string, roles ...string) (*aggregateQuery, error) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/orders/aggregate?"+query, nil)
+		SetContextOptions(opts)(c)
+		SetContextEntity(&Order{})(c)
+		SetContextRoles(roles...)(c)
+		return parseAggregateQuery(c, opts)
+	}
+
+	// 分组列可以使用 json 名称
+	q, er := parse("groupBy=customerId&metrics=max:id", "admin")
+	assert.NoError(t, er)
+	assert.Equal(t, []string{"customer_id"}, q.GroupBy)
+	// 不可读的列不能分组
+	_, er = parse("groupBy=customerId")
+	assert.EqualError(t, er, "column [customerId] could not be grouped")
+	// 脱敏的列不能分组，也不能做计数以外的统计
+	_, er = parse("groupBy=no", "admin")
+	assert.EqualError(t, er, "column [no] is encrypted or masked and could not be grouped")
+	_, er = parse("metrics=max:no", "admin")
+	assert.EqualError(t, er, "aggregate [max] is not allowed on column [no]")
+}
+
+func TestAggregateHandlers(t *testing.T) {
+	opts := &Options{Resource: "orders"}
+	WithAggregate(AggregateOptions{GroupBy: []string{"status"}})(opts)
+	WithOperationMiddleware(PathList, RequireIdentity("admin"))(opts)
+	list := RouteHandler{Path: string(PathList), Handlers: []gin.HandlerFunc{DoNothingFunc, DoNothingFunc, QueryList()}}
+	handlers := aggregateHandlers(opts, []RouteHandler{list})
+	assert.Len(t, handlers, 2)
+	assert.Equal(t, string(PathAggregate), handlers[1].Path)
+	assert.Equal(t, "GET", handlers[1].HttpMethod)
+
+	// 分组统计同样受列表接口的鉴权约束
+	applyMiddlewares(opts, handlers)
+	assert.Len(t, handlers[1].Handlers, 4)
+}
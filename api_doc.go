@@ -2,6 +2,7 @@ package crud
 
 import (
 	"reflect"
+	"strings"
 	"sync"
 	"time"
 
@@ -10,12 +11,13 @@ import (
 
 // APIParam 描述API参数
 type ApiProperty struct {
-	Name        string        `json:"name"`        // 参数名称
-	Type        string        `json:"type"`        // 参数类型
-	Required    bool          `json:"required"`    // 是否必须
-	Description string        `json:"description"` // 参数说明
-	Location    string        `json:"location"`    // 参数位置(query/body/path)
-	Fields      []ApiProperty `json:"fields"`      // 用于对象类型的子属性定义
+	Name        string        `json:"name"`           // 参数名称
+	Type        string        `json:"type"`           // 参数类型
+	Required    bool          `json:"required"`       // 是否必须
+	Description string        `json:"description"`    // 参数说明
+	Location    string        `json:"location"`       // 参数位置(query/body/path)
+	Fields      []ApiProperty `json:"fields"`         // 用于对象类型的子属性定义
+	Mask        string        `json:"mask,omitempty"` // 脱敏策略，为空表示不脱敏
 }
 
 // GeneratePageInfoApiProperty 生成描述 PageInfo 结构体的 ApiProperty 对象
@@ -119,7 +121,7 @@ func GenerateApiPropertiesFromStruct(instance interface{}) []ApiProperty {
 		fieldValue := val.Field(i)
 
 		// 获取字段的标签信息
-		jsonTag := field.Tag.Get("json")
+		jsonTag := strings.Split(field.Tag.Get("json"), ",")[0]
 		if jsonTag == "" {
 			jsonTag = field.Name
 		}
@@ -163,9 +165,10 @@ func GenerateApiPropertiesFromStruct(instance interface{}) []ApiProperty {
 
 // APIResponse 描述API响应
 type APIResponse struct {
-	Description string               `json:"description"`       // 响应说明
-	Content     map[string]MediaType `json:"content"`           // 响应内容
-	Headers     map[string]Header    `json:"headers,omitempty"` // 响应头
+	Description string               `json:"description"`          // 响应说明
+	Content     map[string]MediaType `json:"content"`              // 响应内容
+	Headers     map[string]Header    `json:"headers,omitempty"`    // 响应头
+	StatusCode  int                  `json:"statusCode,omitempty"` // 成功时的HTTP状态码，为空表示200
 }
 
 // NewCodeMsgResponse 创建一个包含CodeMsg结构体的APIResponse
@@ -220,6 +223,8 @@ type APIDoc struct {
 	Parameters  []ApiProperty          `json:"parameters"`  // 入参列表
 	Response    APIResponse            `json:"response"`    // 响应说明
 	Metadata    map[string]interface{} `json:"metadata"`    // 扩展元数据
+
+	options *Options // 资源扩展配置，用于按调用者过滤文档
 }
 
 // APIRegistry 全局API注册表
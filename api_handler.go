@@ -15,7 +15,11 @@ func RegisterAPIDocHandler(engine *gin.Engine, path string) {
 
 // GetAPIDoc 获取所有API文档
 func GetAPIDoc(c *gin.Context) {
-	RenderOk(c, globalAPIRegistry.GetAPIs())
+	apis := globalAPIRegistry.GetAPIs()
+	for group, docs := range apis {
+		apis[group] = filterAPIDocs(c, docs)
+	}
+	RenderOk(c, apis)
 }
 
 // GetGroupAPIDoc 获取分组API文档
@@ -26,5 +30,5 @@ func GetGroupAPIDoc(c *gin.Context) {
 		RenderErr2(c, 404, "API group not found")
 		return
 	}
-	RenderOk(c, apis)
+	RenderOk(c, filterAPIDocs(c, apis))
 }
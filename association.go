@@ -0,0 +1,370 @@
+package crud
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4"
+	"github.com/kmlixh/gom/v4/define"
+)
+
+// ManyToMany 通过中间表建立的多对多关联，生成 {prefix}/{Name}/list、link、unlink、replace 接口
+type ManyToMany struct {
+	Name        string // 关联名称，作为路由的一部分，如 roles
+	JoinTable   string // 中间表，如 user_roles
+	JoinLocal   string // 中间表中指向本资源主键的列，如 user_id
+	JoinForeign string // 中间表中指向关联资源主键的列，如 role_id
+	Resource    ICrud  // 关联资源，可为空；设置后 list 返回关联资源的数据，link/replace 会校验关联数据是否存在
+}
+
+// WithManyToMany 声明资源的多对多关联
+func WithManyToMany(relations ...ManyToMany) Option {
+	return func(o *Options) {
+		o.ManyToMany = append(o.ManyToMany, relations...)
+	}
+}
+
+// AssociationAction 多对多关联的操作
+type AssociationAction string
+
+const (
+	AssociationList    AssociationAction = "list"
+	AssociationLink    AssociationAction = "link"
+	AssociationUnlink  AssociationAction = "unlink"
+	AssociationReplace AssociationAction = "replace"
+)
+
+// AssociationResult link、unlink、replace 的结果
+type AssociationResult struct {
+	Linked   int64 `json:"linked"`   // 新建立的关联数量
+	Unlinked int64 `json:"unlinked"` // 解除的关联数量
+}
+
+// associationRequest 解析请求中的本资源主键和关联资源主键列表，主键统一转换为字符串
+// ids 可以是 JSON 数组，也可以是表单中的一个或多个值
+func associationRequest(c *gin.Context, withIds bool) (string, []any, error) {
+	maps, er := GetMapFromRst(c)
+	if er != nil {
+		return "", nil, er
+	}
+	id := formatValue(maps["id"])
+	if maps["id"] == nil || id == "" {
+		return "", nil, errors.New("id could not be empty")
+	}
+	if !withIds {
+		return id, nil, nil
+	}
+	var raw []any
+	switch v := maps["ids"].(type) {
+	case []any:
+		raw = v
+	case []string:
+		for _, item := range v {
+			raw = append(raw, item)
+		}
+	case string:
+		if c.ContentType() != gin.MIMEPOSTForm {
+			return "", nil, errors.New("ids must be an array")
+		}
+		raw = []any{v}
+	default:
+		return "", nil, errors.New("ids must be an array")
+	}
+	ids := make([]any, 0, len(raw))
+	seen := make(map[string]bool)
+	for _, v := range raw {
+		if key := formatValue(v); !seen[key] {
+			seen[key] = true
+			ids = append(ids, key)
+		}
+	}
+	return id, ids, nil
+}
+
+// joinedIds 查询本资源一行数据已关联的关联资源主键
+func (m ManyToMany) joinedIds(db *gom.DB, chain *gom.Chain, id string) ([]any, error) {
+	rows, er := queryRows(db, chain, m.JoinTable, define.Eq(m.JoinLocal, id), 0)
+	if er != nil {
+		return nil, er
+	}
+	ids := make([]any, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, row[m.JoinForeign])
+	}
+	return ids, nil
+}
+
+// checkLocal 校验本资源的数据存在，子资源同时校验其属于路由中的父资源
+func checkLocal(c *gin.Context, db *gom.DB, i any, id string) error {
+	var rows []map[string]any
+	er := readTransaction(c, db, func(tx *gom.Chain) (er error) {
+		rows, er = queryRows(db, tx, getTableName(i), scopeCondition(c, define.Eq(primaryKeyOf(i), id)), 1)
+		return er
+	})
+	if er != nil {
+		return er
+	}
+	if len(rows) == 0 {
+		return errors.New("record not found")
+	}
+	return nil
+}
+
+// checkForeign 校验关联资源的数据都存在
+func (m ManyToMany) checkForeign(db *gom.DB, ids []any) error {
+	if m.Resource == nil || len(ids) == 0 {
+		return nil
+	}
+	target, er := Relation{Name: m.Name, Resource: m.Resource}.target()
+	if er != nil {
+		return er
+	}
+	pk := primaryKeyOf(target.entity)
+	sqlStr, args := db.Factory.BuildSelect(getTableName(target.entity), []string{pk}, []*define.Condition{define.In(pk, ids...)}, "", 0, 0)
+	result := db.Chain().RawQuery(sqlStr, args...)
+	if result.Error != nil {
+		return result.Error
+	}
+	if len(result.Data) != len(ids) {
+		return fmt.Errorf("some records of [%s] not found", m.Name)
+	}
+	return nil
+}
+
+// link 在事务中建立尚不存在的关联
+func (m ManyToMany) link(db *gom.DB, tx *gom.Chain, id string, ids []any) (int64, error) {
+	existing, er := m.joinedIds(db, tx, id)
+	if er != nil {
+		return 0, er
+	}
+	linked := make(map[string]bool, len(existing))
+	for _, v := range existing {
+		linked[formatValue(v)] = true
+	}
+	values := make([]map[string]interface{}, 0, len(ids))
+	for _, v := range ids {
+		if !linked[formatValue(v)] {
+			values = append(values, map[string]interface{}{m.JoinLocal: id, m.JoinForeign: v})
+		}
+	}
+	if len(values) == 0 {
+		return 0, nil
+	}
+	sqlStr, args := db.Factory.BuildBatchInsert(m.JoinTable, values)
+	result := tx.RawExecute(sqlStr, args...)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	return int64(len(values)), nil
+}
+
+// unlink 在事务中解除关联，keep 为 true 时解除 ids 以外的关联
+func (m ManyToMany) unlink(db *gom.DB, tx *gom.Chain, id string, ids []any, keep bool) (int64, error) {
+	cnd := define.Eq(m.JoinLocal, id)
+	if keep {
+		if len(ids) > 0 {
+			cnd = cnd.And(define.NotIn(m.JoinForeign, ids...))
+		}
+	} else {
+		if len(ids) == 0 {
+			return 0, nil
+		}
+		cnd = cnd.And(define.In(m.JoinForeign, ids...))
+	}
+	sqlStr, args := db.Factory.BuildDelete(m.JoinTable, []*define.Condition{cnd})
+	result := tx.RawExecute(sqlStr, args...)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	return result.Affected, nil
+}
+
+// DoAssociation 多对多关联接口的处理器
+func DoAssociation(relation ManyToMany, action AssociationAction) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db, ok := GetContextDatabase(c)
+		if !ok {
+			RenderErr2(c, 500, "can't find database")
+			return
+		}
+		i, ok := GetContextEntity(c)
+		if !ok {
+			RenderErr2(c, 500, "can't find data entity")
+			return
+		}
+		id, ids, er := associationRequest(c, action != AssociationList)
+		if er != nil {
+			RenderErrs(c, er)
+			return
+		}
+		if er := checkLocal(c, db, i, id); er != nil {
+			RenderErr2(c, 404, er.Error())
+			return
+		}
+		if action == AssociationList {
+			relation.renderList(c, db, id)
+			return
+		}
+		if action != AssociationUnlink {
+			if er := relation.checkForeign(db, ids); er != nil {
+				RenderErr2(c, 400, er.Error())
+				return
+			}
+		}
+		result := AssociationResult{}
+		er = relation.mutate(c, db, i, id, func(tx *gom.Chain) error {
+			var er error
+			switch action {
+			case AssociationLink:
+				result.Linked, er = relation.link(db, tx, id, ids)
+			case AssociationUnlink:
+				result.Unlinked, er = relation.unlink(db, tx, id, ids, false)
+			case AssociationReplace:
+				if result.Unlinked, er = relation.unlink(db, tx, id, ids, true); er == nil {
+					result.Linked, er = relation.link(db, tx, id, ids)
+				}
+			}
+			return er
+		})
+		if er != nil {
+			renderDBError(c, 500, er)
+			return
+		}
+		RenderOk(c, result)
+	}
+}
+
+// mutate 在事务中修改关联，并作为本资源一行数据的更新交给变更钩子
+// 变更前后的数据为本资源的整行数据加上 {Name: 已关联的主键列表}，审计、版本、事件和缓存失效与普通更新一致
+func (m ManyToMany) mutate(c *gin.Context, db *gom.DB, i any, id string, exec func(tx *gom.Chain) error) error {
+	ReadFromPrimary()(c)
+	opts, _ := GetContextOptions(c)
+	table, pk := getTableName(i), primaryKeyOf(i)
+	var change *Change
+	er := transaction(c, db, func(tx *gom.Chain) error {
+		if opts == nil || len(opts.Hooks) == 0 {
+			return exec(tx)
+		}
+		before, er := m.rowWithIds(db, tx, table, pk, id)
+		if er != nil {
+			return er
+		}
+		if er := exec(tx); er != nil {
+			return er
+		}
+		after, er := m.rowWithIds(db, tx, table, pk, id)
+		if er != nil {
+			return er
+		}
+		change = newChange(c, opts, table, ChangeUpdate)
+		change.Keys = map[string]any{pk: before[pk]}
+		change.Before, change.After = before, after
+		for _, hook := range opts.Hooks {
+			if er := hook.InTx(c, tx, change); er != nil {
+				return fmt.Errorf("change hook failed: %w", er)
+			}
+		}
+		return nil
+	})
+	if er != nil {
+		return er
+	}
+	if change != nil {
+		for _, hook := range opts.Hooks {
+			hook.AfterCommit(c, change)
+		}
+	}
+	invalidateCache(c, table)
+	invalidateCache(c, m.JoinTable)
+	return nil
+}
+
+// rowWithIds 查询本资源的一行数据，并附加已关联的主键列表
+func (m ManyToMany) rowWithIds(db *gom.DB, tx *gom.Chain, table string, pk string, id string) (map[string]any, error) {
+	rows, er := queryRows(db, tx, table, define.Eq(pk, id), 1)
+	if er != nil {
+		return nil, er
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("record not found")
+	}
+	ids, er := m.joinedIds(db, tx, id)
+	if er != nil {
+		return nil, er
+	}
+	keys := make([]string, 0, len(ids))
+	for _, v := range ids {
+		keys = append(keys, formatValue(v))
+	}
+	sort.Strings(keys)
+	rows[0][m.Name] = keys
+	return rows[0], nil
+}
+
+// renderList 输出已关联的数据，没有设置关联资源时只输出关联资源的主键
+func (m ManyToMany) renderList(c *gin.Context, db *gom.DB, id string) {
+	var ids []any
+	er := readTransaction(c, db, func(tx *gom.Chain) (er error) {
+		ids, er = m.joinedIds(db, tx, id)
+		return er
+	})
+	if er != nil {
+		renderDBError(c, 500, er)
+		return
+	}
+	if m.Resource == nil {
+		RenderOk(c, ids)
+		return
+	}
+	target, er := Relation{Name: m.Name, Resource: m.Resource}.target()
+	if er != nil {
+		RenderErr2(c, 500, er.Error())
+		return
+	}
+	grouped, er := loadRelated(c, target, primaryKeyOf(target.entity), ids)
+	if er != nil {
+		RenderErr2(c, 500, er.Error())
+		return
+	}
+	rows := make([]map[string]any, 0, len(ids))
+	for _, v := range ids {
+		rows = append(rows, grouped[fmt.Sprint(v)]...)
+	}
+	RenderOk(c, rows)
+}
+
+// generateAssociationHandlers 生成多对多关联的接口
+func generateAssociationHandlers(modelName string, relation ManyToMany, beforeCommitFunc ...gin.HandlerFunc) []RouteHandler {
+	idParam := ApiProperty{Name: "id", Type: "string", Required: true, Description: modelName + "的主键", Location: "query"}
+	idsParam := ApiProperty{Name: "ids", Type: "array", Required: true, Description: relation.Name + "的主键列表", Location: "body"}
+	bodyIdParam := idParam
+	bodyIdParam.Location = "body"
+
+	listResp := NewCodeMsgResponse("获取"+modelName+"关联的"+relation.Name, 200, "ok")
+	listSchema := &ApiProperty{Type: "array"}
+	if target, er := (Relation{Name: relation.Name, Resource: relation.Resource}).target(); er == nil {
+		listSchema.Fields = GenerateApiPropertiesFromStruct(reflect.New(reflect.TypeOf(target.entity).Elem()).Interface())
+	}
+	listResp.Content["data"] = MediaType{Schema: listSchema}
+	changeResp := NewCodeMsgResponse("修改"+modelName+"关联的"+relation.Name, 200, "ok")
+	changeResp.Content["data"] = MediaType{Schema: &ApiProperty{Type: "object", Fields: GenerateApiPropertiesFromStruct(AssociationResult{})}}
+
+	handler := func(action AssociationAction, method, name, description string, parameters []ApiProperty, response APIResponse) RouteHandler {
+		funcs := append(append([]gin.HandlerFunc{}, beforeCommitFunc...), DoAssociation(relation, action))
+		// 关联列表按列表接口处理，建立、解除、替换关联修改本资源，按更新接口处理
+		operation := PathUpdate
+		if action == AssociationList {
+			operation = PathList
+		}
+		return withOperation(GetRouteHandler(relation.Name+"/"+string(action), method, name, description, parameters, response, funcs...), operation)
+	}
+	return []RouteHandler{
+		handler(AssociationList, "GET", modelName+"关联"+relation.Name+"列表", "获取"+modelName+"关联的"+relation.Name, []ApiProperty{idParam}, listResp),
+		handler(AssociationLink, "POST", modelName+"关联"+relation.Name, "为"+modelName+"批量建立与"+relation.Name+"的关联，已存在的关联会被忽略", []ApiProperty{bodyIdParam, idsParam}, changeResp),
+		handler(AssociationUnlink, "POST", modelName+"解除关联"+relation.Name, "批量解除"+modelName+"与"+relation.Name+"的关联", []ApiProperty{bodyIdParam, idsParam}, changeResp),
+		handler(AssociationReplace, "POST", modelName+"替换关联"+relation.Name, "将"+modelName+"关联的"+relation.Name+"替换为 ids，在同一事务中完成", []ApiProperty{bodyIdParam, idsParam}, changeResp),
+	}
+}
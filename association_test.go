@@ -0,0 +1,113 @@
+package crud
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4/define"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManyToManyRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	idParam := []ConditionParam{{QueryName: "idEq", ColName: "id", Operation: define.OpEq}}
+	customers, er := NewCrud2("tagged_customers", &Customer{}, nil, []string{"id", "name"}, nil, nil, idParam, []string{"name"}, []string{"name"}, nil, idParam, nil,
+		WithManyToMany(ManyToMany{Name: "orders", JoinTable: "customer_orders", JoinLocal: "customer_id", JoinForeign: "order_id"}))
+	assert.NoError(t, er)
+
+	r := gin.New()
+	assert.NoError(t, customers.Register(r.Group("/api")))
+	paths := make(map[string]bool)
+	for _, route := range r.Routes() {
+		paths[route.Method+" "+route.Path] = true
+	}
+	for _, path := range []string{"GET /api/tagged_customers/orders/list", "POST /api/tagged_customers/orders/link", "POST /api/tagged_customers/orders/unlink", "POST /api/tagged_customers/orders/replace"} {
+		assert.True(t, paths[path], path)
+	}
+	docs := make(map[string]bool)
+	for _, doc := range globalAPIRegistry.GetAPIsByGroup("tagged_customers") {
+		docs[doc.Method+" "+doc.Path] = true
+	}
+	assert.True(t, docs["POST tagged_customers/orders/replace"])
+}
+
+func TestAssociationRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	parse := func(contentType, body string) (string, []any, error) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("POST", "/api/users/roles/link", strings.NewReader(body))
+		c.Request.Header.Set("Content-Type", contentType)
+		return associationRequest(c, true)
+	}
+
+	// 大于 1e7 的主键不会被格式化为科学计数法
+	id, ids, er := parse("application/json", `{"id":12345678,"ids":[1,2,2,23456789]}`)
+	assert.NoError(t, er)
+	assert.Equal(t, "12345678", id)
+	assert.Equal(t, []any{"1", "2", "23456789"}, ids)
+
+	_, _, er = parse("application/json", `{"id":3,"ids":"1"}`)
+	assert.EqualError(t, er, "ids must be an array")
+
+	// 表单中的一个或多个值
+	id, ids, er = parse("application/x-www-form-urlencoded", "id=3&ids=1&ids=2")
+	assert.NoError(t, er)
+	assert.Equal(t, "3", id)
+	assert.Equal(t, []any{"1", "2"}, ids)
+	_, ids, er = parse("application/x-www-form-urlencoded", "id=3&ids=5")
+	assert.NoError(t, er)
+	assert.Equal(t, []any{"5"}, ids)
+}
+
+func TestAssociationChangeHooks(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	joined := []driver.Value{int64(12345678)}
+	inserted := 0
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, int64, error) {
+		switch {
+		case strings.HasPrefix(query, "SELECT * FROM `customers`"):
+			return []string{"id", "name"}, [][]driver.Value{{int64(3), "Alice"}}, 0, nil
+		case strings.HasPrefix(query, "SELECT * FROM `customer_orders`"):
+			rows := make([][]driver.Value, 0, len(joined))
+			for _, v := range joined {
+				rows = append(rows, []driver.Value{int64(3), v})
+			}
+			return []string{"customer_id", "order_id"}, rows, 0, nil
+		case strings.HasPrefix(query, "INSERT INTO `customer_orders`"):
+			// 批量插入的列顺序不固定，按 SQL 中列出现的位置取 order_id
+			offset := 1
+			if strings.Index(query, "`order_id`") < strings.Index(query, "`customer_id`") {
+				offset = 0
+			}
+			for idx := offset; idx < len(args); idx += 2 {
+				joined = append(joined, args[idx])
+			}
+			inserted += len(args) / 2
+			return nil, nil, int64(len(args) / 2), nil
+		}
+		return nil, nil, 0, fmt.Errorf("unexpected query: %s", query)
+	})
+	sink := &memoryAuditSink{}
+	idParam := []ConditionParam{{QueryName: "idEq", ColName: "id", Operation: define.OpEq}}
+	customers, er := NewCrud2("tagged_customers", &Customer{}, db, []string{"id", "name"}, nil, nil, idParam, []string{"name"}, []string{"name"}, nil, idParam, nil,
+		WithManyToMany(ManyToMany{Name: "orders", JoinTable: "customer_orders", JoinLocal: "customer_id", JoinForeign: "order_id"}), WithAudit(sink))
+	assert.NoError(t, er)
+	r := gin.New()
+	assert.NoError(t, customers.Register(r.Group("/api")))
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/api/tagged_customers/orders/link", strings.NewReader(`{"id":3,"ids":[12345678,7]}`))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	assert.Contains(t, w.Body.String(), `"linked":1`)
+	// 已存在的关联不会重复插入
+	assert.Equal(t, 1, inserted)
+	if assert.Len(t, sink.logs, 1) {
+		assert.Equal(t, "3", sink.logs[0].RecordId)
+		assert.Equal(t, `[{"field":"orders","old":["12345678"],"new":["12345678","7"]}]`, sink.logs[0].Diff)
+	}
+}
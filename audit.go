@@ -0,0 +1,244 @@
+package crud
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4"
+)
+
+// AuditLog 审计日志，记录一行数据的一次变更
+type AuditLog struct {
+	ID        int64     `json:"id" gom:"id,@,auto"`
+	Resource  string    `json:"resource" gom:"resource"`
+	Table     string    `json:"table" gom:"table_name"`
+	Operation string    `json:"operation" gom:"operation"`
+	RecordId  string    `json:"recordId" gom:"record_id"`
+	ActorId   string    `json:"actorId" gom:"actor_id"`
+	ActorType string    `json:"actorType" gom:"actor_type"`
+	Method    string    `json:"method" gom:"method"`
+	Path      string    `json:"path" gom:"path"`
+	ClientIP  string    `json:"clientIp" gom:"client_ip"`
+	UserAgent string    `json:"userAgent" gom:"user_agent"`
+	RequestId string    `json:"requestId" gom:"request_id"`
+	Diff      string    `json:"diff" gom:"diff"` // 字段变更列表，JSON格式
+	CreatedAt time.Time `json:"createdAt" gom:"created_at"`
+}
+
+// AuditSink 审计日志的存储
+type AuditSink interface {
+	// Write 在写操作所在的事务中保存审计日志
+	Write(c *gin.Context, tx *gom.Chain, log AuditLog) error
+	// History 按时间倒序分页查询资源中一行数据的审计日志，返回当前页和总数
+	History(resource string, recordId string, pageNum, pageSize int) ([]AuditLog, int64, error)
+}
+
+// TableAuditSink 将审计日志保存到数据库表中
+type TableAuditSink struct {
+	DB    *gom.DB
+	Table string
+}
+
+// NewTableAuditSink 创建基于数据库表的审计日志存储，table 为空时使用 crud_audit_log
+func NewTableAuditSink(db *gom.DB, table string) *TableAuditSink {
+	if table == "" {
+		table = "crud_audit_log"
+	}
+	return &TableAuditSink{DB: db, Table: table}
+}
+
+func (s *TableAuditSink) Write(c *gin.Context, tx *gom.Chain, log AuditLog) error {
+	return insertRow(s.DB, tx, s.Table, map[string]any{
+		"resource":   log.Resource,
+		"table_name": log.Table,
+		"operation":  log.Operation,
+		"record_id":  log.RecordId,
+		"actor_id":   log.ActorId,
+		"actor_type": log.ActorType,
+		"method":     log.Method,
+		"path":       log.Path,
+		"client_ip":  log.ClientIP,
+		"user_agent": log.UserAgent,
+		"request_id": log.RequestId,
+		"diff":       log.Diff,
+		"created_at": log.CreatedAt,
+	})
+}
+
+func (s *TableAuditSink) History(resource string, recordId string, pageNum, pageSize int) ([]AuditLog, int64, error) {
+	chain := s.DB.Chain().Table(s.Table).Eq("resource", resource).Eq("record_id", recordId)
+	total, er := chain.Count()
+	if er != nil {
+		return nil, 0, er
+	}
+	logs := make([]AuditLog, 0)
+	result := chain.OrderByDesc("id").Page(pageNum, pageSize).List()
+	if result.Error != nil {
+		return nil, 0, result.Error
+	}
+	if er := result.Into(&logs); er != nil {
+		return nil, 0, er
+	}
+	return logs, total, nil
+}
+
+// AuditHook 将每一行数据的变更写入审计日志的变更钩子
+type AuditHook struct {
+	Sink AuditSink
+}
+
+func (h AuditHook) InTx(c *gin.Context, tx *gom.Chain, change *Change) error {
+	diff, er := json.Marshal(change.Diff())
+	if er != nil {
+		return er
+	}
+	return h.Sink.Write(c, tx, AuditLog{
+		Resource:  change.Resource,
+		Table:     change.Table,
+		Operation: string(change.Operation),
+		RecordId:  keyString(change.Keys),
+		ActorId:   change.Actor.UserId,
+		ActorType: change.Actor.UserType,
+		Method:    change.Request.Method,
+		Path:      change.Request.Path,
+		ClientIP:  change.Request.ClientIP,
+		UserAgent: change.Request.UserAgent,
+		RequestId: change.Request.RequestId,
+		Diff:      string(diff),
+		CreatedAt: change.Time,
+	})
+}
+
+func (h AuditHook) AfterCommit(c *gin.Context, change *Change) {}
+
+// keyString 将主键转换为字符串，单个主键直接使用其值
+func keyString(keys map[string]any) string {
+	if len(keys) == 1 {
+		for _, v := range keys {
+			return fmt.Sprint(normalizeValue(v))
+		}
+	}
+	data, _ := json.Marshal(keys)
+	return string(data)
+}
+
+// WithAudit 开启审计日志，新增、更新、删除会在同一事务中记录变更，并注册 history 接口
+func WithAudit(sink AuditSink) Option {
+	return func(o *Options) {
+		o.Audit = sink
+		o.Hooks = append(o.Hooks, AuditHook{Sink: sink})
+	}
+}
+
+// QueryHistory 分页查询一行数据的审计日志，参数 id 为主键值，子资源只能查询父资源下的数据
+// 变更中只保留调用者可读的字段，字段的值按资源的配置解密和脱敏
+func QueryHistory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		opts, ok := GetContextOptions(c)
+		if !ok || opts.Audit == nil {
+			RenderErr2(c, 500, "audit is not enabled")
+			return
+		}
+		id := c.Query("id")
+		if id == "" {
+			RenderErrs(c, errors.New("id could not be empty"))
+			return
+		}
+		if !requireParent(c, id) {
+			return
+		}
+		pageNum, pageSize := getContextPageNumber(c), getContextPageSize(c)
+		logs, total, er := opts.Audit.History(opts.Resource, id, pageNum, pageSize)
+		if er != nil {
+			RenderErr2(c, 500, er.Error())
+			return
+		}
+		code, er := visibleHistory(c, opts, logs)
+		if er != nil {
+			RenderErr2(c, code, er.Error())
+			return
+		}
+		RenderOk(c, newPageInfo(pageNum, pageSize, total, logs))
+	}
+}
+
+// visibleHistory 去掉审计日志中调用者不可读的字段，并解密和脱敏变更前后的值，出错时返回响应码
+func visibleHistory(c *gin.Context, opts *Options, logs []AuditLog) (int, error) {
+	identities := callerIdentities(c)
+	for idx := range logs {
+		var diffs []FieldDiff
+		if er := json.Unmarshal([]byte(logs[idx].Diff), &diffs); er != nil {
+			return 500, er
+		}
+		visible := make([]FieldDiff, 0, len(diffs))
+		before, after := make(map[string]any), make(map[string]any)
+		for _, diff := range diffs {
+			if !opts.CanRead(diff.Field, identities...) {
+				continue
+			}
+			visible = append(visible, diff)
+			before[diff.Field], after[diff.Field] = diff.Old, diff.New
+		}
+		if er := DecryptData(c, []map[string]any{before, after}); er != nil {
+			return 500, er
+		}
+		if er := MaskData(c, []map[string]any{before, after}); er != nil {
+			return 403, er
+		}
+		for pos := range visible {
+			visible[pos].Old, visible[pos].New = before[visible[pos].Field], after[visible[pos].Field]
+		}
+		data, er := json.Marshal(visible)
+		if er != nil {
+			return 500, er
+		}
+		logs[idx].Diff = string(data)
+	}
+	return 0, nil
+}
+
+// newPageInfo 按页码、每页数量和总数生成分页结果
+func newPageInfo(pageNum, pageSize int, total int64, list any) *gom.PageInfo {
+	pages := 0
+	if pageSize > 0 {
+		pages = int((total + int64(pageSize) - 1) / int64(pageSize))
+	}
+	return &gom.PageInfo{
+		PageNum:     pageNum,
+		PageSize:    pageSize,
+		Total:       total,
+		Pages:       pages,
+		HasPrev:     pageNum > 1,
+		HasNext:     pageNum < pages,
+		List:        list,
+		IsFirstPage: pageNum == 1,
+		IsLastPage:  pageNum >= pages,
+	}
+}
+
+func GetHistoryHandler(name, description string, parameters []ApiProperty, response APIResponse, beforeCommitFunc ...gin.HandlerFunc) RouteHandler {
+	return withOperation(GetRouteHandler(string(PathHistory), "GET", name, description, parameters, response, append(beforeCommitFunc, QueryHistory())...), PathDetail)
+}
+
+func generateHistoryParameters() []ApiProperty {
+	return []ApiProperty{
+		{Name: "id", Type: "string", Required: true, Description: "主键值", Location: "query"},
+	}
+}
+
+func generateHistoryPageParameters() []ApiProperty {
+	return append(generateHistoryParameters(),
+		ApiProperty{Name: "pageNum", Type: "integer", Description: "页码，默认为 1", Location: "query"},
+		ApiProperty{Name: "pageSize", Type: "integer", Description: "每页数量", Location: "query"},
+	)
+}
+
+func generateHistoryResponse(modelName string) APIResponse {
+	resp := NewCodeMsgResponse("获取"+modelName+"变更历史", 200, "ok")
+	pageInfo := GeneratePageInfoApiProperty(GenerateApiPropertiesFromStruct(AuditLog{}))
+	resp.Content["data"] = MediaType{Schema: &pageInfo}
+	return resp
+}
@@ -0,0 +1,122 @@
+package crud
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryAuditSink struct {
+	logs []AuditLog
+}
+
+func (s *memoryAuditSink) Write(c *gin.Context, tx *gom.Chain, log AuditLog) error {
+	s.logs = append(s.logs, log)
+	return nil
+}
+
+func (s *memoryAuditSink) History(resource string, recordId string, pageNum, pageSize int) ([]AuditLog, int64, error) {
+	result := make([]AuditLog, 0)
+	for idx := len(s.logs) - 1; idx >= 0; idx-- {
+		if s.logs[idx].Resource == resource && s.logs[idx].RecordId == recordId {
+			result = append(result, s.logs[idx])
+		}
+	}
+	total := int64(len(result))
+	start := (pageNum - 1) * pageSize
+	if start > len(result) {
+		start = len(result)
+	}
+	end := start + pageSize
+	if end > len(result) {
+		end = len(result)
+	}
+	return result[start:end], total, nil
+}
+
+func TestDiffFields(t *testing.T) {
+	diffs := DiffFields(
+		map[string]any{"id": int64(1), "name": []byte("old"), "age": int64(10)},
+		map[string]any{"id": int64(1), "name": "new", "age": int64(10), "email": "a@b.c"},
+	)
+	assert.Equal(t, []FieldDiff{
+		{Field: "email", Old: nil, New: "a@b.c"},
+		{Field: "name", Old: "old", New: "new"},
+	}, diffs)
+}
+
+func TestAuditHook(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/api/users/update", nil)
+	c.Request.Header.Set("X-Request-Id", "req-1")
+	c.Set("userId", "u1")
+	c.Set("userType", "admin")
+
+	sink := &memoryAuditSink{}
+	opts := NewOptions(&TestModel{}, WithAudit(sink))
+	opts.Resource = "users"
+	change := newChange(c, opts, "users", ChangeUpdate)
+	change.Keys = map[string]any{"id": int64(7)}
+	change.Before = map[string]any{"id": int64(7), "name": "old"}
+	change.After = map[string]any{"id": int64(7), "name": "new"}
+
+	for _, hook := range opts.Hooks {
+		assert.NoError(t, hook.InTx(c, nil, change))
+	}
+	logs, total, err := sink.History("users", "7", 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	assert.Len(t, logs, 1)
+	assert.Equal(t, "u1", logs[0].ActorId)
+	assert.Equal(t, "req-1", logs[0].RequestId)
+	assert.Equal(t, "update", logs[0].Operation)
+
+	var diffs []FieldDiff
+	assert.NoError(t, json.Unmarshal([]byte(logs[0].Diff), &diffs))
+	assert.Equal(t, []FieldDiff{{Field: "name", Old: "old", New: "new"}}, diffs)
+}
+
+func TestQueryHistoryVisibleFields(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	sink := &memoryAuditSink{}
+	for idx := 0; idx < 3; idx++ {
+		diff, _ := json.Marshal([]FieldDiff{
+			{Field: "name", Old: "old", New: "new"},
+			{Field: "phone", Old: "13800001111", New: "13900002222"},
+			{Field: "salary", Old: 100, New: 200},
+		})
+		sink.logs = append(sink.logs, AuditLog{Resource: "users", RecordId: "7", Diff: string(diff)})
+	}
+	opts := NewOptions(&TestModel{}, WithAudit(sink), WithMask("phone", MaskPhone),
+		WithFieldPermissions(FieldPermission{Field: "salary", Readable: []string{"hr"}}))
+	opts.Resource = "users"
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/api/users/history?id=7&pageSize=2", nil)
+	c.Set("userType", "staff")
+	SetContextOptions(opts)(c)
+	DefaultGenPageFromRstQuery(c)
+	QueryHistory()(c)
+
+	var resp struct {
+		Data struct {
+			Total int64      `json:"total"`
+			List  []AuditLog `json:"list"`
+		} `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, int64(3), resp.Data.Total)
+	assert.Len(t, resp.Data.List, 2)
+	var diffs []FieldDiff
+	assert.NoError(t, json.Unmarshal([]byte(resp.Data.List[0].Diff), &diffs))
+	assert.Equal(t, []FieldDiff{
+		{Field: "name", Old: "old", New: "new"},
+		{Field: "phone", Old: "138****1111", New: "139****2222"},
+	}, diffs)
+}
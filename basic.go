@@ -18,8 +18,19 @@ type CodeMsg struct {
 }
 
 // RenderJson 渲染JSON响应
+// 成功时使用处理链中设置的 HTTP 状态码，如 REST 风格下新增返回 201、删除返回 204
 func RenderJson(c *gin.Context, code int, msg string, data interface{}) {
-	c.JSON(http.StatusOK, CodeMsg{
+	status := http.StatusOK
+	if code == 200 {
+		if s, ok := GetContextAny(c, "successStatus"); ok {
+			status = s.(int)
+		}
+	}
+	if status == http.StatusNoContent {
+		c.AbortWithStatus(status)
+		return
+	}
+	c.JSON(status, CodeMsg{
 		Code: code,
 		Msg:  msg,
 		Data: data,
@@ -131,6 +142,24 @@ func (s Server) ListenAndServe() error {
 	return s.server.ListenAndServe()
 }
 
+// requestBody 读取请求体并缓存，同一请求中绑定实体和解析条件可以重复读取
+// 缓存记录所属的请求，c.Request 被替换后重新读取
+func requestBody(c *gin.Context) ([]byte, error) {
+	if cached, ok := c.Get(gin.BodyBytesKey); ok {
+		owner, tracked := GetContextAny(c, "bodyRequest")
+		if bbs, ok := cached.([]byte); ok && (!tracked || owner == c.Request) {
+			return bbs, nil
+		}
+	}
+	bbs, er := io.ReadAll(c.Request.Body)
+	if er != nil {
+		return nil, er
+	}
+	c.Set(gin.BodyBytesKey, bbs)
+	SetContextAny("bodyRequest", c.Request)(c)
+	return bbs, nil
+}
+
 func GetMapFromRst(c *gin.Context) (map[string]any, error) {
 	var maps map[string]interface{}
 	var er error
@@ -152,7 +181,7 @@ func GetMapFromRst(c *gin.Context) (map[string]any, error) {
 			}
 
 		} else if strings.Contains(contentType, "application/json") {
-			bbs, er := io.ReadAll(c.Request.Body)
+			bbs, er := requestBody(c)
 			if er != nil {
 				return nil, er
 			}
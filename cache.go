@@ -0,0 +1,350 @@
+package crud
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// HeaderCache 响应头，标记列表和详情查询是否命中缓存
+const HeaderCache = "X-Cache"
+
+// CacheStore 查询缓存的存储
+// 每个表有一个版本号，缓存键中包含版本号，写操作成功后递增版本号使该表的所有缓存失效
+type CacheStore interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Generation 获取表当前的版本号
+	Generation(ctx context.Context, table string) (int64, error)
+	// Invalidate 递增表的版本号
+	Invalidate(ctx context.Context, table string) error
+}
+
+// WithCache 为列表和详情查询开启读缓存，ttl 为缓存的有效期
+func WithCache(store CacheStore, ttl time.Duration) Option {
+	return func(o *Options) {
+		o.Cache = store
+		o.CacheTTL = ttl
+	}
+}
+
+// memoryCacheItem 内存缓存的一项
+type memoryCacheItem struct {
+	value    []byte
+	expireAt time.Time
+}
+
+// MemoryCacheStore 基于内存的缓存，适用于单进程部署和测试
+type MemoryCacheStore struct {
+	maxEntries int
+	mu         sync.Mutex
+	items      map[string]memoryCacheItem
+	gens       map[string]int64
+}
+
+// NewMemoryCacheStore 创建内存缓存，maxEntries 大于0时限制缓存的数量
+func NewMemoryCacheStore(maxEntries int) *MemoryCacheStore {
+	return &MemoryCacheStore{
+		maxEntries: maxEntries,
+		items:      make(map[string]memoryCacheItem),
+		gens:       make(map[string]int64),
+	}
+}
+
+func (s *MemoryCacheStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.items[key]
+	if !ok {
+		return nil, false, nil
+	}
+	if !item.expireAt.IsZero() && time.Now().After(item.expireAt) {
+		delete(s.items, key)
+		return nil, false, nil
+	}
+	return item.value, true, nil
+}
+
+func (s *MemoryCacheStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.maxEntries > 0 && len(s.items) >= s.maxEntries {
+		s.evictLocked()
+	}
+	item := memoryCacheItem{value: value}
+	if ttl > 0 {
+		item.expireAt = time.Now().Add(ttl)
+	}
+	s.items[key] = item
+	return nil
+}
+
+// evictLocked 清理过期的缓存，仍然超出上限时随机删除
+func (s *MemoryCacheStore) evictLocked() {
+	now := time.Now()
+	for key, item := range s.items {
+		if !item.expireAt.IsZero() && now.After(item.expireAt) {
+			delete(s.items, key)
+		}
+	}
+	for key := range s.items {
+		if len(s.items) < s.maxEntries {
+			return
+		}
+		delete(s.items, key)
+	}
+}
+
+func (s *MemoryCacheStore) Generation(ctx context.Context, table string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.gens[table], nil
+}
+
+func (s *MemoryCacheStore) Invalidate(ctx context.Context, table string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gens[table]++
+	return nil
+}
+
+// RedisCacheStore 基于 Redis 的缓存，多个进程共享缓存和版本号
+type RedisCacheStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisCacheStore 创建基于 Redis 的缓存，prefix 为空时使用 "crud:cache:"
+func NewRedisCacheStore(client *redis.Client, prefix string) *RedisCacheStore {
+	if prefix == "" {
+		prefix = "crud:cache:"
+	}
+	return &RedisCacheStore{client: client, prefix: prefix}
+}
+
+func (s *RedisCacheStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, er := s.client.Get(ctx, s.prefix+key).Bytes()
+	if errors.Is(er, redis.Nil) {
+		return nil, false, nil
+	}
+	if er != nil {
+		return nil, false, er
+	}
+	return value, true, nil
+}
+
+func (s *RedisCacheStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return s.client.Set(ctx, s.prefix+key, value, ttl).Err()
+}
+
+func (s *RedisCacheStore) Generation(ctx context.Context, table string) (int64, error) {
+	gen, er := s.client.Get(ctx, s.prefix+"gen:"+table).Int64()
+	if errors.Is(er, redis.Nil) {
+		return 0, nil
+	}
+	return gen, er
+}
+
+func (s *RedisCacheStore) Invalidate(ctx context.Context, table string) error {
+	return s.client.Incr(ctx, s.prefix+"gen:"+table).Err()
+}
+
+// flightCall 正在执行的查询
+type flightCall struct {
+	done  chan struct{}
+	value []byte
+	ok    bool
+}
+
+// flightGroup 合并相同缓存键的并发查询，只有第一个请求访问数据库
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// do 执行 fn 或等待正在执行的相同查询，shared 表示结果来自其他请求
+func (g *flightGroup) do(ctx context.Context, key string, fn func() ([]byte, bool)) (value []byte, ok bool, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	if call, running := g.calls[key]; running {
+		g.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.value, call.ok, true
+		case <-ctx.Done():
+			return nil, false, true
+		}
+	}
+	call := &flightCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(call.done)
+	}()
+	call.value, call.ok = fn()
+	return call.value, call.ok, false
+}
+
+var cacheFlight flightGroup
+
+// cacheWriter 记录响应内容的 ResponseWriter
+type cacheWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *cacheWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *cacheWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// contextTableName 请求所查询的表
+func contextTableName(c *gin.Context) (string, bool) {
+	if schema, ok := GetContextTable(c); ok {
+		return schema.Info.TableName, true
+	}
+	if i, ok := GetContextEntity(c); ok {
+		return getTableName(i), true
+	}
+	return "", false
+}
+
+// cacheKey 按表、版本号、查询条件、列、分页和影响输出的调用方信息生成缓存键
+func cacheKey(c *gin.Context, opts *Options, op string, table string, gen int64) (string, error) {
+	cond, _ := getScopedCondition(c)
+	mask, er := shouldMask(c, opts)
+	if er != nil {
+		return "", er
+	}
+	parts := map[string]any{
+		"op":       op,
+		"cond":     cond,
+		"cols":     getSelectColumns(c),
+		"pageNum":  getContextPageNumber(c),
+		"pageSize": getContextPageSize(c),
+		"mask":     mask,
+	}
+	if tenant, ok := GetContextTenant(c); ok {
+		parts["tenant"] = tenant
+	}
+	if len(opts.FieldPermissions) > 0 {
+		parts["caller"] = callerIdentities(c)
+	}
+	data, er := json.Marshal(parts)
+	if er != nil {
+		return "", er
+	}
+	sum := sha256.Sum256(data)
+	return table + ":" + strconv.FormatInt(gen, 10) + ":" + hex.EncodeToString(sum[:]), nil
+}
+
+// CacheQuery 查询的读缓存，命中时直接输出缓存的响应，未命中时执行查询并缓存成功的响应
+// 缓存读写失败时直接查询数据库；带 expand 参数的请求包含关联表的数据，关联表的写操作不会使其失效，不缓存
+func CacheQuery(op string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		opts, ok := GetContextOptions(c)
+		if !ok || opts.Cache == nil || len(expandNames(c)) > 0 {
+			return
+		}
+		table, ok := contextTableName(c)
+		if !ok {
+			return
+		}
+		ctx := requestContext(c)
+		gen, er := opts.Cache.Generation(ctx, table)
+		if er != nil {
+			return
+		}
+		key, er := cacheKey(c, opts, op, table, gen)
+		if er != nil {
+			return
+		}
+		if body, hit, er := opts.Cache.Get(ctx, key); er == nil && hit {
+			cachedETag(c, opts, key)
+			renderCached(c, body)
+			return
+		}
+
+		body, ok, shared := cacheFlight.do(ctx, key, func() ([]byte, bool) {
+			writer := &cacheWriter{ResponseWriter: c.Writer}
+			c.Writer = writer
+			c.Header(HeaderCache, "MISS")
+			c.Next()
+			c.Writer = writer.ResponseWriter
+			var resp CodeMsg
+			if json.Unmarshal(writer.body.Bytes(), &resp) != nil || resp.Code != 200 {
+				return nil, false
+			}
+			body := writer.body.Bytes()
+			_ = opts.Cache.Set(context.WithoutCancel(ctx), key, body, opts.CacheTTL)
+			if etag, ok := GetContextAny(c, "etag"); ok {
+				_ = opts.Cache.Set(context.WithoutCancel(ctx), key+":etag", []byte(etag.(string)), opts.CacheTTL)
+			}
+			return body, true
+		})
+		if shared && ok {
+			cachedETag(c, opts, key)
+			renderCached(c, body)
+		}
+		// 其他请求的查询失败时，由本次请求自己查询
+	}
+}
+
+// cachedETag 读取与缓存的详情一起保存的 ETag，命中缓存时不再查询数据库计算
+func cachedETag(c *gin.Context, opts *Options, key string) {
+	if !opts.ETag {
+		return
+	}
+	if etag, hit, er := opts.Cache.Get(requestContext(c), key+":etag"); er == nil && hit {
+		SetContextAny("etag", string(etag))(c)
+	}
+}
+
+// renderCached 输出缓存的响应
+func renderCached(c *gin.Context, body []byte) {
+	c.Header(HeaderCache, "HIT")
+	c.Data(200, "application/json; charset=utf-8", body)
+	c.Abort()
+}
+
+// applyCache 为列表和详情查询加入读缓存
+func applyCache(opts *Options, handlers []RouteHandler) {
+	if opts.Cache == nil {
+		return
+	}
+	for idx := range handlers {
+		switch DefaultRoutePath(handlers[idx].Path) {
+		case PathList, PathDetail:
+			handlers[idx].Handlers = insertBeforeLast(handlers[idx].Handlers, CacheQuery(handlers[idx].Path))
+		}
+	}
+}
+
+// invalidateCache 写操作成功后使表的缓存失效，请求已结束时仍然执行
+func invalidateCache(c *gin.Context, table string) {
+	opts, ok := GetContextOptions(c)
+	if !ok || opts.Cache == nil {
+		return
+	}
+	_ = opts.Cache.Invalidate(context.WithoutCancel(requestContext(c)), table)
+}
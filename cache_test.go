@@ -0,0 +1,96 @@
+package crud
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryCacheStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryCacheStore(2)
+	assert.NoError(t, store.Set(ctx, "a", []byte("1"), time.Millisecond))
+	assert.NoError(t, store.Set(ctx, "b", []byte("2"), 0))
+	time.Sleep(5 * time.Millisecond)
+	_, ok, _ := store.Get(ctx, "a")
+	assert.False(t, ok)
+	value, ok, _ := store.Get(ctx, "b")
+	assert.True(t, ok)
+	assert.Equal(t, "2", string(value))
+
+	assert.NoError(t, store.Invalidate(ctx, "notes"))
+	gen, _ := store.Generation(ctx, "notes")
+	assert.Equal(t, int64(1), gen)
+}
+
+func TestFlightGroup(t *testing.T) {
+	var group flightGroup
+	var calls int32
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	for n := 0; n < 5; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, ok, _ := group.do(context.Background(), "k", func() ([]byte, bool) {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				return []byte("v"), true
+			})
+			assert.True(t, ok)
+			assert.Equal(t, "v", string(value))
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestCacheQuery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	schema, _ := newTableSchema(testTableInfo("cached_notes", true))
+	opts := &Options{}
+	WithCache(NewMemoryCacheStore(0), time.Minute)(opts)
+
+	queries := 0
+	r := gin.New()
+	r.GET("/notes/list", SetContextOptions(opts), SetContextTable(schema), CacheQuery(string(PathList)), func(c *gin.Context) {
+		queries++
+		RenderOk(c, queries)
+	})
+	r.POST("/notes/add", SetContextOptions(opts), func(c *gin.Context) {
+		invalidateCache(c, "cached_notes")
+		RenderOk(c, nil)
+	})
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/notes/list", nil))
+		return w
+	}
+
+	assert.Equal(t, "MISS", get().Header().Get(HeaderCache))
+	w := get()
+	assert.Equal(t, "HIT", w.Header().Get(HeaderCache))
+	assert.JSONEq(t, `{"code":200,"msg":"ok","data":1}`, w.Body.String())
+
+	// 写操作之后缓存失效
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/notes/add", nil))
+	w = get()
+	assert.Equal(t, "MISS", w.Header().Get(HeaderCache))
+	assert.JSONEq(t, `{"code":200,"msg":"ok","data":2}`, w.Body.String())
+
+	// 加载关联数据的请求不缓存
+	for range 2 {
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/notes/list?expand=author", nil))
+		assert.Empty(t, w.Header().Get(HeaderCache))
+	}
+	assert.Equal(t, 4, queries)
+}
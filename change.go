@@ -0,0 +1,277 @@
+package crud
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4"
+	"github.com/kmlixh/gom/v4/define"
+)
+
+// ChangeType 数据变更类型
+type ChangeType string
+
+const (
+	ChangeInsert ChangeType = "insert"
+	ChangeUpdate ChangeType = "update"
+	ChangeDelete ChangeType = "delete"
+)
+
+// Actor 发起变更的用户
+type Actor struct {
+	UserId   string `json:"userId"`
+	UserType string `json:"userType"`
+}
+
+// RequestMeta 发起变更的请求信息
+type RequestMeta struct {
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	ClientIP  string `json:"clientIp"`
+	UserAgent string `json:"userAgent"`
+	RequestId string `json:"requestId"`
+}
+
+// Change 一行数据的变更
+type Change struct {
+	Resource  string         `json:"resource"`  // 资源名称
+	Table     string         `json:"table"`     // 表名
+	Operation ChangeType     `json:"operation"` // 变更类型
+	Keys      map[string]any `json:"keys"`      // 主键
+	Before    map[string]any `json:"before"`    // 变更前的数据，新增时为空
+	After     map[string]any `json:"after"`     // 变更后的数据，删除时为空
+	Actor     Actor          `json:"actor"`     // 发起变更的用户
+	Request   RequestMeta    `json:"request"`   // 发起变更的请求
+	Time      time.Time      `json:"time"`      // 变更时间
+}
+
+// ChangeHook 数据变更钩子
+type ChangeHook interface {
+	// InTx 在写操作所在的事务中调用，返回错误时整个事务回滚
+	InTx(c *gin.Context, tx *gom.Chain, change *Change) error
+	// AfterCommit 在事务提交成功后调用
+	AfterCommit(c *gin.Context, change *Change)
+}
+
+// WithChangeHooks 添加数据变更钩子，配置钩子后写操作会在事务中执行
+func WithChangeHooks(hooks ...ChangeHook) Option {
+	return func(o *Options) {
+		o.Hooks = append(o.Hooks, hooks...)
+	}
+}
+
+// FieldDiff 单个字段的变更
+type FieldDiff struct {
+	Field string `json:"field"`
+	Old   any    `json:"old"`
+	New   any    `json:"new"`
+}
+
+// Diff 计算变更前后有变化的字段，按字段名排序
+func (ch *Change) Diff() []FieldDiff {
+	return DiffFields(ch.Before, ch.After)
+}
+
+// DiffFields 计算两行数据中有变化的字段，按字段名排序
+func DiffFields(before, after map[string]any) []FieldDiff {
+	names := make(map[string]bool)
+	for k := range before {
+		names[k] = true
+	}
+	for k := range after {
+		names[k] = true
+	}
+	diffs := make([]FieldDiff, 0)
+	for name := range names {
+		oldVal, newVal := normalizeValue(before[name]), normalizeValue(after[name])
+		if !reflect.DeepEqual(oldVal, newVal) {
+			diffs = append(diffs, FieldDiff{Field: name, Old: oldVal, New: newVal})
+		}
+	}
+	sort.Slice(diffs, func(a, b int) bool {
+		return diffs[a].Field < diffs[b].Field
+	})
+	return diffs
+}
+
+func normalizeValue(v any) any {
+	if b, ok := v.([]byte); ok {
+		return string(b)
+	}
+	return v
+}
+
+// primaryKeyOf 获取实体的主键列名，默认为 id
+func primaryKeyOf(i any) string {
+	if transfer := define.GetTransfer(i); transfer != nil && transfer.PrimaryKey != nil {
+		return transfer.PrimaryKey.Column
+	}
+	return "id"
+}
+
+func newChange(c *gin.Context, opts *Options, table string, op ChangeType) *Change {
+	return &Change{
+		Resource:  opts.Resource,
+		Table:     table,
+		Operation: op,
+		Actor: Actor{
+			UserId:   GetContextUserId(c),
+			UserType: GetContextUserType(c),
+		},
+		Request: RequestMeta{
+			Method:    c.Request.Method,
+			Path:      c.Request.URL.Path,
+			ClientIP:  c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+			RequestId: c.GetHeader("X-Request-Id"),
+		},
+		Time: time.Now(),
+	}
+}
+
+// queryRows 在 chain 所在的连接或事务中按条件查询整行数据
+func queryRows(db *gom.DB, chain *gom.Chain, table string, cnd *define.Condition, limit int) ([]map[string]any, error) {
+	conds := make([]*define.Condition, 0, 1)
+	if cnd != nil {
+		conds = append(conds, cnd)
+	}
+	sqlStr, args := db.Factory.BuildSelect(table, nil, conds, "", limit, 0)
+	result := chain.RawQuery(sqlStr, args...)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	for _, row := range result.Data {
+		for k, v := range row {
+			row[k] = normalizeValue(v)
+		}
+	}
+	return result.Data, nil
+}
+
+// insertRow 在 chain 所在的连接或事务中插入一行数据
+func insertRow(db *gom.DB, chain *gom.Chain, table string, fields map[string]any) error {
+	order := make([]string, 0, len(fields))
+	for k := range fields {
+		order = append(order, k)
+	}
+	sort.Strings(order)
+	sqlStr, args := db.Factory.BuildInsert(table, fields, order)
+	result := chain.RawExecute(sqlStr, args...)
+	return result.Error
+}
+
+// runMutation 执行写操作，配置了变更钩子时在事务中执行并收集每一行的变更
+// cnd 为写操作影响的数据的条件，新增时为空，insertKey 为新增时已知的主键值
+func runMutation(c *gin.Context, db *gom.DB, table string, pk string, op ChangeType, cnd *define.Condition, insertKey any, exec func(chain *gom.Chain) *define.Result) *define.Result {
+	if op != ChangeInsert && c.GetHeader("If-Match") != "" {
+		// 先在同一事务中校验客户端持有的 ETag
+		write := exec
+		exec = func(chain *gom.Chain) *define.Result {
+			if er := checkIfMatch(c, db, chain, table, cnd); er != nil {
+				return &define.Result{Error: er}
+			}
+			return write(chain)
+		}
+	}
+	result := mutate(c, db, table, pk, op, cnd, insertKey, exec)
+	if result.Error == nil {
+		invalidateCache(c, table)
+	}
+	return result
+}
+
+func mutate(c *gin.Context, db *gom.DB, table string, pk string, op ChangeType, cnd *define.Condition, insertKey any, exec func(chain *gom.Chain) *define.Result) *define.Result {
+	// 写操作之后同一请求中的查询读主库，避免读到从库上尚未同步的数据
+	ReadFromPrimary()(c)
+	opts, ok := GetContextOptions(c)
+	if !ok || len(opts.Hooks) == 0 {
+		ctx := requestContext(c)
+		if er := ctx.Err(); er != nil {
+			return &define.Result{Error: er}
+		}
+		_, timed := ctx.Deadline()
+		if !timed && c.GetHeader("If-Match") == "" {
+			// 单条语句不需要事务，同样以请求的 context 执行
+			bound, release, er := requestDB(ctx, db)
+			if er != nil {
+				return &define.Result{Error: er}
+			}
+			defer release()
+			return exec(bound.Chain())
+		}
+		// 设置了超时时间或需要校验 If-Match 时在事务中执行
+		var result *define.Result
+		er := transaction(c, db, func(tx *gom.Chain) error {
+			result = exec(tx)
+			return result.Error
+		})
+		if er != nil && (result == nil || result.Error == nil) {
+			return &define.Result{Error: er}
+		}
+		return result
+	}
+	var result *define.Result
+	changes := make([]*Change, 0)
+	er := transaction(c, db, func(tx *gom.Chain) error {
+		var before []map[string]any
+		if op != ChangeInsert {
+			rows, er := queryRows(db, tx, table, cnd, 0)
+			if er != nil {
+				return er
+			}
+			before = rows
+		}
+		result = exec(tx)
+		if result.Error != nil {
+			return result.Error
+		}
+		if op == ChangeInsert {
+			key := insertKey
+			if result.ID != 0 {
+				key = result.ID
+			}
+			change := newChange(c, opts, table, op)
+			change.Keys = map[string]any{pk: key}
+			changes = append(changes, change)
+		} else {
+			for _, row := range before {
+				change := newChange(c, opts, table, op)
+				change.Keys = map[string]any{pk: row[pk]}
+				change.Before = row
+				changes = append(changes, change)
+			}
+		}
+		for _, change := range changes {
+			if op != ChangeDelete {
+				rows, er := queryRows(db, tx, table, define.Eq(pk, change.Keys[pk]), 1)
+				if er != nil {
+					return er
+				}
+				if len(rows) > 0 {
+					change.After = rows[0]
+				}
+			}
+			for _, hook := range opts.Hooks {
+				if er := hook.InTx(c, tx, change); er != nil {
+					return fmt.Errorf("change hook failed: %w", er)
+				}
+			}
+		}
+		return nil
+	})
+	if er != nil {
+		if result != nil && result.Error != nil {
+			return result
+		}
+		return &define.Result{Error: er}
+	}
+	for _, change := range changes {
+		for _, hook := range opts.Hooks {
+			hook.AfterCommit(c, change)
+		}
+	}
+	return result
+}
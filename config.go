@@ -0,0 +1,335 @@
+package crud
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4"
+	"gopkg.in/yaml.v3"
+)
+
+// ResourceConfig 声明式的资源配置，可以从 YAML 或 JSON 文件加载
+type ResourceConfig struct {
+	Resources []ResourceSpec `yaml:"resources" json:"resources"`
+}
+
+// ResourceSpec 单个资源的配置
+//
+//	resources:
+//	  - table: users
+//	    route: users
+//	    query: [id, name, email]
+//	    insert: [name, email]
+//	    update: [name]
+//	    conditions: {name: [Eq, Like], id: [Eq, In]}
+//	    operations: [list, detail, add, update]
+//	    pageSize: 20
+//	    maxPageSize: 100
+//	    auth: {"*": [], add: [admin], update: [admin]}
+type ResourceSpec struct {
+	Table       string              `yaml:"table" json:"table"`             // 表名
+	Route       string              `yaml:"route" json:"route"`             // 路由名称，默认为表名
+	Query       []string            `yaml:"query" json:"query"`             // 列表查询的列，为空表示所有列
+	Detail      []string            `yaml:"detail" json:"detail"`           // 详情查询的列，为空时与 query 相同
+	Insert      []string            `yaml:"insert" json:"insert"`           // 新增时可写入的列，为空表示除自增列外的所有列
+	Update      []string            `yaml:"update" json:"update"`           // 更新时可写入的列，为空表示除主键外的所有列
+	Conditions  map[string][]string `yaml:"conditions" json:"conditions"`   // 查询条件，列名 -> 操作(Eq、NotEq、Gt、Like、In 等)，查询参数名为 列名+操作
+	Operations  []string            `yaml:"operations" json:"operations"`   // 开放的接口，为空表示全部开放
+	PageSize    int                 `yaml:"pageSize" json:"pageSize"`       // 列表接口默认的每页数量
+	MaxPageSize int                 `yaml:"maxPageSize" json:"maxPageSize"` // 列表接口每页数量的上限
+	Auth        map[string][]string `yaml:"auth" json:"auth"`               // 接口 -> 允许的用户类型或角色，"*" 表示所有接口，空列表表示只要求登录
+}
+
+// ConfigError 配置校验的所有错误
+type ConfigError struct {
+	Errors []string
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid resource config:\n" + strings.Join(e.Errors, "\n")
+}
+
+// LoadResourceConfig 从文件加载资源配置，按扩展名识别 .yaml、.yml 和 .json
+func LoadResourceConfig(file string) (*ResourceConfig, error) {
+	data, er := os.ReadFile(file)
+	if er != nil {
+		return nil, er
+	}
+	return ParseResourceConfig(data, strings.TrimPrefix(filepath.Ext(file), "."))
+}
+
+// ParseResourceConfig 解析资源配置，format 为 yaml、yml 或 json
+func ParseResourceConfig(data []byte, format string) (*ResourceConfig, error) {
+	cfg := &ResourceConfig{}
+	switch strings.ToLower(format) {
+	case "yaml", "yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if er := decoder.Decode(cfg); er != nil {
+			return nil, er
+		}
+	case "json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if er := decoder.Decode(cfg); er != nil {
+			return nil, er
+		}
+	default:
+		return nil, fmt.Errorf("unsupported config format [%s]", format)
+	}
+	return cfg, nil
+}
+
+// route 资源的路由名称
+func (s ResourceSpec) route() string {
+	if s.Route == "" {
+		return s.Table
+	}
+	return s.Route
+}
+
+// validate 按表结构校验资源配置，返回所有错误
+func (s ResourceSpec) validate(schema *TableSchema) []string {
+	name := fmt.Sprintf("resource [%s]", s.route())
+	errs := make([]string, 0)
+	checkColumns := func(field string, cols []string) {
+		for _, col := range cols {
+			if _, ok := schema.Columns[col]; !ok {
+				errs = append(errs, fmt.Sprintf("%s: %s column [%s] not found in table [%s]", name, field, col, s.Table))
+			}
+		}
+	}
+	checkColumns("query", s.Query)
+	checkColumns("detail", s.Detail)
+	checkColumns("insert", s.Insert)
+	checkColumns("update", s.Update)
+	for _, col := range sortedKeys(s.Conditions) {
+		info, ok := schema.Columns[col]
+		if !ok {
+			errs = append(errs, fmt.Sprintf("%s: condition column [%s] not found in table [%s]", name, col, s.Table))
+			continue
+		}
+		supported := conditionParamsOf(col, info.DataType)
+		for _, op := range s.Conditions[col] {
+			found := false
+			for _, param := range supported {
+				found = found || param.QueryName == col+op
+			}
+			if !found {
+				errs = append(errs, fmt.Sprintf("%s: condition [%s] is not supported on column [%s]", name, op, col))
+			}
+		}
+		// 按不可查询的列过滤同样会泄露该列的值
+		if len(s.Query) > 0 && !containsString(s.Query, col) {
+			errs = append(errs, fmt.Sprintf("%s: condition column [%s] is not in query columns", name, col))
+		}
+	}
+
+	operations := s.operations()
+	for _, op := range s.Operations {
+		if !containsString(routePathNames(builtinOperations), op) {
+			errs = append(errs, fmt.Sprintf("%s: unknown operation [%s]", name, op))
+		}
+	}
+	for _, op := range sortedKeys(s.Auth) {
+		if op != "*" && !containsString(routePathNames(builtinOperations), op) {
+			errs = append(errs, fmt.Sprintf("%s: unknown auth operation [%s]", name, op))
+		}
+	}
+	writes := containsString(operations, string(PathAdd)) || containsString(operations, string(PathUpdate)) || containsString(operations, string(PathDelete))
+	if schema.PrimaryKey == "" && writes {
+		errs = append(errs, fmt.Sprintf("%s: table [%s] has no primary key, only list, detail and struct can be enabled", name, s.Table))
+	}
+	if containsString(operations, string(PathAdd)) && len(s.Insert) > 0 {
+		for _, col := range schema.Info.Columns {
+			if !col.IsNullable && !col.IsAutoIncrement && col.DefaultValue == "" && !containsString(s.Insert, col.Name) {
+				errs = append(errs, fmt.Sprintf("%s: required column [%s] is missing from insert", name, col.Name))
+			}
+		}
+	}
+	if containsString(s.Update, schema.PrimaryKey) {
+		errs = append(errs, fmt.Sprintf("%s: primary key [%s] could not be updated", name, schema.PrimaryKey))
+	}
+	if s.PageSize < 0 || s.MaxPageSize < 0 {
+		errs = append(errs, fmt.Sprintf("%s: page size could not be negative", name))
+	} else if s.MaxPageSize > 0 && s.PageSize > s.MaxPageSize {
+		errs = append(errs, fmt.Sprintf("%s: pageSize %d exceeds maxPageSize %d", name, s.PageSize, s.MaxPageSize))
+	}
+	return errs
+}
+
+// operations 开放的接口，没有配置时为所有内置接口
+func (s ResourceSpec) operations() []string {
+	if len(s.Operations) == 0 {
+		return routePathNames(builtinOperations)
+	}
+	return s.Operations
+}
+
+// options 将配置转换为资源配置
+// auth 中 "*" 的中间件加到每个内置接口上，对应到内置接口的其他接口（如 patch、自定义操作）同样受其约束
+func (s ResourceSpec) options() []Option {
+	options := make([]Option, 0)
+	if len(s.Operations) > 0 {
+		operations := make([]DefaultRoutePath, 0, len(s.Operations))
+		for _, op := range s.Operations {
+			operations = append(operations, DefaultRoutePath(op))
+		}
+		options = append(options, WithOperations(operations...))
+	}
+	if s.PageSize > 0 || s.MaxPageSize > 0 {
+		options = append(options, WithPageSize(s.PageSize, s.MaxPageSize))
+	}
+	if identities, ok := s.Auth["*"]; ok {
+		for _, op := range builtinOperations {
+			if _, own := s.Auth[string(op)]; !own {
+				options = append(options, WithOperationMiddleware(op, RequireIdentity(identities...)))
+			}
+		}
+	}
+	for _, op := range sortedKeys(s.Auth) {
+		if op != "*" {
+			options = append(options, WithOperationMiddleware(DefaultRoutePath(op), RequireIdentity(s.Auth[op]...)))
+		}
+	}
+	return options
+}
+
+// columns 将配置转换为各接口使用的列和查询条件
+func (s ResourceSpec) columns(schema *TableSchema) tableColumns {
+	cols := tableColumns{query: s.Query, detail: s.Detail, insert: s.Insert, update: s.Update}
+	if cols.detail == nil {
+		cols.detail = s.Query
+	}
+	if s.Conditions != nil {
+		cols.conditions = make([]ConditionParam, 0)
+		for _, col := range sortedKeys(s.Conditions) {
+			for _, param := range conditionParamsOf(col, schema.Columns[col].DataType) {
+				for _, op := range s.Conditions[col] {
+					if param.QueryName == col+op {
+						cols.conditions = append(cols.conditions, param)
+					}
+				}
+			}
+		}
+	}
+	return cols
+}
+
+// Validate 按数据库元数据校验配置，所有错误通过 *ConfigError 一起返回
+func (cfg *ResourceConfig) Validate(db *gom.DB) error {
+	_, er := cfg.load(db)
+	return er
+}
+
+// Build 校验配置并生成资源，返回 路由名称 -> 资源
+func (cfg *ResourceConfig) Build(db *gom.DB) (map[string]ICrud, error) {
+	schemas, er := cfg.load(db)
+	if er != nil {
+		return nil, er
+	}
+	return cfg.build(db, schemas)
+}
+
+// Register 校验配置、生成资源并注册到路由
+func (cfg *ResourceConfig) Register(routes gin.IRoutes, db *gom.DB) (map[string]ICrud, error) {
+	resources, er := cfg.Build(db)
+	if er != nil {
+		return nil, er
+	}
+	for _, spec := range cfg.Resources {
+		if er := resources[spec.route()].Register(routes); er != nil {
+			return nil, er
+		}
+	}
+	return resources, nil
+}
+
+// load 读取配置中所有表的元数据并校验
+func (cfg *ResourceConfig) load(db *gom.DB) (map[string]*TableSchema, error) {
+	schemas := make(map[string]*TableSchema)
+	errs := make([]string, 0)
+	for idx, spec := range cfg.Resources {
+		if spec.Table == "" {
+			errs = append(errs, fmt.Sprintf("resource #%d: table could not be empty", idx+1))
+			continue
+		}
+		if _, ok := schemas[spec.Table]; ok {
+			continue
+		}
+		schema, er := NewTableSchema(db, spec.Table)
+		if er != nil {
+			errs = append(errs, fmt.Sprintf("resource [%s]: read table [%s]: %s", spec.route(), spec.Table, er.Error()))
+			continue
+		}
+		schemas[spec.Table] = schema
+	}
+	errs = append(errs, cfg.validate(schemas)...)
+	if len(errs) > 0 {
+		return nil, &ConfigError{Errors: errs}
+	}
+	return schemas, nil
+}
+
+// validate 按已读取的表结构校验所有资源
+func (cfg *ResourceConfig) validate(schemas map[string]*TableSchema) []string {
+	errs := make([]string, 0)
+	routes := make(map[string]bool)
+	for _, spec := range cfg.Resources {
+		if spec.Table == "" {
+			continue
+		}
+		if routes[spec.route()] {
+			errs = append(errs, fmt.Sprintf("resource [%s]: duplicate route", spec.route()))
+		}
+		routes[spec.route()] = true
+		if schema, ok := schemas[spec.Table]; ok {
+			errs = append(errs, spec.validate(schema)...)
+		}
+	}
+	return errs
+}
+
+// build 按校验过的配置生成资源
+func (cfg *ResourceConfig) build(db *gom.DB, schemas map[string]*TableSchema) (map[string]ICrud, error) {
+	if errs := cfg.validate(schemas); len(errs) > 0 {
+		return nil, &ConfigError{Errors: errs}
+	}
+	resources := make(map[string]ICrud, len(cfg.Resources))
+	for _, spec := range cfg.Resources {
+		schema, ok := schemas[spec.Table]
+		if !ok {
+			return nil, errors.New("table [" + spec.Table + "] not loaded")
+		}
+		resource, er := newTableCrud(spec.route(), db, schema, spec.columns(schema), spec.options()...)
+		if er != nil {
+			return nil, fmt.Errorf("resource [%s]: %w", spec.route(), er)
+		}
+		resources[spec.route()] = resource
+	}
+	return resources, nil
+}
+
+func routePathNames(paths []DefaultRoutePath) []string {
+	names := make([]string, 0, len(paths))
+	for _, p := range paths {
+		names = append(names, string(p))
+	}
+	return names
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,103 @@
+package crud
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+const testResourceConfig = `
+resources:
+  - table: cfg_notes
+    route: notes
+    query: [id, title]
+    update: [title, score]
+    conditions: {title: [Eq, Like], id: [In]}
+    operations: [list, detail, update]
+    pageSize: 5
+    maxPageSize: 50
+    auth: {"*": [], update: [admin]}
+`
+
+func TestParseResourceConfig(t *testing.T) {
+	cfg, er := ParseResourceConfig([]byte(testResourceConfig), "yaml")
+	assert.NoError(t, er)
+	assert.Equal(t, "notes", cfg.Resources[0].route())
+	assert.Equal(t, []string{"Eq", "Like"}, cfg.Resources[0].Conditions["title"])
+
+	_, er = ParseResourceConfig([]byte(`{"resources":[{"table":"a","colums":["id"]}]}`), "json")
+	assert.Error(t, er)
+	_, er = ParseResourceConfig([]byte(`resources: []`), "toml")
+	assert.EqualError(t, er, "unsupported config format [toml]")
+}
+
+func TestResourceConfigValidate(t *testing.T) {
+	notes, _ := newTableSchema(testTableInfo("cfg_notes", true))
+	views, _ := newTableSchema(testTableInfo("cfg_views", false))
+	schemas := map[string]*TableSchema{"cfg_notes": notes, "cfg_views": views}
+	cfg := &ResourceConfig{Resources: []ResourceSpec{
+		{Table: "cfg_notes", Query: []string{"id", "missing"}, Insert: []string{"score"}, Conditions: map[string][]string{"score": {"Like"}}, Operations: []string{"list", "add", "purge"}},
+		{Table: "cfg_views", Route: "cfg_notes"},
+	}}
+	errs := cfg.validate(schemas)
+	assert.Equal(t, []string{
+		"resource [cfg_notes]: query column [missing] not found in table [cfg_notes]",
+		"resource [cfg_notes]: condition [Like] is not supported on column [score]",
+		"resource [cfg_notes]: condition column [score] is not in query columns",
+		"resource [cfg_notes]: unknown operation [purge]",
+		"resource [cfg_notes]: required column [title] is missing from insert",
+		"resource [cfg_notes]: duplicate route",
+		"resource [cfg_notes]: table [cfg_views] has no primary key, only list, detail and struct can be enabled",
+	}, errs)
+
+	_, er := cfg.build(nil, schemas)
+	var configErr *ConfigError
+	assert.True(t, errors.As(er, &configErr))
+	assert.Len(t, configErr.Errors, 7)
+}
+
+func TestResourceConfigBuild(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg, er := ParseResourceConfig([]byte(testResourceConfig), "yaml")
+	assert.NoError(t, er)
+	notes, _ := newTableSchema(testTableInfo("cfg_notes", true))
+	resources, er := cfg.build(nil, map[string]*TableSchema{"cfg_notes": notes})
+	assert.NoError(t, er)
+
+	r := gin.New()
+	assert.NoError(t, resources["notes"].Register(r.Group("/api")))
+	assert.Len(t, r.Routes(), 3)
+
+	list, _ := resources["notes"].GetHandler(string(PathList))
+	names := make([]string, 0)
+	for _, param := range list.Parameters {
+		names = append(names, param.Name)
+	}
+	assert.Equal(t, []string{"idIn", "titleEq", "titleLike"}, names)
+
+	// 未登录时被拒绝
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/api/notes/update", nil))
+	assert.JSONEq(t, `{"code":401,"msg":"unauthorized","data":null}`, w.Body.String())
+
+	// "*" 同样作用于对应到内置接口的其他接口
+	opts := NewOptions(nil, cfg.Resources[0].options()...)
+	derived := []RouteHandler{
+		withOperation(RouteHandler{Path: string(PathRevisions), Handlers: []gin.HandlerFunc{DoNothingFunc, DoNothingFunc, DoNothingFunc}}, PathDetail),
+		withOperation(RouteHandler{Path: string(PathWatch), Handlers: []gin.HandlerFunc{DoNothingFunc, DoNothingFunc, DoNothingFunc}}, PathList),
+	}
+	applyMiddlewares(opts, derived)
+	for _, handler := range derived {
+		assert.Len(t, handler.Handlers, 4, handler.Path)
+	}
+
+	// 列表的每页数量受上限约束
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/api/notes/list?pageSize=500", nil)
+	SetContextOptions(list.Options)(c)
+	DefaultGenPageFromRstQuery(c)
+	assert.Equal(t, 50, getContextPageSize(c))
+}
@@ -0,0 +1,218 @@
+package crud
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+
+	"github.com/kmlixh/gom/v4"
+)
+
+// requestDB 返回绑定 ctx 的 gom.DB，gom 不向驱动传递 context，这里把语句固定在连接池中的一个连接上，
+// 以 ctx 执行查询、写入和事务，ctx 取消或超时后驱动中断正在执行的语句；release 归还连接
+func requestDB(ctx context.Context, db *gom.DB) (*gom.DB, func(), error) {
+	if ctx.Done() == nil {
+		return db, func() {}, nil
+	}
+	conn, er := db.GetDB().Conn(ctx)
+	if er != nil {
+		return nil, nil, er
+	}
+	bound := sql.OpenDB(&boundConnector{ctx: ctx, conn: conn})
+	bound.SetMaxOpenConns(1)
+	return &gom.DB{DB: bound, Factory: db.Factory}, func() {
+		_ = bound.Close()
+		_ = conn.Close()
+	}, nil
+}
+
+// boundConnector 只提供同一个连接的 connector
+type boundConnector struct {
+	ctx  context.Context
+	conn *sql.Conn
+}
+
+func (b *boundConnector) Connect(context.Context) (driver.Conn, error) {
+	return &boundConn{ctx: b.ctx, conn: b.conn}, nil
+}
+
+func (b *boundConnector) Driver() driver.Driver {
+	return boundDriver{}
+}
+
+type boundDriver struct{}
+
+func (boundDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("bound connection could not be opened by name")
+}
+
+// boundConn 将语句转交给固定的连接，事务中转交给事务，均使用请求的 ctx
+type boundConn struct {
+	ctx  context.Context
+	conn *sql.Conn
+	tx   *sql.Tx
+}
+
+// queryer 当前执行语句的连接或事务
+func (b *boundConn) queryer() interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+} {
+	if b.tx != nil {
+		return b.tx
+	}
+	return b.conn
+}
+
+// CheckNamedValue 参数原样交给底层驱动转换
+func (b *boundConn) CheckNamedValue(*driver.NamedValue) error {
+	return nil
+}
+
+func (b *boundConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rows, er := b.queryer().QueryContext(b.ctx, query, namedArgs(args)...)
+	if er != nil {
+		return nil, er
+	}
+	types, er := rows.ColumnTypes()
+	if er != nil {
+		_ = rows.Close()
+		return nil, er
+	}
+	return &boundRows{rows: rows, types: types}, nil
+}
+
+func (b *boundConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return b.queryer().ExecContext(b.ctx, query, namedArgs(args)...)
+}
+
+func (b *boundConn) BeginTx(_ context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b.tx != nil {
+		return nil, errors.New("transaction already started")
+	}
+	tx, er := b.conn.BeginTx(b.ctx, &sql.TxOptions{Isolation: sql.IsolationLevel(opts.Isolation), ReadOnly: opts.ReadOnly})
+	if er != nil {
+		return nil, er
+	}
+	b.tx = tx
+	return boundTx{conn: b}, nil
+}
+
+func (b *boundConn) Begin() (driver.Tx, error) {
+	return b.BeginTx(b.ctx, driver.TxOptions{})
+}
+
+func (b *boundConn) Prepare(query string) (driver.Stmt, error) {
+	return boundStmt{conn: b, query: query}, nil
+}
+
+func (b *boundConn) Close() error {
+	return nil
+}
+
+// namedArgs 驱动参数转换为 database/sql 的参数
+func namedArgs(args []driver.NamedValue) []any {
+	values := make([]any, 0, len(args))
+	for _, arg := range args {
+		if arg.Name != "" {
+			values = append(values, sql.Named(arg.Name, arg.Value))
+			continue
+		}
+		values = append(values, arg.Value)
+	}
+	return values
+}
+
+type boundTx struct {
+	conn *boundConn
+}
+
+func (t boundTx) Commit() error {
+	tx := t.conn.tx
+	t.conn.tx = nil
+	return tx.Commit()
+}
+
+func (t boundTx) Rollback() error {
+	tx := t.conn.tx
+	t.conn.tx = nil
+	return tx.Rollback()
+}
+
+// boundStmt 预处理语句直接按原 SQL 执行
+type boundStmt struct {
+	conn  *boundConn
+	query string
+}
+
+func (s boundStmt) Close() error {
+	return nil
+}
+
+func (s boundStmt) NumInput() int {
+	return -1
+}
+
+func (s boundStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.ExecContext(s.conn.ctx, s.query, valueArgs(args))
+}
+
+func (s boundStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.QueryContext(s.conn.ctx, s.query, valueArgs(args))
+}
+
+// valueArgs 按位置转换参数
+func valueArgs(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, 0, len(args))
+	for idx, arg := range args {
+		named = append(named, driver.NamedValue{Ordinal: idx + 1, Value: arg})
+	}
+	return named
+}
+
+// boundRows 转交底层的结果集，列类型保持不变，gom 按列类型选择扫描的目标类型
+type boundRows struct {
+	rows  *sql.Rows
+	types []*sql.ColumnType
+}
+
+func (r *boundRows) Columns() []string {
+	names := make([]string, 0, len(r.types))
+	for _, t := range r.types {
+		names = append(names, t.Name())
+	}
+	return names
+}
+
+func (r *boundRows) Close() error {
+	return r.rows.Close()
+}
+
+func (r *boundRows) Next(dest []driver.Value) error {
+	if !r.rows.Next() {
+		if er := r.rows.Err(); er != nil {
+			return er
+		}
+		return io.EOF
+	}
+	values := make([]any, len(dest))
+	for idx := range values {
+		values[idx] = &dest[idx]
+	}
+	return r.rows.Scan(values...)
+}
+
+func (r *boundRows) ColumnTypeDatabaseTypeName(index int) string {
+	return r.types[index].DatabaseTypeName()
+}
+
+func (r *boundRows) ColumnTypeScanType(index int) reflect.Type {
+	return r.types[index].ScanType()
+}
+
+func (r *boundRows) ColumnTypeNullable(index int) (bool, bool) {
+	return r.types[index].Nullable()
+}
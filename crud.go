@@ -12,6 +12,7 @@ import (
 	"unicode"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/kmlixh/gom/v4"
 	"github.com/kmlixh/gom/v4/define"
 )
@@ -66,9 +67,13 @@ func DefaultGenPageFromRstQuery(c *gin.Context) {
 		return
 	}
 	SetContextPageNumber(pageNum)(c)
+	opts, _ := GetContextOptions(c)
 	pageSizet := c.Query("pageSize")
 	if pageSizet == "" {
 		pageSizet = "10"
+		if opts != nil && opts.PageSize > 0 {
+			pageSizet = strconv.Itoa(opts.PageSize)
+		}
 	}
 	pageSize, er := strconv.Atoi(pageSizet)
 	if er != nil {
@@ -76,6 +81,9 @@ func DefaultGenPageFromRstQuery(c *gin.Context) {
 		RenderErrs(c, er)
 		return
 	}
+	if opts != nil && opts.MaxPageSize > 0 && pageSize > opts.MaxPageSize {
+		pageSize = opts.MaxPageSize
+	}
 	SetContextPageSize(pageSize)(c)
 }
 
@@ -122,7 +130,8 @@ func getContextPageSize(c *gin.Context) int {
 
 func DefaultUnMarshFunc(i any) gin.HandlerFunc {
 	return func(context *gin.Context) {
-		err := context.ShouldBindJSON(i)
+		// 保留请求体，PATCH 等后续处理需要读取请求中出现的字段
+		err := context.ShouldBindBodyWith(i, binding.JSON)
 		if err != nil {
 			context.Abort()
 			RenderErrs(context, err)
@@ -197,12 +206,30 @@ func SetColumns(columns []string) gin.HandlerFunc {
 
 func SetConditionParamAsCnd(queryParam []ConditionParam) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		cnd, _, er := MapToParamCondition(c, queryParam)
+		cnd, _, er := MapToParamCondition(c, readableConditionParams(c, queryParam))
 		if er != nil {
 			c.Abort()
 			RenderErrs(c, er)
 			return
 		}
+		if opts, ok := GetContextOptions(c); ok {
+			if er := rewriteEncryptedCondition(opts, cnd); er != nil {
+				c.Abort()
+				RenderErrs(c, er)
+				return
+			}
+		}
+		path, er := pathCondition(c)
+		if er != nil {
+			c.Abort()
+			RenderErr2(c, 500, er.Error())
+			return
+		}
+		if path != nil && cnd != nil {
+			cnd = &define.Condition{IsSubGroup: true, SubConds: []*define.Condition{path, cnd}}
+		} else if path != nil {
+			cnd = path
+		}
 		if cnd != nil && cnd.Field != "" {
 			c.Set(prefix+"cnd", cnd)
 		}
@@ -219,6 +246,9 @@ type RouteHandler struct {
 	Parameters  []ApiProperty     // 入参说明
 	Response    APIResponse       // 响应说明
 	Handlers    []gin.HandlerFunc // 处理函数
+	Route       string            // 实际注册的路由，为空时使用 Path，"/" 表示资源根路径
+	Options     *Options          // 资源扩展配置
+	Operation   DefaultRoutePath  // 接口的读写行为对应的内置接口，决定接口是否开放以及使用的中间件和超时时间，为空时为 Path
 }
 
 // ICrud represents the CRUD interface
@@ -228,6 +258,7 @@ type ICrud interface {
 	GetHandler(name string) (RouteHandler, error)
 	DeleteHandler(name string) error
 	AppendHandler(name string, handler gin.HandlerFunc, appendType HandlerAppendType, position HandlerPosition) error
+	AddAction(action Action) error
 }
 
 // HandlerAppendType represents how to append a handler
@@ -252,6 +283,7 @@ type Crud struct {
 	Name     string
 	Handlers []RouteHandler
 	IdxMap   map[string]int
+	actions  *[]RouteHandler // AddAction 添加的接口，Crud 按值传递时各副本共享
 }
 
 // ConditionPayload represents a condition's operation type and value
@@ -273,21 +305,50 @@ type ConditionParam struct {
 	DataType  reflect.Kind
 }
 
-func NewCrud2(prefix string, i any, db *gom.DB, queryCols []string, queryConditionParam []ConditionParam, queryDetailCols []string, detailConditionParam []ConditionParam, insertCols []string, updateCols []string, updateConditionParam []ConditionParam, deleteConditionParam []ConditionParam, resultPropertiese []ApiProperty) (ICrud, error) {
+func NewCrud2(prefix string, i any, db *gom.DB, queryCols []string, queryConditionParam []ConditionParam, queryDetailCols []string, detailConditionParam []ConditionParam, insertCols []string, updateCols []string, updateConditionParam []ConditionParam, deleteConditionParam []ConditionParam, resultPropertiese []ApiProperty, options ...Option) (ICrud, error) {
 	t := reflect.TypeOf(i)
 	if t.Kind() == reflect.Ptr {
 		t = t.Elem()
 	}
+	opts := NewOptions(i, options...)
+	if opts.Resource == "" {
+		opts.Resource = prefix
+	}
+	opts.entity = i
+	opts.db = db
+	if er := opts.validate(); er != nil {
+		return nil, er
+	}
+	router := opts.resolveRouter(db)
+	opts.queryColumns = queryCols
+	opts.detailColumns = queryDetailCols
 
 	// 生成基础API文档
 	modelName := t.Name()
+	if len(resultPropertiese) == 0 {
+		resultPropertiese = GenerateApiPropertiesFromStruct(i)
+	}
+	resultPropertiese = markMaskedProperties(opts, resultPropertiese)
+
+	listParameters := generateApiPropertys(queryConditionParam, "query", false)
+	detailParameters := generateApiPropertys(detailConditionParam, "query", false)
+	if len(opts.Relations) > 0 {
+		listParameters = append(listParameters, generateExpandParameter(opts.Relations))
+		detailParameters = append(detailParameters, generateExpandParameter(opts.Relations))
+	}
+	asOfFunc := DoNothingFunc
+	if opts.Revisions != nil {
+		detailParameters = append(detailParameters, ApiProperty{Name: "asOf", Type: "string", Description: "查询该时刻的版本，RFC3339格式或时间戳", Location: "query"})
+		asOfFunc = QueryAsOf()
+	}
 
 	listHandler := GetQueryListHandler(
 		modelName+"列表查询",
 		"获取"+modelName+"分页列表",
-		generateApiPropertys(queryConditionParam, "query", false),
-		generateListResponse(modelName),
-		SetContextDatabase(db),
+		listParameters,
+		generateListResponse(modelName, resultPropertiese),
+		SetContextDatabase(db, router),
+		SetContextOptions(opts),
 		SetContextEntity(i),
 		DoNothingFunc,
 		SetConditionParamAsCnd(queryConditionParam),
@@ -299,15 +360,16 @@ func NewCrud2(prefix string, i any, db *gom.DB, queryCols []string, queryConditi
 	detailHandler := GetQuerySingleHandler(
 		modelName+"详情查询",
 		"获取单个"+modelName+"详情",
-		generateApiPropertys(detailConditionParam, "query", false),
-		generateDetailResponse(modelName),
-		SetContextDatabase(db),
+		detailParameters,
+		generateDetailResponse(modelName, resultPropertiese),
+		SetContextDatabase(db, router),
+		SetContextOptions(opts),
 		SetContextEntity(i),
 		DoNothingFunc,
 		SetConditionParamAsCnd(detailConditionParam),
 		SetColumns(queryDetailCols),
 		DoNothingFunc,
-		DoNothingFunc,
+		asOfFunc,
 	)
 
 	insertHandler := GetInsertHandler(
@@ -315,7 +377,8 @@ func NewCrud2(prefix string, i any, db *gom.DB, queryCols []string, queryConditi
 		"新增"+modelName,
 		[]ApiProperty{},
 		generateInsertResponse(modelName),
-		SetContextDatabase(db),
+		SetContextDatabase(db, router),
+		SetContextOptions(opts),
 		DoNothingFunc,
 		DefaultUnMarshFunc(i),
 		DoNothingFunc,
@@ -329,7 +392,8 @@ func NewCrud2(prefix string, i any, db *gom.DB, queryCols []string, queryConditi
 		"更新"+modelName,
 		generateApiPropertys(updateConditionParam, "query", false),
 		generateUpdateResponse(modelName),
-		SetContextDatabase(db),
+		SetContextDatabase(db, router),
+		SetContextOptions(opts),
 		DoNothingFunc,
 		DefaultUnMarshFunc(i),
 		SetConditionParamAsCnd(updateConditionParam),
@@ -343,7 +407,8 @@ func NewCrud2(prefix string, i any, db *gom.DB, queryCols []string, queryConditi
 		"删除"+modelName,
 		generateApiPropertys(deleteConditionParam, "query", false),
 		generateDeleteResponse(modelName),
-		SetContextDatabase(db),
+		SetContextDatabase(db, router),
+		SetContextOptions(opts),
 		SetContextEntity(i),
 		DoNothingFunc,
 		SetConditionParamAsCnd(deleteConditionParam),
@@ -357,7 +422,8 @@ func NewCrud2(prefix string, i any, db *gom.DB, queryCols []string, queryConditi
 		"获取"+modelName+"表结构",
 		generateTableStructParameters(),
 		generateTableStructResponse(modelName),
-		SetContextDatabase(db),
+		SetContextDatabase(db, router),
+		SetContextOptions(opts),
 		SetContextEntity(i),
 		DoNothingFunc,
 		DoNothingFunc,
@@ -365,7 +431,94 @@ func NewCrud2(prefix string, i any, db *gom.DB, queryCols []string, queryConditi
 		DoNothingFunc,
 	)
 
-	return GenHandlerRegister(prefix, listHandler, detailHandler, insertHandler, updateHandler, deleteHandler, tableStructHandler)
+	handlers := []RouteHandler{listHandler, detailHandler, insertHandler, updateHandler, deleteHandler, tableStructHandler}
+	if opts.Audit != nil {
+		handlers = append(handlers, GetHistoryHandler(
+			modelName+"变更历史",
+			"获取单个"+modelName+"的变更历史",
+			generateHistoryPageParameters(),
+			generateHistoryResponse(modelName),
+			SetContextDatabase(db, router),
+			SetContextOptions(opts),
+			SetContextEntity(i),
+			DefaultGenPageFromRstQuery,
+		))
+	}
+	if opts.Revisions != nil {
+		handlers = append(handlers, GetRevisionsHandler(
+			modelName+"版本列表",
+			"获取单个"+modelName+"的所有版本",
+			generateHistoryParameters(),
+			generateRevisionsResponse(modelName),
+			SetContextDatabase(db, router),
+			SetContextOptions(opts),
+			SetContextEntity(i),
+			SetColumns(queryDetailCols),
+		), GetRollbackHandler(
+			modelName+"版本恢复",
+			"将单个"+modelName+"恢复到指定版本",
+			generateRollbackParameters(),
+			generateUpdateResponse(modelName),
+			SetContextDatabase(db, router),
+			SetContextOptions(opts),
+			SetContextEntity(i),
+			SetColumns(updateCols),
+		))
+	}
+	if opts.Watch != nil {
+		watchParameters := append(generateApiPropertys(queryConditionParam, "query", false),
+			ApiProperty{Name: "lastEventId", Type: "integer", Description: "续传的起始事件序号，SSE 也可以使用 Last-Event-ID 请求头", Location: "query"})
+		handlers = append(handlers, GetWatchHandler(
+			modelName+"变更订阅",
+			"以 Server-Sent Events 推送"+modelName+"的新增、更新、删除",
+			watchParameters,
+			generateWatchResponse(modelName, resultPropertiese),
+			SetContextDatabase(db, router),
+			SetContextOptions(opts),
+			SetContextEntity(i),
+			DoNothingFunc,
+			SetConditionParamAsCnd(queryConditionParam),
+			SetColumns(queryCols),
+		), GetWatchWebSocketHandler(
+			modelName+"变更订阅(WebSocket)",
+			"以 WebSocket 推送"+modelName+"的新增、更新、删除",
+			watchParameters,
+			generateWatchResponse(modelName, resultPropertiese),
+			SetContextDatabase(db, router),
+			SetContextOptions(opts),
+			SetContextEntity(i),
+			DoNothingFunc,
+			SetConditionParamAsCnd(queryConditionParam),
+			SetColumns(queryCols),
+		))
+	}
+	for _, relation := range opts.ManyToMany {
+		handlers = append(handlers, generateAssociationHandlers(modelName, relation, SetContextDatabase(db, router), SetContextOptions(opts), SetContextEntity(i))...)
+	}
+	return buildResource(prefix, opts, handlers)
+}
+
+// buildResource 按资源配置过滤接口、映射路由风格、加入父资源校验和中间件后生成 ICrud
+func buildResource(prefix string, opts *Options, handlers []RouteHandler) (ICrud, error) {
+	handlers = aggregateHandlers(opts, handlers)
+	handlers = enabledHandlers(opts, handlers)
+	if opts.RouteStyle == RouteStyleREST {
+		handlers = restHandlers(handlers)
+	}
+	for idx := range handlers {
+		handlers[idx].Options = opts
+	}
+	name := prefix
+	if opts.Parent != nil {
+		nestHandlers(handlers)
+		name = opts.Parent.routeName(prefix)
+	}
+	resolveHandlers(opts, handlers)
+	applyMiddlewares(opts, handlers)
+	applyETag(opts, handlers)
+	applyCache(opts, handlers)
+	applyTimeouts(opts, handlers)
+	return GenHandlerRegister(name, handlers...)
 }
 
 func GetQueryListHandler(name, description string, parameters []ApiProperty, response APIResponse, beforeCommitFunc ...gin.HandlerFunc) RouteHandler {
@@ -426,6 +579,13 @@ func (h Crud) AddHandler(routeHandler RouteHandler) error {
 func (h Crud) GetHandler(name string) (RouteHandler, error) {
 	idx, ok := h.IdxMap[name]
 	if !ok {
+		if h.actions != nil {
+			for _, handler := range *h.actions {
+				if handler.Path == name {
+					return handler, nil
+				}
+			}
+		}
 		return RouteHandler{}, fmt.Errorf("handler [%s] not found", name)
 	} else {
 		return h.Handlers[idx], nil
@@ -484,30 +644,48 @@ func (h Crud) Register(routes gin.IRoutes, prefix ...string) error {
 		return errors.New("route handler could not be empty or nil")
 	}
 
+	handlers := h.Handlers
+	if h.actions != nil {
+		handlers = append(append([]RouteHandler{}, handlers...), *h.actions...)
+	}
 	// 注册路由同时注册API文档
-	for _, handler := range h.Handlers {
+	for _, handler := range handlers {
+		path := handler.routePath(name)
 		if handler.HttpMethod != "Any" {
-			routes.Handle(handler.HttpMethod, name+"/"+handler.Path, handler.Handlers...)
+			routes.Handle(handler.HttpMethod, path, handler.Handlers...)
 
 			// 注册API文档
 			doc := APIDoc{
 				Name:        handler.Name,
-				Path:        name + "/" + handler.Path,
+				Path:        path,
 				Method:      handler.HttpMethod,
 				Description: handler.Description,
 				Group:       name,
 				Parameters:  handler.Parameters,
 				Response:    handler.Response,
 				Metadata:    map[string]interface{}{},
+				options:     handler.Options,
 			}
 			globalAPIRegistry.RegisterAPI(name, doc)
 		} else {
-			routes.Any(name+"/"+handler.Path, handler.Handlers...)
+			routes.Any(path, handler.Handlers...)
 		}
 	}
 	return nil
 }
 
+// routePath 接口注册到路由上的完整路径
+func (h RouteHandler) routePath(name string) string {
+	switch h.Route {
+	case "":
+		return name + "/" + h.Path
+	case "/":
+		return name
+	default:
+		return name + "/" + h.Route
+	}
+}
+
 func GenHandlerRegister(name string, handlers ...RouteHandler) (ICrud, error) {
 	if len(handlers) == 0 {
 		return Crud{}, errors.New("route handler could not be empty or nil")
@@ -520,9 +698,12 @@ func GenHandlerRegister(name string, handlers ...RouteHandler) (ICrud, error) {
 		Name:     name,
 		Handlers: handlers,
 		IdxMap:   handlerIdxMap,
+		actions:  &[]RouteHandler{},
 	}, nil
 }
 
+// NewCrud 按结构体和数据库中的表结构生成增删改查接口
+// 查询、写入的列为结构体中与表对应的列，查询条件参数按字段类型生成
 func NewCrud(db *gom.DB, tableName string, model any) (ICrud, error) {
 	if tableName == "" {
 		return nil, errors.New("table name cannot be empty")
@@ -537,15 +718,23 @@ func NewCrud(db *gom.DB, tableName string, model any) (ICrud, error) {
 	if t.Kind() != reflect.Struct {
 		return nil, errors.New("model must be a struct")
 	}
+	// 请求体需要绑定到指针上
+	model = reflect.New(t).Interface()
 	tableStruct, er := db.GetTableStruct(model, tableName)
 	if er != nil {
 		return nil, er
 	}
 
-	// 获取模型的所有字段作为默认的查询和操作字段
+	// 获取模型与表共有的列作为默认的查询和操作字段
 	fields := make([]string, 0)
 	defaultCondParams := make([]ConditionParam, 0)
-	for colName, fieldName := range tableStruct.Columns {
+	for _, col := range tableStruct.Columns {
+		field, ok := modelField(t, col.Name)
+		if !ok {
+			continue
+		}
+		fieldName, fieldType := col.Name, field.Type.Kind()
+		fields = append(fields, fieldName)
 
 		// 根据字段类型生成不同的条件参数
 		switch fieldType {
@@ -607,6 +796,9 @@ func NewCrud(db *gom.DB, tableName string, model any) (ICrud, error) {
 			)
 		}
 	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("model has no column of table [%s]", tableName)
+	}
 
 	// 调用 NewCrud2 创建路由处理器
 	return NewCrud2(
@@ -621,9 +813,28 @@ func NewCrud(db *gom.DB, tableName string, model any) (ICrud, error) {
 		fields,            // 更新字段
 		defaultCondParams, // 更新条件参数
 		defaultCondParams, // 删除条件参数
+		nil,               // 结果字段说明，默认按结构体生成
 	)
 }
 
+// modelField 按列名查找结构体字段，列名取自 gom 标签，没有标签时为字段名的蛇形形式
+func modelField(t reflect.Type, col string) (reflect.StructField, bool) {
+	for idx := 0; idx < t.NumField(); idx++ {
+		field := t.Field(idx)
+		name := strings.Split(field.Tag.Get("gom"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = ToSnakeCase(field.Name)
+		}
+		if name == col {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
 // 辅助函数
 func getTableName(i interface{}) string {
 	if t, ok := i.(interface{ TableName() string }); ok {
@@ -693,13 +904,15 @@ func MapToParamCondition(c *gin.Context, conditionParams []ConditionParam) (*def
 					newCond = define.Like(param.ColName, "%"+val.(string))
 				case "LikeRight":
 					newCond = define.Like(param.ColName, val.(string)+"%")
-				case "In":
-					if slice, ok := val.([]interface{}); ok {
-						newCond = define.In(param.ColName, slice...)
+				case "In", "NotIn":
+					values := conditionValues(val)
+					if len(values) == 0 {
+						return nil, nil, fmt.Errorf("condition [%s] needs at least one value", param.QueryName)
 					}
-				case "NotIn":
-					if slice, ok := val.([]interface{}); ok {
-						newCond = define.NotIn(param.ColName, slice...)
+					if oper == "In" {
+						newCond = define.In(param.ColName, values...)
+					} else {
+						newCond = define.NotIn(param.ColName, values...)
 					}
 				case "NotLike":
 					newCond = define.NotLike(param.ColName, val)
@@ -721,6 +934,64 @@ func MapToParamCondition(c *gin.Context, conditionParams []ConditionParam) (*def
 	return nil, nil, nil
 }
 
+// conditionValues 解析 In、NotIn 条件的值，支持 JSON 数组、重复的查询参数和逗号分隔的字符串
+func conditionValues(val any) []any {
+	values := make([]any, 0)
+	switch v := val.(type) {
+	case []any:
+		values = append(values, v...)
+	case []string:
+		for _, s := range v {
+			values = append(values, conditionValues(s)...)
+		}
+	case string:
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				values = append(values, s)
+			}
+		}
+	case nil:
+	default:
+		values = append(values, v)
+	}
+	return values
+}
+
+// getWriteFields 按写入白名单、字段权限和加密配置生成要写入的列，没有任何限制时返回nil
+func getWriteFields(c *gin.Context, i any) (map[string]any, error) {
+	cols, er := writableColumns(c, i, getSelectColumns(c))
+	if er != nil {
+		return nil, er
+	}
+	opts, _ := GetContextOptions(c)
+	encrypted := opts != nil && len(opts.Encrypted) > 0
+	nested := opts != nil && opts.Parent != nil
+	if len(cols) == 0 && !encrypted && !nested {
+		return nil, nil
+	}
+	transfer := define.GetTransfer(i)
+	if transfer == nil {
+		return nil, errors.New("entity is not a struct")
+	}
+	fields := transfer.ToMap(i)
+	if len(cols) > 0 {
+		picked := make(map[string]any, len(cols))
+		for _, col := range cols {
+			if val, ok := fields[col]; ok {
+				picked[col] = val
+			}
+		}
+		fields = picked
+	}
+	if er := encryptFields(opts, fields); er != nil {
+		return nil, er
+	}
+	if er := bindParentField(c, fields); er != nil {
+		return nil, er
+	}
+	return fields, nil
+}
+
 func DoInsert() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		db, ok := GetContextDatabase(c)
@@ -734,11 +1005,29 @@ func DoInsert() gin.HandlerFunc {
 			return
 		}
 
+		// 按写入白名单、字段权限和加密配置确定写入的列
+		fields, er := getWriteFields(c, i)
+		if er != nil {
+			RenderErr2(c, 0, er.Error())
+			return
+		}
+
 		// 执行插入操作
-		chain := db.Chain().Table(getTableName(i))
-		result := chain.Save(i)
+		table := getTableName(i)
+		pk := primaryKeyOf(i)
+		var insertKey any
+		if fields != nil {
+			insertKey = fields[pk]
+		}
+		result := runMutation(c, db, table, pk, ChangeInsert, nil, insertKey, func(chain *gom.Chain) *define.Result {
+			chain = chain.Table(table)
+			if fields != nil {
+				return chain.Values(fields).Save()
+			}
+			return chain.Save(i)
+		})
 		if result.Error != nil {
-			RenderErr2(c, 0, result.Error.Error())
+			renderDBError(c, 0, result.Error)
 			return
 		}
 
@@ -772,11 +1061,26 @@ func DoUpdate() gin.HandlerFunc {
 			return
 		}
 
+		// 按写入白名单、字段权限和加密配置确定写入的列
+		fields, er := getWriteFields(c, i)
+		if er != nil {
+			RenderErr2(c, 500, er.Error())
+			return
+		}
+
 		// 执行更新操作
-		chain := db.Chain().Table(getTableName(i))
-		result := chain.Where("id", define.OpEq, idField.Interface()).Update(i)
+		table := getTableName(i)
+		cond := scopeCondition(c, define.Eq("id", idField.Interface()))
+		result := runMutation(c, db, table, "id", ChangeUpdate, cond, nil, func(chain *gom.Chain) *define.Result {
+			chain = chain.Table(table).Where2(cond)
+			if fields != nil {
+				delete(fields, "id")
+				return chain.Update(fields)
+			}
+			return chain.Update(i)
+		})
 		if result.Error != nil {
-			RenderErr2(c, 500, result.Error.Error())
+			renderDBError(c, 500, result.Error)
 			return
 		}
 		RenderOk(c, result)
@@ -796,9 +1100,17 @@ func DoDelete() gin.HandlerFunc {
 			return
 		}
 
-		result := db.Chain().Table(getTableName(i)).From(i).Delete()
+		cond, _ := getContextCondition(c)
+		cond = scopeCondition(c, cond)
+		table := getTableName(i)
+		result := runMutation(c, db, table, primaryKeyOf(i), ChangeDelete, cond, nil, func(chain *gom.Chain) *define.Result {
+			if cond == nil {
+				return chain.Table(table).From(i).Delete()
+			}
+			return chain.Table(table).Where2(cond).Delete()
+		})
 		if result.Error != nil {
-			RenderErr2(c, 500, result.Error.Error())
+			renderDBError(c, 500, result.Error)
 			return
 		}
 
@@ -808,7 +1120,7 @@ func DoDelete() gin.HandlerFunc {
 
 func QueryList() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		db, ok := GetContextDatabase(c)
+		db, ok := GetContextReadDatabase(c)
 		if !ok {
 			RenderErr2(c, 500, "can't find database")
 			return
@@ -824,25 +1136,45 @@ func QueryList() gin.HandlerFunc {
 		pageSize := getContextPageSize(c)
 
 		// 获取条件
-		cond, ok := getContextCondition(c)
+		cond, ok := getScopedCondition(c)
 
 		// 获取要查询的字段
-		cols := getSelectColumns(c)
-
-		// 执行查询
-		chain := db.Chain().Table(getTableName(i))
-		if len(cols) > 0 {
-			chain = chain.Fields(cols...)
-		}
-		if cond != nil {
-			chain = chain.Where2(cond)
+		cols, er := readableColumns(c, i, getSelectColumns(c))
+		if er != nil {
+			RenderErr2(c, 500, er.Error())
+			return
 		}
+		cols = withRelationKeys(c, i, cols)
+
 		// 执行分页查询
-		result, er := chain.From(i).Page(pageNum, pageSize).PageInfo()
+		var result *gom.PageInfo
+		er = readTransaction(c, db, func(tx *gom.Chain) (er error) {
+			chain := tx.Table(getTableName(i))
+			if len(cols) > 0 {
+				chain = chain.Fields(cols...)
+			}
+			if cond != nil {
+				chain = chain.Where2(cond)
+			}
+			result, er = chain.From(i).Page(pageNum, pageSize).PageInfo()
+			return er
+		})
 		if er != nil {
+			renderDBError(c, 500, er)
+			return
+		}
+		if er := DecryptData(c, result.List); er != nil {
 			RenderErr2(c, 500, er.Error())
 			return
 		}
+		if er := MaskData(c, result.List); er != nil {
+			RenderErr2(c, 403, er.Error())
+			return
+		}
+		if result.List, er = expandRelations(c, result.List); er != nil {
+			RenderErrs(c, er)
+			return
+		}
 		RenderOk(c, result)
 	}
 }
@@ -865,7 +1197,7 @@ func CreateSliceByReflect(instance any) any {
 }
 func QuerySingle() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		db, ok := GetContextDatabase(c)
+		db, ok := GetContextReadDatabase(c)
 		if !ok {
 			RenderErr2(c, 500, "can't find database")
 			return
@@ -877,30 +1209,48 @@ func QuerySingle() gin.HandlerFunc {
 		}
 
 		// 获取条件
-		cond, ok := getContextCondition(c)
+		cond, ok := getScopedCondition(c)
 
 		// 获取要查询的字段
-		cols := getSelectColumns(c)
+		cols, er := readableColumns(c, i, getSelectColumns(c))
+		if er != nil {
+			RenderErr2(c, 500, er.Error())
+			return
+		}
+		cols = withRelationKeys(c, i, cols)
 
 		// 执行查询
-		chain := db.Chain().Table(getTableName(i))
-		if len(cols) > 0 {
-			chain = chain.Fields(cols...)
-		}
-		if cond != nil {
-			chain = chain.Where2(cond)
+		var result *define.Result
+		if er := readTransaction(c, db, func(tx *gom.Chain) error {
+			chain := tx.Table(getTableName(i))
+			if len(cols) > 0 {
+				chain = chain.Fields(cols...)
+			}
+			if cond != nil {
+				chain = chain.Where2(cond)
+			}
+			result = chain.First()
+			return nil
+		}); er != nil {
+			renderDBError(c, 500, er)
+			return
 		}
-
-		result := chain.First()
 		if result.Error != nil {
 			if result.Error.Error() == "sql: no rows in result set" {
 				RenderOk(c, nil)
 				return
 			}
-			RenderErr2(c, 500, result.Error.Error())
+			renderDBError(c, 500, result.Error)
 			return
 		}
 
+		if len(result.Data) > 0 {
+			if er := setContextETag(c, result.Data[0]); er != nil {
+				RenderErr2(c, 500, er.Error())
+				return
+			}
+		}
+
 		// 创建一个新的结构体实例
 		newStruct := reflect.New(reflect.TypeOf(i).Elem()).Interface()
 
@@ -909,7 +1259,20 @@ func QuerySingle() gin.HandlerFunc {
 			RenderErr2(c, 500, err.Error())
 			return
 		}
-		RenderOk(c, newStruct)
+		if err := DecryptData(c, newStruct); err != nil {
+			RenderErr2(c, 500, err.Error())
+			return
+		}
+		if err := MaskData(c, newStruct); err != nil {
+			RenderErr2(c, 403, err.Error())
+			return
+		}
+		data, err := expandRelations(c, newStruct)
+		if err != nil {
+			RenderErrs(c, err)
+			return
+		}
+		RenderOk(c, data)
 	}
 }
 
@@ -925,15 +1288,30 @@ func SetContextCondition(cnd *define.Condition) gin.HandlerFunc {
 	return SetContextAny("cnd", cnd)
 }
 
+// GetContextDatabase 获取请求使用的数据库，配置了租户数据库解析时返回租户的数据库
 func GetContextDatabase(c *gin.Context) (*gom.DB, bool) {
+	if i, ok := GetContextAny(c, "tenantDb"); ok {
+		return i.(*gom.DB), true
+	}
 	i, ok := GetContextAny(c, "db")
 	if ok {
 		return i.(*gom.DB), ok
 	}
 	return nil, ok
 }
-func SetContextDatabase(db *gom.DB) gin.HandlerFunc {
-	return SetContextAny("db", db)
+
+// SetContextDatabase 设置请求使用的数据库，router 不为空时列表和详情查询按主从路由选择数据库
+func SetContextDatabase(db *gom.DB, router ...*DBRouter) gin.HandlerFunc {
+	var r *DBRouter
+	if len(router) > 0 {
+		r = router[0]
+	}
+	return func(c *gin.Context) {
+		c.Set(prefix+"db", db)
+		if r != nil {
+			c.Set(prefix+"dbRouter", r)
+		}
+	}
 }
 func SetContextAny(name string, i any) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -958,9 +1336,20 @@ func DoTableStruct() gin.HandlerFunc {
 			return
 		}
 
-		tableStruct, er := db.GetTableStruct2(i)
+		ctx := requestContext(c)
+		if er := ctx.Err(); er != nil {
+			renderDBError(c, 500, er)
+			return
+		}
+		bound, release, er := requestDB(ctx, db)
 		if er != nil {
-			RenderErr2(c, 500, er.Error())
+			renderDBError(c, 500, er)
+			return
+		}
+		defer release()
+		tableStruct, er := bound.GetTableStruct2(i)
+		if er != nil {
+			renderDBError(c, 500, er)
 			return
 		}
 		RenderOk(c, tableStruct)
@@ -1082,4 +1471,11 @@ const (
 	PathUpdate      DefaultRoutePath = "update"
 	PathDelete      DefaultRoutePath = "delete"
 	PathTableStruct DefaultRoutePath = "struct"
+	PathHistory     DefaultRoutePath = "history"
+	PathRevisions   DefaultRoutePath = "revisions"
+	PathRollback    DefaultRoutePath = "rollback"
+	PathRedeliver   DefaultRoutePath = "redeliver"
+	PathWatch       DefaultRoutePath = "watch"
+	PathWatchWs     DefaultRoutePath = "watch/ws"
+	PathPatch       DefaultRoutePath = "patch" // REST 风格下 PATCH 接口的名称
 )
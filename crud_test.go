@@ -83,6 +83,7 @@ func TestCRUDOperations(t *testing.T) {
 		[]string{"name"},
 		[]ConditionParam{{QueryName: "name", Operation: define.OpEq}},
 		[]ConditionParam{{QueryName: "id", Operation: define.OpEq}},
+		nil,
 	)
 
 	assert.NoError(t, err)
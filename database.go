@@ -0,0 +1,111 @@
+package crud
+
+import (
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4"
+)
+
+// TableOverride 单个表的配置，覆盖 DatabaseOptions 中的默认值
+type TableOverride struct {
+	Route   string   // 路由名称，默认为表名
+	Options []Option // 追加在 DatabaseOptions.Options 之后的资源配置
+}
+
+// DatabaseOptions RegisterDatabase 的配置
+type DatabaseOptions struct {
+	Include []string                 // 要注册的表，支持 path.Match 的通配符，为空表示所有表
+	Exclude []string                 // 不注册的表，支持通配符，优先于 Include
+	Options []Option                 // 所有表共用的资源配置，如 WithReadOnly、WithOperationMiddleware
+	Tables  map[string]TableOverride // 表名 -> 单个表的配置
+}
+
+// matchTable 判断表名是否匹配通配符列表
+func matchTable(patterns []string, table string) (bool, error) {
+	for _, pattern := range patterns {
+		ok, er := path.Match(pattern, table)
+		if er != nil {
+			return false, fmt.Errorf("invalid table pattern [%s]: %w", pattern, er)
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// selectTables 按 Include、Exclude 过滤表名并排序
+func (o DatabaseOptions) selectTables(tables []string) ([]string, error) {
+	selected := make([]string, 0, len(tables))
+	for _, table := range tables {
+		if len(o.Include) > 0 {
+			ok, er := matchTable(o.Include, table)
+			if er != nil {
+				return nil, er
+			}
+			if !ok {
+				continue
+			}
+		}
+		excluded, er := matchTable(o.Exclude, table)
+		if er != nil {
+			return nil, er
+		}
+		if !excluded {
+			selected = append(selected, table)
+		}
+	}
+	sort.Strings(selected)
+	return selected, nil
+}
+
+// RegisterDatabase 通过数据库元数据发现表，为每个表注册一个无结构体资源(见 NewTableCrud)
+// 每个资源的接口文档以其路由名称分组注册到 API 文档中，返回 路由名称 -> 资源
+func RegisterDatabase(routes gin.IRoutes, db *gom.DB, opts DatabaseOptions) (map[string]ICrud, error) {
+	tables, er := db.GetTables("")
+	if er != nil {
+		return nil, er
+	}
+	tables, er = opts.selectTables(tables)
+	if er != nil {
+		return nil, er
+	}
+	schemas := make([]*TableSchema, 0, len(tables))
+	for _, table := range tables {
+		schema, er := NewTableSchema(db, table)
+		if er != nil {
+			return nil, fmt.Errorf("read table [%s]: %w", table, er)
+		}
+		schemas = append(schemas, schema)
+	}
+	return registerSchemas(routes, db, schemas, opts)
+}
+
+// registerSchemas 按表结构生成并注册资源
+func registerSchemas(routes gin.IRoutes, db *gom.DB, schemas []*TableSchema, opts DatabaseOptions) (map[string]ICrud, error) {
+	resources := make(map[string]ICrud, len(schemas))
+	for _, schema := range schemas {
+		table := schema.Info.TableName
+		override := opts.Tables[table]
+		route := override.Route
+		if route == "" {
+			route = table
+		}
+		if _, ok := resources[route]; ok {
+			return nil, fmt.Errorf("duplicate route [%s] for table [%s]", route, table)
+		}
+		options := append(append([]Option{}, opts.Options...), override.Options...)
+		resource, er := newTableCrud(route, db, schema, tableColumns{}, options...)
+		if er != nil {
+			return nil, fmt.Errorf("table [%s]: %w", table, er)
+		}
+		if er := resource.Register(routes); er != nil {
+			return nil, fmt.Errorf("table [%s]: %w", table, er)
+		}
+		resources[route] = resource
+	}
+	return resources, nil
+}
@@ -0,0 +1,44 @@
+package crud
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectTables(t *testing.T) {
+	opts := DatabaseOptions{Include: []string{"shop_*", "users"}, Exclude: []string{"*_log"}}
+	tables, er := opts.selectTables([]string{"users", "shop_orders", "shop_order_log", "migrations", "shop_items"})
+	assert.NoError(t, er)
+	assert.Equal(t, []string{"shop_items", "shop_orders", "users"}, tables)
+
+	all, er := DatabaseOptions{}.selectTables([]string{"b", "a"})
+	assert.NoError(t, er)
+	assert.Equal(t, []string{"a", "b"}, all)
+
+	_, er = DatabaseOptions{Include: []string{"["}}.selectTables([]string{"a"})
+	assert.Error(t, er)
+}
+
+func TestRegisterSchemas(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	notes, _ := newTableSchema(testTableInfo("db_notes", true))
+	logs, _ := newTableSchema(testTableInfo("db_logs", true))
+	r := gin.New()
+	resources, er := registerSchemas(r.Group("/admin"), nil, []*TableSchema{notes, logs}, DatabaseOptions{
+		Options: []Option{WithReadOnly()},
+		Tables:  map[string]TableOverride{"db_logs": {Route: "audit_logs"}},
+	})
+	assert.NoError(t, er)
+	assert.Len(t, resources, 2)
+
+	paths := make(map[string]bool)
+	for _, route := range r.Routes() {
+		paths[route.Method+" "+route.Path] = true
+	}
+	assert.True(t, paths["GET /admin/db_notes/list"])
+	assert.True(t, paths["GET /admin/audit_logs/detail"])
+	assert.False(t, paths["POST /admin/db_notes/add"])
+	assert.Len(t, globalAPIRegistry.GetAPIsByGroup("audit_logs"), 2)
+}
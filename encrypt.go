@@ -0,0 +1,289 @@
+package crud
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4/define"
+)
+
+// 密文格式：enc:{keyId}:{base64(nonce+密文)}
+const encryptedPrefix = "enc:"
+
+// KeyProvider 加密密钥提供者，通过密钥ID支持密钥轮换
+// 新数据总是使用 CurrentKey 加密，解密时根据密文中的密钥ID取对应的密钥
+type KeyProvider interface {
+	CurrentKey() (keyId string, key []byte, err error)
+	GetKey(keyId string) ([]byte, error)
+}
+
+// StaticKeyProvider 基于内存的密钥提供者
+type StaticKeyProvider struct {
+	Current string            // 当前用于加密的密钥ID
+	Keys    map[string][]byte // 密钥ID -> 密钥，密钥长度为16/24/32字节
+}
+
+func (p StaticKeyProvider) CurrentKey() (string, []byte, error) {
+	key, er := p.GetKey(p.Current)
+	return p.Current, key, er
+}
+
+func (p StaticKeyProvider) GetKey(keyId string) ([]byte, error) {
+	key, ok := p.Keys[keyId]
+	if !ok {
+		return nil, fmt.Errorf("encryption key [%s] not found", keyId)
+	}
+	return key, nil
+}
+
+// WithEncryption 设置加密字段使用的密钥提供者，blindIndexKey 用于计算盲索引，没有盲索引列时可为 nil，不能为空字节
+func WithEncryption(provider KeyProvider, blindIndexKey []byte) Option {
+	return func(o *Options) {
+		if blindIndexKey != nil && len(blindIndexKey) == 0 {
+			o.addError(errors.New("blind index key could not be empty"))
+		}
+		o.KeyProvider = provider
+		o.BlindIndexKey = blindIndexKey
+	}
+}
+
+// WithEncryptedField 设置加密字段，blindIndexColumn 不为空时写入时同时保存盲索引，用于等值查询
+func WithEncryptedField(field string, blindIndexColumn string) Option {
+	return func(o *Options) {
+		if o.Encrypted == nil {
+			o.Encrypted = make(map[string]string)
+		}
+		o.Encrypted[o.columnName(field)] = blindIndexColumn
+	}
+}
+
+// encryptTagsOf 读取结构体字段上的 encrypt 和 blindIndex 标签，返回 列名 -> 盲索引列名
+func encryptTagsOf(i any) map[string]string {
+	encrypted := make(map[string]string)
+	if i == nil {
+		return encrypted
+	}
+	t := reflect.TypeOf(i)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return encrypted
+	}
+	for idx := 0; idx < t.NumField(); idx++ {
+		field := t.Field(idx)
+		col := strings.Split(field.Tag.Get("gom"), ",")[0]
+		if field.Tag.Get("encrypt") == "true" && col != "" && col != "-" {
+			encrypted[col] = field.Tag.Get("blindIndex")
+		}
+	}
+	return encrypted
+}
+
+// validateEncryption 加密字段需要密钥提供者，有盲索引列时需要盲索引密钥，结构体中的加密字段必须为字符串
+func (o *Options) validateEncryption() error {
+	cols := make([]string, 0, len(o.Encrypted))
+	for col := range o.Encrypted {
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+	errs := make([]error, 0)
+	for _, col := range cols {
+		if o.KeyProvider == nil {
+			errs = append(errs, fmt.Errorf("encrypted field [%s] has no key provider", col))
+		}
+		if o.Encrypted[col] != "" && len(o.BlindIndexKey) == 0 {
+			errs = append(errs, fmt.Errorf("blind index of encrypted field [%s] requires a blind index key", col))
+		}
+		if kind, ok := columnKindOf(o.entity, col); ok && kind != reflect.String {
+			errs = append(errs, fmt.Errorf("encrypted field [%s] should be a string, got %s", col, kind))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// columnKindOf 结构体中列对应字段的类型，指针取其元素的类型
+func columnKindOf(i any, col string) (reflect.Kind, bool) {
+	if i == nil {
+		return reflect.Invalid, false
+	}
+	t := reflect.TypeOf(i)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return reflect.Invalid, false
+	}
+	for idx := 0; idx < t.NumField(); idx++ {
+		field := t.Field(idx)
+		if strings.Split(field.Tag.Get("gom"), ",")[0] != col {
+			continue
+		}
+		ft := field.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		return ft.Kind(), true
+	}
+	return reflect.Invalid, false
+}
+
+// EncryptValue 使用当前密钥以 AES-GCM 加密，col 作为附加数据防止密文被挪用到其他列
+func EncryptValue(provider KeyProvider, col string, plain string) (string, error) {
+	if provider == nil {
+		return "", errors.New("no key provider for encrypted field")
+	}
+	keyId, key, er := provider.CurrentKey()
+	if er != nil {
+		return "", er
+	}
+	gcm, er := newGCM(key)
+	if er != nil {
+		return "", er
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, er := io.ReadFull(rand.Reader, nonce); er != nil {
+		return "", er
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plain), []byte(col))
+	return encryptedPrefix + keyId + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptValue 解密 EncryptValue 生成的密文，空值原样返回，其余不是密文格式的值返回错误
+func DecryptValue(provider KeyProvider, col string, value string) (string, error) {
+	if value == "" {
+		return value, nil
+	}
+	if !strings.HasPrefix(value, encryptedPrefix) {
+		return "", fmt.Errorf("value of encrypted field [%s] is not encrypted", col)
+	}
+	if provider == nil {
+		return "", errors.New("no key provider for encrypted field")
+	}
+	parts := strings.SplitN(strings.TrimPrefix(value, encryptedPrefix), ":", 2)
+	if len(parts) != 2 {
+		return "", errors.New("malformed encrypted value")
+	}
+	key, er := provider.GetKey(parts[0])
+	if er != nil {
+		return "", er
+	}
+	sealed, er := base64.StdEncoding.DecodeString(parts[1])
+	if er != nil {
+		return "", er
+	}
+	gcm, er := newGCM(key)
+	if er != nil {
+		return "", er
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("malformed encrypted value")
+	}
+	plain, er := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(col))
+	if er != nil {
+		return "", er
+	}
+	return string(plain), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, er := aes.NewCipher(key)
+	if er != nil {
+		return nil, er
+	}
+	return cipher.NewGCM(block)
+}
+
+// BlindIndex 计算确定性的盲索引，相同的明文总是得到相同的结果
+func BlindIndex(key []byte, col string, plain string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(col))
+	mac.Write([]byte{0})
+	mac.Write([]byte(plain))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// encryptFields 加密要写入的列，并写入对应的盲索引列
+func encryptFields(opts *Options, fields map[string]any) error {
+	if opts == nil || len(opts.Encrypted) == 0 {
+		return nil
+	}
+	for col, blindCol := range opts.Encrypted {
+		val, ok := fields[col]
+		if !ok || val == nil {
+			continue
+		}
+		plain := fmt.Sprint(val)
+		cipherText, er := EncryptValue(opts.KeyProvider, col, plain)
+		if er != nil {
+			return er
+		}
+		fields[col] = cipherText
+		if blindCol != "" {
+			fields[blindCol] = BlindIndex(opts.BlindIndexKey, col, plain)
+		}
+	}
+	return nil
+}
+
+// DecryptData 解密查询结果中的加密字段，支持结构体、map及其切片和指针
+func DecryptData(c *gin.Context, data any) error {
+	opts, ok := GetContextOptions(c)
+	if !ok || len(opts.Encrypted) == 0 {
+		return nil
+	}
+	return walkStringFields(reflect.ValueOf(data), func(col string, s string) (string, bool, error) {
+		if _, ok := opts.Encrypted[col]; !ok {
+			return s, false, nil
+		}
+		plain, er := DecryptValue(opts.KeyProvider, col, s)
+		return plain, true, er
+	})
+}
+
+// rewriteEncryptedCondition 将加密字段上的等值条件改写为盲索引列上的条件
+func rewriteEncryptedCondition(opts *Options, cnd *define.Condition) error {
+	if opts == nil || len(opts.Encrypted) == 0 || cnd == nil {
+		return nil
+	}
+	if blindCol, ok := opts.Encrypted[cnd.Field]; ok {
+		if blindCol == "" {
+			return fmt.Errorf("encrypted field [%s] could not be used as condition", cnd.Field)
+		}
+		switch cnd.Op {
+		case define.OpEq, define.OpNe:
+			cnd.Value = BlindIndex(opts.BlindIndexKey, cnd.Field, fmt.Sprint(cnd.Value))
+		case define.OpIn, define.OpNotIn:
+			values, ok := cnd.Value.([]interface{})
+			if !ok {
+				return fmt.Errorf("unsupported values for encrypted field [%s]", cnd.Field)
+			}
+			indexes := make([]interface{}, len(values))
+			for idx, v := range values {
+				indexes[idx] = BlindIndex(opts.BlindIndexKey, cnd.Field, fmt.Sprint(v))
+			}
+			cnd.Value = indexes
+		default:
+			return fmt.Errorf("encrypted field [%s] only supports equality conditions", cnd.Field)
+		}
+		cnd.Field = blindCol
+	}
+	for _, sub := range cnd.SubConds {
+		if er := rewriteEncryptedCondition(opts, sub); er != nil {
+			return er
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package crud
+
+import (
+	"testing"
+
+	"github.com/kmlixh/gom/v4/define"
+	"github.com/stretchr/testify/assert"
+)
+
+type TestPatient struct {
+	ID    int64  `json:"id" gom:"id,@"`
+	Name  string `json:"name" gom:"name"`
+	Phone string `json:"phone" gom:"phone" encrypt:"true" blindIndex:"phone_bidx"`
+}
+
+func TestEncryptValue(t *testing.T) {
+	provider := StaticKeyProvider{Current: "k1", Keys: map[string][]byte{"k1": []byte("0123456789abcdef0123456789abcdef")}}
+	cipherText, err := EncryptValue(provider, "phone", "13812341234")
+	assert.NoError(t, err)
+	assert.Contains(t, cipherText, "enc:k1:")
+
+	// 轮换密钥后旧数据仍然可以解密
+	provider.Current = "k2"
+	provider.Keys["k2"] = []byte("fedcba9876543210")
+	plain, err := DecryptValue(provider, "phone", cipherText)
+	assert.NoError(t, err)
+	assert.Equal(t, "13812341234", plain)
+
+	_, err = DecryptValue(provider, "email", cipherText)
+	assert.Error(t, err)
+
+	_, err = DecryptValue(provider, "phone", "legacy")
+	assert.EqualError(t, err, "value of encrypted field [phone] is not encrypted")
+	plain, err = DecryptValue(provider, "phone", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "", plain)
+}
+
+type TestSecretAge struct {
+	ID  int64 `json:"id" gom:"id,@"`
+	Age int   `json:"age" gom:"age" encrypt:"true"`
+}
+
+func TestEncryptionOptions(t *testing.T) {
+	provider := StaticKeyProvider{Current: "k1", Keys: map[string][]byte{"k1": []byte("0123456789abcdef")}}
+	_, err := NewCrud2("patients", &TestPatient{}, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, WithEncryption(provider, []byte{}))
+	assert.ErrorContains(t, err, "blind index key could not be empty")
+	_, err = NewCrud2("patients", &TestPatient{}, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, WithEncryption(provider, nil))
+	assert.EqualError(t, err, "blind index of encrypted field [phone] requires a blind index key")
+	_, err = NewCrud2("ages", &TestSecretAge{}, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, WithEncryption(provider, nil))
+	assert.EqualError(t, err, "encrypted field [age] should be a string, got int")
+	_, err = NewCrud2("patients", &TestPatient{}, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, WithEncryption(provider, []byte("blind")))
+	assert.NoError(t, err)
+}
+
+func TestEncryptFieldsAndConditions(t *testing.T) {
+	provider := StaticKeyProvider{Current: "k1", Keys: map[string][]byte{"k1": []byte("0123456789abcdef")}}
+	opts := NewOptions(&TestPatient{}, WithEncryption(provider, []byte("blind")))
+
+	fields := map[string]any{"name": "test", "phone": "13812341234"}
+	assert.NoError(t, encryptFields(opts, fields))
+	assert.Equal(t, "test", fields["name"])
+	assert.NotEqual(t, "13812341234", fields["phone"])
+	assert.Equal(t, BlindIndex([]byte("blind"), "phone", "13812341234"), fields["phone_bidx"])
+
+	cnd := define.Eq("name", "test").And(define.Eq("phone", "13812341234"))
+	assert.NoError(t, rewriteEncryptedCondition(opts, cnd))
+	assert.Equal(t, "phone_bidx", cnd.SubConds[0].Field)
+	assert.Equal(t, fields["phone_bidx"], cnd.SubConds[0].Value)
+
+	assert.Error(t, rewriteEncryptedCondition(opts, define.Like("phone", "138%")))
+}
@@ -0,0 +1,214 @@
+package crud
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4"
+	"github.com/kmlixh/gom/v4/define"
+)
+
+// ErrPreconditionFailed If-Match 与数据当前的 ETag 不一致
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// WithETag 列表和详情接口输出 ETag 并支持 If-None-Match
+// 列表的 ETag 按响应内容计算；详情的 ETag 按查询出的数据行中调用者可读的详情列计算，column 不为空时(如 version、updated_at)只按该列计算，该列不可读时不输出 ETag
+// 更新和删除接口的 If-Match 按同样的方式计算详情的 ETag 进行比较
+func WithETag(column string) Option {
+	return func(o *Options) {
+		o.ETag = true
+		o.ETagColumn = column
+	}
+}
+
+// etagOf 按内容生成强 ETag
+func etagOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// rowETag 数据行的 ETag，配置了版本列时只按版本列计算
+func rowETag(row map[string]any, column string) (string, error) {
+	var value any = row
+	if column != "" {
+		if v, ok := row[column]; ok {
+			value = v
+		}
+	}
+	data, er := json.Marshal(value)
+	if er != nil {
+		return "", er
+	}
+	return etagOf(data), nil
+}
+
+// matchETag 判断 ETag 是否在请求头的列表中，weak 为 true 时忽略 W/ 前缀
+func matchETag(header string, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// etagWriter 缓存响应内容，计算出 ETag 之后再输出
+type etagWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *etagWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *etagWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+// ConditionalQuery 为查询接口输出 ETag，请求的 If-None-Match 与之相符时返回 304
+// detail 为 true 时按数据行计算 ETag，否则按响应内容计算
+func ConditionalQuery(detail bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		writer := &etagWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+		c.Writer = writer.ResponseWriter
+
+		body := writer.body.Bytes()
+		var resp CodeMsg
+		if c.Writer.Status() != http.StatusOK || json.Unmarshal(body, &resp) != nil || resp.Code != 200 || resp.Data == nil {
+			_, _ = c.Writer.Write(body)
+			return
+		}
+		etag := etagOf(body)
+		if detail {
+			// 详情的 ETag 由查询或缓存写入，与响应内容来自同一行数据
+			v, ok := GetContextAny(c, "etag")
+			if !ok {
+				_, _ = c.Writer.Write(body)
+				return
+			}
+			etag = v.(string)
+		}
+		c.Header("ETag", etag)
+		if match := c.GetHeader("If-None-Match"); match != "" && matchETag(match, etag, true) {
+			c.Writer.WriteHeader(http.StatusNotModified)
+			c.Writer.WriteHeaderNow()
+			return
+		}
+		_, _ = c.Writer.Write(body)
+	}
+}
+
+// setContextETag 按详情接口查询出的数据行计算 ETag 并写入请求，没有开启 ETag 时不做处理
+func setContextETag(c *gin.Context, row map[string]any) error {
+	opts, ok := GetContextOptions(c)
+	if !ok || !opts.ETag {
+		return nil
+	}
+	etag, er := detailETag(c, opts, row)
+	if er != nil || etag == "" {
+		return er
+	}
+	SetContextAny("etag", etag)(c)
+	return nil
+}
+
+// detailETag 数据行的 ETag，配置了版本列但数据行中没有该列时返回空
+func detailETag(c *gin.Context, opts *Options, row map[string]any) (string, error) {
+	normalized := make(map[string]any, len(row))
+	for k, v := range row {
+		normalized[k] = normalizeValue(v)
+	}
+	if opts.ETagColumn != "" {
+		if _, ok := normalized[opts.ETagColumn]; !ok {
+			return "", nil
+		}
+		return rowETag(normalized, opts.ETagColumn)
+	}
+	cols, er := etagColumns(c, opts)
+	if er != nil {
+		return "", er
+	}
+	if len(cols) > 0 {
+		picked := make(map[string]any, len(cols))
+		for _, col := range cols {
+			if v, ok := normalized[col]; ok {
+				picked[col] = v
+			}
+		}
+		normalized = picked
+	}
+	return rowETag(normalized, "")
+}
+
+// etagColumns 计算 ETag 的列，为详情接口中调用者可读的列，为空表示所有列
+func etagColumns(c *gin.Context, opts *Options) ([]string, error) {
+	cols := opts.detailColumns
+	if schema, ok := GetContextTable(c); ok {
+		if len(cols) == 0 {
+			cols = schema.ColumnNames()
+		}
+		return readableColumns(c, nil, cols)
+	}
+	i, _ := GetContextEntity(c)
+	return readableColumns(c, i, cols)
+}
+
+// checkIfMatch 在写操作的事务中校验 If-Match，受影响的每一行的 ETag 都必须相符
+func checkIfMatch(c *gin.Context, db *gom.DB, tx *gom.Chain, table string, cnd *define.Condition) error {
+	match := c.GetHeader("If-Match")
+	rows, er := queryRows(db, tx, table, cnd, 0)
+	if er != nil {
+		return er
+	}
+	if len(rows) == 0 {
+		return ErrPreconditionFailed
+	}
+	opts, ok := GetContextOptions(c)
+	if !ok {
+		opts = &Options{}
+	}
+	for _, row := range rows {
+		etag, er := detailETag(c, opts, row)
+		if er != nil {
+			return er
+		}
+		if etag == "" || !matchETag(match, etag, false) {
+			return ErrPreconditionFailed
+		}
+	}
+	return nil
+}
+
+// applyETag 为列表和详情接口加入 ETag 处理
+func applyETag(opts *Options, handlers []RouteHandler) {
+	if !opts.ETag {
+		return
+	}
+	for idx := range handlers {
+		switch DefaultRoutePath(handlers[idx].Path) {
+		case PathList:
+			handlers[idx].Handlers = insertBeforeLast(handlers[idx].Handlers, ConditionalQuery(false))
+		case PathDetail:
+			handlers[idx].Handlers = insertBeforeLast(handlers[idx].Handlers, ConditionalQuery(true))
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package crud
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4/define"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestETagMatch(t *testing.T) {
+	etag, er := rowETag(map[string]any{"id": 1, "version": 3, "title": "a"}, "version")
+	assert.NoError(t, er)
+	same, _ := rowETag(map[string]any{"id": 1, "version": 3, "title": "b"}, "version")
+	assert.Equal(t, etag, same)
+	changed, _ := rowETag(map[string]any{"id": 1, "title": "b"}, "")
+	assert.NotEqual(t, etag, changed)
+
+	assert.True(t, matchETag(`"x", `+etag, etag, false))
+	assert.True(t, matchETag("*", etag, false))
+	assert.False(t, matchETag("W/"+etag, etag, false))
+	assert.True(t, matchETag("W/"+etag, etag, true))
+}
+
+func TestConditionalQuery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/notes/list", ConditionalQuery(false), func(c *gin.Context) {
+		RenderOk(c, []string{"a", "b"})
+	})
+	r.POST("/notes/update", func(c *gin.Context) {
+		renderDBError(c, 500, ErrPreconditionFailed)
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/notes/list", nil))
+	etag := w.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+	assert.JSONEq(t, `{"code":200,"msg":"ok","data":["a","b"]}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/notes/list", nil)
+	req.Header.Set("If-None-Match", etag)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 304, w.Code)
+	assert.Empty(t, w.Body.String())
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/notes/update", nil))
+	assert.Equal(t, 412, w.Code)
+	assert.JSONEq(t, `{"code":412,"msg":"precondition failed","data":null}`, w.Body.String())
+}
+
+func TestDetailETagFromQueriedRow(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	no := "A"
+	selects, updates := 0, 0
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, int64, error) {
+		switch {
+		case strings.HasPrefix(query, "SELECT * FROM `etag_orders`"):
+			// If-Match 校验读取整行，ETag 只按详情的列计算
+			return []string{"id", "customer_id", "no"}, [][]driver.Value{{int64(7), int64(3), no}}, 0, nil
+		case strings.HasPrefix(query, "SELECT"):
+			selects++
+			return []string{"id", "no"}, [][]driver.Value{{int64(7), no}}, 0, nil
+		case strings.HasPrefix(query, "UPDATE"):
+			updates++
+			return nil, nil, 1, nil
+		}
+		return nil, nil, 0, fmt.Errorf("unexpected query: %s", query)
+	})
+	idParam := []ConditionParam{{QueryName: "idEq", ColName: "id", Operation: define.OpEq}}
+	orders, er := NewCrud2("etag_orders", &etagOrder{}, db, []string{"id", "no"}, idParam, []string{"id", "no"}, idParam, []string{"no"}, []string{"no"}, idParam, idParam, nil,
+		WithETag(""), WithCache(NewMemoryCacheStore(0), time.Minute))
+	assert.NoError(t, er)
+	r := gin.New()
+	assert.NoError(t, orders.Register(r.Group("/api")))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/api/etag_orders/detail?idEq=7", nil))
+	etag := w.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+	assert.Equal(t, 1, selects)
+
+	// 命中缓存时不再查询，ETag 与缓存一起保存
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/api/etag_orders/detail?idEq=7", nil)
+	req.Header.Set("If-None-Match", etag)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 304, w.Code)
+	assert.Equal(t, 1, selects)
+
+	// 详情返回的 ETag 可以直接用于 If-Match
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/api/etag_orders/update", strings.NewReader(`{"idEq":7,"no":"B"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", etag)
+	r.ServeHTTP(w, req)
+	assert.Contains(t, w.Body.String(), `"code":200`)
+	assert.Equal(t, 1, updates)
+}
+
+type etagOrder struct {
+	ID         int64  `json:"id" gom:"id,@,auto"`
+	CustomerId int64  `json:"customerId" gom:"customer_id"`
+	No         string `json:"no" gom:"no"`
+}
+
+func (etagOrder) TableName() string {
+	return "etag_orders"
+}
@@ -0,0 +1,290 @@
+package crud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/kmlixh/gom/v4"
+	"github.com/redis/go-redis/v9"
+)
+
+// ChangeEvent 数据变更事件，每一行数据的新增、更新、删除成功后产生一个事件
+type ChangeEvent struct {
+	Id        string         `json:"id"`        // 事件ID
+	Resource  string         `json:"resource"`  // 资源名称
+	Operation ChangeType     `json:"operation"` // 变更类型
+	Keys      map[string]any `json:"keys"`      // 主键
+	Row       map[string]any `json:"row"`       // 变更后的整行数据，删除时为删除前的数据
+	Actor     Actor          `json:"actor"`     // 发起变更的用户
+	Time      time.Time      `json:"time"`      // 变更时间
+}
+
+// NewChangeEvent 根据变更生成事件
+func NewChangeEvent(change *Change) ChangeEvent {
+	row := change.After
+	if change.Operation == ChangeDelete {
+		row = change.Before
+	}
+	return ChangeEvent{
+		Id:        uuid.NewString(),
+		Resource:  change.Resource,
+		Operation: change.Operation,
+		Keys:      change.Keys,
+		Row:       row,
+		Actor:     change.Actor,
+		Time:      change.Time,
+	}
+}
+
+// EventPublisher 变更事件的发布者
+type EventPublisher interface {
+	Publish(ctx context.Context, events ...ChangeEvent) error
+}
+
+// MemoryEventPublisher 基于内存的事件发布者，适用于单进程内订阅和测试
+type MemoryEventPublisher struct {
+	mu          sync.RWMutex
+	subscribers map[int]chan ChangeEvent
+	nextId      int
+}
+
+func NewMemoryEventPublisher() *MemoryEventPublisher {
+	return &MemoryEventPublisher{subscribers: make(map[int]chan ChangeEvent)}
+}
+
+// Subscribe 订阅事件，返回事件通道和取消订阅的函数
+// 通道缓冲区满时新的事件会被丢弃，不会阻塞写操作
+func (p *MemoryEventPublisher) Subscribe(buffer int) (<-chan ChangeEvent, func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	id := p.nextId
+	p.nextId++
+	ch := make(chan ChangeEvent, buffer)
+	p.subscribers[id] = ch
+	return ch, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if _, ok := p.subscribers[id]; ok {
+			delete(p.subscribers, id)
+			close(ch)
+		}
+	}
+}
+
+func (p *MemoryEventPublisher) Publish(ctx context.Context, events ...ChangeEvent) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, event := range events {
+		for _, ch := range p.subscribers {
+			select {
+			case ch <- event:
+			default:
+			}
+		}
+	}
+	return nil
+}
+
+// RedisStreamPublisher 将事件写入 Redis Stream，每个资源一个 Stream
+type RedisStreamPublisher struct {
+	client *redis.Client
+	prefix string
+	maxLen int64
+}
+
+// NewRedisStreamPublisher 创建基于 Redis Stream 的事件发布者
+// Stream 的名称为 prefix + 资源名称，prefix 为空时使用 "crud:events:"，maxLen 大于0时按近似长度裁剪 Stream
+func NewRedisStreamPublisher(client *redis.Client, prefix string, maxLen int64) *RedisStreamPublisher {
+	if prefix == "" {
+		prefix = "crud:events:"
+	}
+	return &RedisStreamPublisher{client: client, prefix: prefix, maxLen: maxLen}
+}
+
+// StreamOf 获取资源对应的 Stream 名称
+func (p *RedisStreamPublisher) StreamOf(resource string) string {
+	return p.prefix + resource
+}
+
+func (p *RedisStreamPublisher) Publish(ctx context.Context, events ...ChangeEvent) error {
+	pipe := p.client.Pipeline()
+	for _, event := range events {
+		data, er := json.Marshal(event)
+		if er != nil {
+			return er
+		}
+		args := &redis.XAddArgs{
+			Stream: p.StreamOf(event.Resource),
+			Values: map[string]any{
+				"id":        event.Id,
+				"operation": string(event.Operation),
+				"event":     string(data),
+			},
+		}
+		if p.maxLen > 0 {
+			args.MaxLen = p.maxLen
+			args.Approx = true
+		}
+		pipe.XAdd(ctx, args)
+	}
+	_, er := pipe.Exec(ctx)
+	return er
+}
+
+// EventHook 在事务提交后发布变更事件的变更钩子，发布失败的事件会丢失，需要保证送达时使用 Outbox
+type EventHook struct {
+	Publisher EventPublisher
+	OnError   func(event ChangeEvent, err error) // 发布失败时的回调，可为空
+}
+
+func (h EventHook) InTx(c *gin.Context, tx *gom.Chain, change *Change) error {
+	return nil
+}
+
+func (h EventHook) AfterCommit(c *gin.Context, change *Change) {
+	event := NewChangeEvent(change)
+	if er := h.Publisher.Publish(c, event); er != nil && h.OnError != nil {
+		h.OnError(event, er)
+	}
+}
+
+// WithEventPublisher 开启变更事件，新增、更新、删除成功后发布事件
+func WithEventPublisher(publisher EventPublisher) Option {
+	return func(o *Options) {
+		o.Hooks = append(o.Hooks, EventHook{Publisher: publisher})
+	}
+}
+
+// OutboxEvent 事务发件箱中的一条事件
+type OutboxEvent struct {
+	ID          int64      `json:"id" gom:"id,@,auto"`
+	EventId     string     `json:"eventId" gom:"event_id"`
+	Resource    string     `json:"resource" gom:"resource"`
+	Payload     string     `json:"payload" gom:"payload"` // 事件内容，JSON格式
+	CreatedAt   time.Time  `json:"createdAt" gom:"created_at"`
+	PublishedAt *time.Time `json:"publishedAt" gom:"published_at"` // 发布时间，未发布时为空
+}
+
+// Outbox 事务发件箱，事件与数据变更在同一事务中写入数据库，提交后再发布
+// 进程在发布前崩溃时，未发布的事件由 Relay 重新发布，因此事件至少送达一次
+// AfterCommit 与 Relay 并发时同一事件可能被发布两次，消费者应按事件 Id 去重
+type Outbox struct {
+	DB        *gom.DB
+	Table     string
+	Publisher EventPublisher
+}
+
+// NewOutbox 创建事务发件箱，table 为空时使用 crud_event_outbox
+func NewOutbox(db *gom.DB, table string, publisher EventPublisher) *Outbox {
+	if table == "" {
+		table = "crud_event_outbox"
+	}
+	return &Outbox{DB: db, Table: table, Publisher: publisher}
+}
+
+// InTx 在数据变更的事务中写入事件
+func (o *Outbox) InTx(c *gin.Context, tx *gom.Chain, change *Change) error {
+	event := NewChangeEvent(change)
+	data, er := json.Marshal(event)
+	if er != nil {
+		return er
+	}
+	c.Set(prefix+"outbox_"+outboxKey(change), event)
+	return insertRow(o.DB, tx, o.Table, map[string]any{
+		"event_id":   event.Id,
+		"resource":   event.Resource,
+		"payload":    string(data),
+		"created_at": event.Time,
+	})
+}
+
+// AfterCommit 事务提交后立即尝试发布，失败时留给 Relay 处理
+func (o *Outbox) AfterCommit(c *gin.Context, change *Change) {
+	key := prefix + "outbox_" + outboxKey(change)
+	val, ok := c.Get(key)
+	if !ok {
+		return
+	}
+	event := val.(ChangeEvent)
+	if er := o.Publisher.Publish(c, event); er != nil {
+		return
+	}
+	_ = o.markPublished(event.Id)
+}
+
+func outboxKey(change *Change) string {
+	return fmt.Sprintf("%s:%s:%s", change.Resource, change.Operation, keyString(change.Keys))
+}
+
+func (o *Outbox) markPublished(eventIds ...string) error {
+	if len(eventIds) == 0 {
+		return nil
+	}
+	ids := make([]any, len(eventIds))
+	for idx, id := range eventIds {
+		ids[idx] = id
+	}
+	result := o.DB.Chain().Table(o.Table).In("event_id", ids).Update(map[string]any{"published_at": time.Now()})
+	return result.Error
+}
+
+// Relay 按写入顺序发布最多 batch 条未发布的事件，返回发布的数量
+// 发布成功但标记 published_at 失败时，下一次 Relay 会再次发布这些事件
+func (o *Outbox) Relay(ctx context.Context, batch int) (int, error) {
+	rows := make([]OutboxEvent, 0)
+	result := o.DB.Chain().Table(o.Table).Fields("event_id", "payload").IsNull("published_at").OrderBy("id").Limit(batch).List()
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if er := result.Into(&rows); er != nil {
+		return 0, er
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	events := make([]ChangeEvent, 0, len(rows))
+	eventIds := make([]string, 0, len(rows))
+	for _, row := range rows {
+		var event ChangeEvent
+		if er := json.Unmarshal([]byte(row.Payload), &event); er != nil {
+			return 0, er
+		}
+		events = append(events, event)
+		eventIds = append(eventIds, row.EventId)
+	}
+	if er := o.Publisher.Publish(ctx, events...); er != nil {
+		return 0, er
+	}
+	return len(events), o.markPublished(eventIds...)
+}
+
+// RunRelay 每隔 interval 发布一次未发布的事件，直到 ctx 结束
+func (o *Outbox) RunRelay(ctx context.Context, interval time.Duration, batch int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for {
+				n, er := o.Relay(ctx, batch)
+				if er != nil || n < batch {
+					break
+				}
+			}
+		}
+	}
+}
+
+// WithOutbox 通过事务发件箱发布变更事件，事件与数据变更一起提交
+func WithOutbox(outbox *Outbox) Option {
+	return func(o *Options) {
+		o.Hooks = append(o.Hooks, outbox)
+	}
+}
@@ -0,0 +1,258 @@
+package crud
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryEventPublisher(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/api/users/delete", nil)
+
+	publisher := NewMemoryEventPublisher()
+	events, cancel := publisher.Subscribe(4)
+	defer cancel()
+
+	change := &Change{
+		Resource:  "users",
+		Operation: ChangeDelete,
+		Keys:      map[string]any{"id": int64(7)},
+		Before:    map[string]any{"id": int64(7), "name": "tom"},
+		Actor:     Actor{UserId: "u1"},
+		Time:      time.Now(),
+	}
+	EventHook{Publisher: publisher}.AfterCommit(c, change)
+
+	select {
+	case event := <-events:
+		assert.NotEmpty(t, event.Id)
+		assert.Equal(t, "users", event.Resource)
+		assert.Equal(t, ChangeDelete, event.Operation)
+		assert.Equal(t, "tom", event.Row["name"])
+		assert.Equal(t, "u1", event.Actor.UserId)
+	default:
+		t.Fatal("event not published")
+	}
+}
+
+type failingPublisher struct{}
+
+func (failingPublisher) Publish(ctx context.Context, events ...ChangeEvent) error {
+	return errors.New("broker down")
+}
+
+func TestEventHookOnError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/api/users/add", nil)
+
+	var failed error
+	hook := EventHook{Publisher: failingPublisher{}, OnError: func(event ChangeEvent, err error) {
+		failed = err
+	}}
+	hook.AfterCommit(c, &Change{Resource: "users", Operation: ChangeInsert, Keys: map[string]any{"id": 1}})
+	assert.EqualError(t, failed, "broker down")
+}
+
+// recordingPublisher 记录发布的事件，failures 大于 0 时前几次发布失败
+type recordingPublisher struct {
+	mu       sync.Mutex
+	failures int
+	events   []ChangeEvent
+}
+
+func (p *recordingPublisher) Publish(ctx context.Context, events ...ChangeEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failures > 0 {
+		p.failures--
+		return errors.New("broker down")
+	}
+	p.events = append(p.events, events...)
+	return nil
+}
+
+func (p *recordingPublisher) resources() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	result := make([]string, 0, len(p.events))
+	for _, event := range p.events {
+		result = append(result, event.Resource)
+	}
+	return result
+}
+
+// fakeOutboxTable 内存中的发件箱表，只处理 Outbox 生成的 INSERT、SELECT 和 UPDATE
+type fakeOutboxTable struct {
+	mu   sync.Mutex
+	rows [][]driver.Value // id, event_id, resource, payload, created_at, published_at
+}
+
+func (f *fakeOutboxTable) handle(query string, args []driver.Value) ([]string, [][]driver.Value, int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case strings.HasPrefix(query, "INSERT INTO `crud_event_outbox`"):
+		// 列按名称排序: created_at, event_id, payload, resource
+		f.rows = append(f.rows, []driver.Value{int64(len(f.rows) + 1), args[1], args[3], args[2], args[0], nil})
+		return nil, nil, 1, nil
+	case strings.HasPrefix(query, "SELECT `event_id`, `payload` FROM `crud_event_outbox` WHERE `published_at` IS NULL ORDER BY `id` ASC LIMIT "):
+		var limit int
+		fmt.Sscanf(query[strings.LastIndex(query, " ")+1:], "%d", &limit)
+		rows := make([][]driver.Value, 0)
+		for _, row := range f.rows {
+			if row[5] == nil && len(rows) < limit {
+				rows = append(rows, []driver.Value{row[1], row[3]})
+			}
+		}
+		return []string{"event_id", "payload"}, rows, 0, nil
+	case strings.HasPrefix(query, "UPDATE `crud_event_outbox` SET `published_at` = ? WHERE `event_id` IN "):
+		var affected int64
+		for _, row := range f.rows {
+			for _, id := range args[1:] {
+				if row[1] == id {
+					row[5] = args[0]
+					affected++
+				}
+			}
+		}
+		return nil, nil, affected, nil
+	}
+	return nil, nil, 0, fmt.Errorf("unexpected query: %s", query)
+}
+
+func (f *fakeOutboxTable) published() []bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	result := make([]bool, 0, len(f.rows))
+	for _, row := range f.rows {
+		result = append(result, row[5] != nil)
+	}
+	return result
+}
+
+func newOutboxTestContext() *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/api/users/add", nil)
+	return c
+}
+
+func TestOutboxRelay(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	table := &fakeOutboxTable{}
+	db := newFakeDB(t, table.handle)
+	publisher := &recordingPublisher{failures: 1}
+	outbox := NewOutbox(db, "", publisher)
+
+	c := newOutboxTestContext()
+	for idx, resource := range []string{"users", "orders", "items"} {
+		change := &Change{Resource: resource, Operation: ChangeInsert, Keys: map[string]any{"id": idx + 1}, Time: time.Now()}
+		assert.NoError(t, outbox.InTx(c, db.Chain(), change))
+	}
+	assert.Equal(t, []bool{false, false, false}, table.published())
+
+	// 发布失败时不标记，下一次 Relay 重新发布
+	n, er := outbox.Relay(context.Background(), 2)
+	assert.EqualError(t, er, "broker down")
+	assert.Equal(t, 0, n)
+	assert.Equal(t, []bool{false, false, false}, table.published())
+
+	// 按写入顺序分批发布并标记 published_at
+	n, er = outbox.Relay(context.Background(), 2)
+	assert.NoError(t, er)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []bool{true, true, false}, table.published())
+	n, er = outbox.Relay(context.Background(), 2)
+	assert.NoError(t, er)
+	assert.Equal(t, 1, n)
+	n, er = outbox.Relay(context.Background(), 2)
+	assert.NoError(t, er)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, []string{"users", "orders", "items"}, publisher.resources())
+}
+
+func TestOutboxAfterCommitAndRunRelay(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	table := &fakeOutboxTable{}
+	db := newFakeDB(t, table.handle)
+	publisher := &recordingPublisher{failures: 1}
+	outbox := NewOutbox(db, "", publisher)
+
+	c := newOutboxTestContext()
+	first := &Change{Resource: "users", Operation: ChangeInsert, Keys: map[string]any{"id": 1}, Time: time.Now()}
+	second := &Change{Resource: "orders", Operation: ChangeInsert, Keys: map[string]any{"id": 2}, Time: time.Now()}
+	assert.NoError(t, outbox.InTx(c, db.Chain(), first))
+	assert.NoError(t, outbox.InTx(c, db.Chain(), second))
+
+	// 提交后立即发布，失败的事件留在发件箱中
+	outbox.AfterCommit(c, first)
+	outbox.AfterCommit(c, second)
+	assert.Equal(t, []bool{false, true}, table.published())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		outbox.RunRelay(ctx, 5*time.Millisecond, 10)
+		close(done)
+	}()
+	assert.Eventually(t, func() bool {
+		return table.published()[0]
+	}, time.Second, 5*time.Millisecond)
+	cancel()
+	<-done
+	assert.Equal(t, []string{"orders", "users"}, publisher.resources())
+}
+
+// xaddRecorder 拦截 Redis 管道中的命令，不需要真实的 Redis
+type xaddRecorder struct {
+	args [][]any
+}
+
+func (h *xaddRecorder) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *xaddRecorder) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return next
+}
+
+func (h *xaddRecorder) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		for _, cmd := range cmds {
+			h.args = append(h.args, cmd.Args())
+		}
+		return nil
+	}
+}
+
+func TestRedisStreamPublisher(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:0"})
+	defer client.Close()
+	recorder := &xaddRecorder{}
+	client.AddHook(recorder)
+
+	publisher := NewRedisStreamPublisher(client, "", 100)
+	assert.Equal(t, "crud:events:users", publisher.StreamOf("users"))
+	er := publisher.Publish(context.Background(),
+		ChangeEvent{Id: "e1", Resource: "users", Operation: ChangeInsert},
+		ChangeEvent{Id: "e2", Resource: "orders", Operation: ChangeDelete})
+	assert.NoError(t, er)
+	assert.Len(t, recorder.args, 2)
+	assert.Equal(t, []any{"xadd", "crud:events:users", "maxlen", "~", int64(100), "*"}, recorder.args[0][:6])
+	assert.Equal(t, "crud:events:orders", recorder.args[1][1])
+	values := fmt.Sprint(recorder.args[1][6:])
+	assert.Contains(t, values, "e2")
+	assert.Contains(t, values, "delete")
+}
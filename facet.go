@@ -0,0 +1,152 @@
+package crud
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4"
+	"github.com/kmlixh/gom/v4/define"
+)
+
+// PathFacets 分面统计接口的名称
+const PathFacets DefaultRoutePath = "facets"
+
+// FacetOptions 分面统计接口的配置
+type FacetOptions struct {
+	Columns  []string // 允许分面统计的列
+	Limit    int      // 每个分面默认返回的值的数量，默认为 20
+	MaxLimit int      // 每个分面返回的值的数量上限，默认为 100
+}
+
+// WithFacets 开放 {prefix}/facets 分面统计接口，按列表接口的查询条件统计各列的不同值及数量，用于筛选下拉框
+//
+//	GET /orders/facets?fields=status,city&limit=10&createdAtGe=2024-01-01
+func WithFacets(facets FacetOptions) Option {
+	return func(o *Options) {
+		o.Facets = &facets
+	}
+}
+
+// FacetValue 分面的一个值及其数量
+type FacetValue struct {
+	Value any   `json:"value"`
+	Count int64 `json:"count"`
+}
+
+// parseFacetQuery 按白名单和调用者可读的列解析要统计的列和每个分面的数量，列可以使用 json 名称，返回列名
+// 加密和脱敏的列返回的是密文或原始值，不能分面统计
+func parseFacetQuery(c *gin.Context, opts *Options) ([]string, int, error) {
+	names := splitParam(c.Query("fields"))
+	if len(names) == 0 {
+		return nil, 0, errors.New("fields could not be empty")
+	}
+	readable, er := statColumns(c)
+	if er != nil {
+		return nil, 0, er
+	}
+	fields := make([]string, 0, len(names))
+	for _, name := range names {
+		col, ok := allowedColumn(opts, opts.Facets.Columns, readable, name)
+		if !ok {
+			return nil, 0, fmt.Errorf("column [%s] could not be faceted", name)
+		}
+		if protectedColumn(c, opts, col) {
+			return nil, 0, fmt.Errorf("column [%s] is encrypted or masked and could not be faceted", name)
+		}
+		fields = append(fields, col)
+	}
+	limit, max := opts.Facets.Limit, opts.Facets.MaxLimit
+	if limit <= 0 {
+		limit = 20
+	}
+	if max <= 0 {
+		max = 100
+	}
+	if value := c.Query("limit"); value != "" {
+		n, er := strconv.Atoi(value)
+		if er != nil || n <= 0 {
+			return nil, 0, fmt.Errorf("limit [%s] should be a positive integer", value)
+		}
+		limit = n
+	}
+	if limit > max {
+		limit = max
+	}
+	return fields, limit, nil
+}
+
+// buildFacetQuery 生成一个分面的 SQL，按数量倒序，数量相同时按值排序
+func buildFacetQuery(db *gom.DB, table string, field string, cond *define.Condition, limit int) (string, []any) {
+	conds := make([]*define.Condition, 0, 1)
+	if cond != nil {
+		conds = append(conds, cond)
+	}
+	col := quoteIdentifier(db, field)
+	fields := []string{field, "COUNT(*) AS " + quoteIdentifier(db, "count")}
+	sqlStr, args := db.Factory.BuildSelect(table, fields, conds, "", 0, 0)
+	return sqlStr + " GROUP BY " + col + " ORDER BY " + quoteIdentifier(db, "count") + " DESC, " + col + " LIMIT " + strconv.Itoa(limit), args
+}
+
+// DoFacets 分面统计，返回 列名 -> 不同值及数量
+func DoFacets() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db, ok := GetContextReadDatabase(c)
+		if !ok {
+			RenderErr2(c, 500, "can't find database")
+			return
+		}
+		opts, ok := GetContextOptions(c)
+		if !ok || opts.Facets == nil {
+			RenderErr2(c, 500, "facets is not configured")
+			return
+		}
+		table, ok := contextTableName(c)
+		if !ok {
+			RenderErr2(c, 500, "can't find data entity")
+			return
+		}
+		fields, limit, er := parseFacetQuery(c, opts)
+		if er != nil {
+			RenderErr2(c, 400, er.Error())
+			return
+		}
+		cond, _ := getScopedCondition(c)
+		facets := make(map[string][]FacetValue, len(fields))
+		er = readTransaction(c, db, func(tx *gom.Chain) error {
+			for _, field := range fields {
+				sqlStr, args := buildFacetQuery(db, table, field, cond, limit)
+				result := tx.RawQuery(sqlStr, args...)
+				if result.Error != nil {
+					return result.Error
+				}
+				values := make([]FacetValue, 0, len(result.Data))
+				for _, row := range result.Data {
+					count, _ := strconv.ParseInt(fmt.Sprint(numericValue(row["count"])), 10, 64)
+					values = append(values, FacetValue{Value: normalizeValue(row[field]), Count: count})
+				}
+				facets[field] = values
+			}
+			return nil
+		})
+		if er != nil {
+			renderDBError(c, 500, er)
+			return
+		}
+		RenderOk(c, facets)
+	}
+}
+
+// facetsHandler 按列表接口生成分面统计接口
+func facetsHandler(opts *Options, list RouteHandler) RouteHandler {
+	funcs := append(append([]gin.HandlerFunc{}, list.Handlers[:len(list.Handlers)-1]...), DoFacets())
+	parameters := append(append([]ApiProperty{}, list.Parameters...),
+		ApiProperty{Name: "fields", Type: "string", Required: true, Description: "统计的列，多个用逗号分隔，可选: " + strings.Join(opts.Facets.Columns, ","), Location: "query"},
+		ApiProperty{Name: "limit", Type: "integer", Description: "每个分面返回的值的数量", Location: "query"},
+	)
+	resp := NewCodeMsgResponse("分面统计结果", 200, "ok")
+	resp.Content["data"] = MediaType{Schema: &ApiProperty{Type: "object", Description: "列名 -> [{value, count}]，按数量倒序"}}
+	return withOperation(GetRouteHandler(string(PathFacets), "GET", opts.Resource+"分面统计", "按条件统计"+opts.Resource+"各列的不同值及数量", parameters, resp, funcs...), PathList)
+}
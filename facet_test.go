@@ -0,0 +1,73 @@
+package crud
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4"
+	"github.com/kmlixh/gom/v4/define"
+	"github.com/kmlixh/gom/v4/factory/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFacetQuery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	opts := &Options{}
+	WithFacets(FacetOptions{Columns: []string{"status", "city"}, MaxLimit: 50})(opts)
+	parse := func(query string) ([]string, int, error) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/orders/facets?"+query, nil)
+		SetContextOptions(opts)(c)
+		return parseFacetQuery(c, opts)
+	}
+
+	fields, limit, er := parse("fields=status,city")
+	assert.NoError(t, er)
+	assert.Equal(t, []string{"status", "city"}, fields)
+	assert.Equal(t, 20, limit)
+	_, limit, _ = parse("fields=status&limit=500")
+	assert.Equal(t, 50, limit)
+	_, _, er = parse("fields=email")
+	assert.EqualError(t, er, "column [email] could not be faceted")
+	_, _, er = parse("")
+	assert.EqualError(t, er, "fields could not be empty")
+
+	sqlStr, args := buildFacetQuery(&gom.DB{Factory: &mysql.Factory{}}, "orders", "status", define.Eq("city", "Paris"), 10)
+	assert.Equal(t, "SELECT `status`, COUNT(*) AS `count` FROM `orders` WHERE `city` = ? GROUP BY `status` ORDER BY `count` DESC, `status` LIMIT 10", sqlStr)
+	assert.Equal(t, []any{"Paris"}, args)
+}
+
+func TestFacetProtectedColumns(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	opts := NewOptions(&Order{},
+		WithFacets(FacetOptions{Columns: []string{"customerId", "no"}}),
+		WithEncryptedField("no", ""),
+		WithFieldPermissions(FieldPermission{Field: "customerId", Readable: []string{"admin"}}))
+	parse := func(query string, roles ...string) ([]string, int, error) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/orders/facets?"+query, nil)
+		SetContextOptions(opts)(c)
+		SetContextEntity(&Order{})(c)
+		SetContextRoles(roles...)(c)
+		return parseFacetQuery(c, opts)
+	}
+
+	// 白名单和请求都可以使用 json 名称，统计按列名进行
+	fields, _, er := parse("fields=customer_id", "admin")
+	assert.NoError(t, er)
+	assert.Equal(t, []string{"customer_id"}, fields)
+	_, _, er = parse("fields=customerId")
+	assert.EqualError(t, er, "column [customerId] could not be faceted")
+	_, _, er = parse("fields=no", "admin")
+	assert.EqualError(t, er, "column [no] is encrypted or masked and could not be faceted")
+}
+
+func TestFacetsHandler(t *testing.T) {
+	opts := &Options{Resource: "orders"}
+	WithFacets(FacetOptions{Columns: []string{"status"}})(opts)
+	list := RouteHandler{Path: string(PathList), Handlers: []gin.HandlerFunc{DoNothingFunc, DoNothingFunc, QueryList()}}
+	handlers := aggregateHandlers(opts, []RouteHandler{list})
+	assert.Len(t, handlers, 2)
+	assert.Equal(t, string(PathFacets), handlers[1].Path)
+}
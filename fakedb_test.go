@@ -0,0 +1,215 @@
+package crud
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kmlixh/gom/v4"
+	"github.com/kmlixh/gom/v4/factory/mysql"
+)
+
+// fakeHandler 处理 gom 生成的一条 SQL，查询返回列名和行，执行返回影响的行数
+// 列名可以写成 "name TYPE" 声明列的类型，否则按第一个非空值推断
+type fakeHandler func(query string, args []driver.Value) (cols []string, rows [][]driver.Value, affected int64, err error)
+
+var (
+	fakeOnce     sync.Once
+	fakeMu       sync.Mutex
+	fakeHandlers = make(map[string]fakeHandler)
+)
+
+// newFakeDB 创建使用 MySQL 语法、由 handler 响应 SQL 的测试数据库，用于不依赖真实数据库的测试
+func newFakeDB(t *testing.T, handler fakeHandler) *gom.DB {
+	fakeOnce.Do(func() {
+		sql.Register("crud_fake", fakeDriver{})
+	})
+	fakeMu.Lock()
+	dsn := t.Name() + "#" + strconv.Itoa(len(fakeHandlers))
+	fakeHandlers[dsn] = handler
+	fakeMu.Unlock()
+	sqlDB, er := sql.Open("crud_fake", dsn)
+	if er != nil {
+		t.Fatal(er)
+	}
+	t.Cleanup(func() {
+		_ = sqlDB.Close()
+	})
+	return &gom.DB{DB: sqlDB, Factory: &mysql.Factory{}}
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(dsn string) (driver.Conn, error) {
+	fakeMu.Lock()
+	defer fakeMu.Unlock()
+	return fakeConn{handler: fakeHandlers[dsn]}, nil
+}
+
+type fakeConn struct {
+	handler fakeHandler
+}
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeStmt{conn: c, query: query}, nil
+}
+
+func (c fakeConn) Close() error {
+	return nil
+}
+
+func (c fakeConn) Begin() (driver.Tx, error) {
+	return fakeTx{}, nil
+}
+
+// BeginTx 只读事务以 START TRANSACTION READ ONLY 交给 handler，便于校验查询所在的事务
+func (c fakeConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if opts.ReadOnly {
+		if _, _, _, er := c.handler("START TRANSACTION READ ONLY", nil); er != nil {
+			return nil, er
+		}
+	}
+	return fakeTx{}, nil
+}
+
+// QueryContext 语句在 ctx 结束时立即返回，和真实驱动一样中断执行中的查询
+func (c fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	var rows driver.Rows
+	er := fakeWithContext(ctx, func() (er error) {
+		rows, er = fakeStmt{conn: c, query: query}.Query(fakeValues(args))
+		return er
+	})
+	return rows, er
+}
+
+// ExecContext 同 QueryContext
+func (c fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	var result driver.Result
+	er := fakeWithContext(ctx, func() (er error) {
+		result, er = fakeStmt{conn: c, query: query}.Exec(fakeValues(args))
+		return er
+	})
+	return result, er
+}
+
+// fakeWithContext 执行 fn，ctx 先结束时返回 ctx 的错误
+func fakeWithContext(ctx context.Context, fn func() error) error {
+	if er := ctx.Err(); er != nil {
+		return er
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+	select {
+	case er := <-done:
+		return er
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func fakeValues(args []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, 0, len(args))
+	for _, arg := range args {
+		values = append(values, arg.Value)
+	}
+	return values
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error {
+	return nil
+}
+
+func (fakeTx) Rollback() error {
+	return nil
+}
+
+type fakeStmt struct {
+	conn  fakeConn
+	query string
+}
+
+func (s fakeStmt) Close() error {
+	return nil
+}
+
+func (s fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	_, _, affected, er := s.conn.handler(s.query, args)
+	if er != nil {
+		return nil, er
+	}
+	return driver.RowsAffected(affected), nil
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	cols, rows, _, er := s.conn.handler(s.query, args)
+	if er != nil {
+		return nil, er
+	}
+	return &fakeRows{cols: cols, rows: rows}, nil
+}
+
+type fakeRows struct {
+	cols []string
+	rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	names := make([]string, 0, len(r.cols))
+	for _, col := range r.cols {
+		names = append(names, strings.Fields(col)[0])
+	}
+	return names
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+// ColumnTypeDatabaseTypeName 列声明的类型或按第一个非空值推断的类型，gom 据此选择扫描的目标类型
+func (r *fakeRows) ColumnTypeDatabaseTypeName(index int) string {
+	if fields := strings.Fields(r.cols[index]); len(fields) > 1 {
+		return fields[1]
+	}
+	for _, row := range r.rows {
+		switch row[index].(type) {
+		case int64:
+			return "BIGINT"
+		case float64:
+			return "DOUBLE"
+		case time.Time:
+			return "DATETIME"
+		case []byte:
+			return "BLOB"
+		case string:
+			return "TEXT"
+		}
+	}
+	return "TEXT"
+}
+
+func (r *fakeRows) ColumnTypeNullable(int) (bool, bool) {
+	return true, true
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
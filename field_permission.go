@@ -0,0 +1,196 @@
+package crud
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4/define"
+)
+
+// FieldPermission 字段级读写权限
+// Readable/Writable 为允许读取/写入该字段的用户类型或角色，为空表示不做限制
+type FieldPermission struct {
+	Field    string   // 列名或json名称
+	Readable []string // 可读的用户类型或角色
+	Writable []string // 可写的用户类型或角色
+}
+
+// WithFieldPermissions 设置字段级读写权限
+func WithFieldPermissions(perms ...FieldPermission) Option {
+	return func(o *Options) {
+		o.FieldPermissions = append(o.FieldPermissions, perms...)
+	}
+}
+
+// 条件参数名称中可能出现的操作符后缀，越长的越靠前
+var conditionSuffixes = []string{"LikeRight", "LikeLeft", "NotLike", "NotEq", "NotIn", "Like", "Eq", "Ne", "Gt", "Ge", "Lt", "Le", "In"}
+
+// permissionOf 查找字段的权限配置，name 可以是列名、json名称或条件参数名称
+func (o *Options) permissionOf(name string) (FieldPermission, bool) {
+	if o == nil || len(o.FieldPermissions) == 0 {
+		return FieldPermission{}, false
+	}
+	candidates := []string{name}
+	for _, suffix := range conditionSuffixes {
+		if strings.HasSuffix(name, suffix) && len(name) > len(suffix) {
+			candidates = append(candidates, strings.TrimSuffix(name, suffix))
+		}
+	}
+	for _, candidate := range candidates {
+		col := o.columnName(candidate)
+		for _, perm := range o.FieldPermissions {
+			if perm.Field == col || o.columnName(perm.Field) == col {
+				return perm, true
+			}
+		}
+	}
+	return FieldPermission{}, false
+}
+
+// CanRead 判断拥有 identities 身份的调用者能否读取字段
+func (o *Options) CanRead(field string, identities ...string) bool {
+	perm, ok := o.permissionOf(field)
+	if !ok {
+		return true
+	}
+	return matchIdentity(perm.Readable, identities)
+}
+
+// CanWrite 判断拥有 identities 身份的调用者能否写入字段
+func (o *Options) CanWrite(field string, identities ...string) bool {
+	perm, ok := o.permissionOf(field)
+	if !ok {
+		return true
+	}
+	return matchIdentity(perm.Writable, identities)
+}
+
+func matchIdentity(allowed []string, identities []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, a := range allowed {
+		for _, identity := range identities {
+			if identity != "" && a == identity {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// callerIdentities 获取当前调用者的用户类型和角色
+func callerIdentities(c *gin.Context) []string {
+	return append([]string{GetContextUserType(c)}, GetContextRoles(c)...)
+}
+
+// entityColumns 获取实体的所有列名
+func entityColumns(i any) []string {
+	transfer := define.GetTransfer(i)
+	if transfer == nil {
+		return nil
+	}
+	return transfer.GetFieldNames()
+}
+
+// filterColumns 按字段权限过滤列，cols 为空时表示实体的所有列
+func filterColumns(c *gin.Context, i any, cols []string, allow func(o *Options, field string, identities ...string) bool) ([]string, error) {
+	opts, ok := GetContextOptions(c)
+	if !ok || len(opts.FieldPermissions) == 0 {
+		return cols, nil
+	}
+	if len(cols) == 0 {
+		cols = entityColumns(i)
+	}
+	identities := callerIdentities(c)
+	result := make([]string, 0, len(cols))
+	for _, col := range cols {
+		if allow(opts, col, identities...) {
+			result = append(result, col)
+		}
+	}
+	if len(result) == 0 {
+		return nil, errors.New("no permitted columns")
+	}
+	return result, nil
+}
+
+// readableColumns 按字段权限过滤出调用者可读的列
+func readableColumns(c *gin.Context, i any, cols []string) ([]string, error) {
+	return filterColumns(c, i, cols, (*Options).CanRead)
+}
+
+// writableColumns 按字段权限过滤出调用者可写的列
+func writableColumns(c *gin.Context, i any, cols []string) ([]string, error) {
+	return filterColumns(c, i, cols, (*Options).CanWrite)
+}
+
+// readableConditionParams 去掉调用者不可读字段上的查询条件，避免通过条件推测字段的值
+func readableConditionParams(c *gin.Context, params []ConditionParam) []ConditionParam {
+	opts, ok := GetContextOptions(c)
+	if !ok || len(opts.FieldPermissions) == 0 {
+		return params
+	}
+	identities := callerIdentities(c)
+	result := make([]ConditionParam, 0, len(params))
+	for _, param := range params {
+		name := param.ColName
+		if name == "" {
+			name = param.QueryName
+		}
+		if opts.CanRead(name, identities...) {
+			result = append(result, param)
+		}
+	}
+	return result
+}
+
+// filterAPIDocs 按调用者身份去掉文档中不可见的字段
+func filterAPIDocs(c *gin.Context, docs []APIDoc) []APIDoc {
+	identities := callerIdentities(c)
+	result := make([]APIDoc, 0, len(docs))
+	for _, doc := range docs {
+		if doc.options == nil || len(doc.options.FieldPermissions) == 0 {
+			result = append(result, doc)
+			continue
+		}
+		params := make([]ApiProperty, 0, len(doc.Parameters))
+		for _, param := range doc.Parameters {
+			if param.Location == "body" && !doc.options.CanWrite(param.Name, identities...) {
+				continue
+			}
+			if param.Location != "body" && !doc.options.CanRead(param.Name, identities...) {
+				continue
+			}
+			params = append(params, param)
+		}
+		doc.Parameters = params
+		content := make(map[string]MediaType, len(doc.Response.Content))
+		for k, media := range doc.Response.Content {
+			if media.Schema != nil {
+				schema := filterReadableProperty(doc.options, *media.Schema, identities)
+				media.Schema = &schema
+			}
+			content[k] = media
+		}
+		doc.Response.Content = content
+		result = append(result, doc)
+	}
+	return result
+}
+
+func filterReadableProperty(opts *Options, property ApiProperty, identities []string) ApiProperty {
+	if len(property.Fields) == 0 {
+		return property
+	}
+	fields := make([]ApiProperty, 0, len(property.Fields))
+	for _, field := range property.Fields {
+		if !opts.CanRead(field.Name, identities...) {
+			continue
+		}
+		fields = append(fields, filterReadableProperty(opts, field, identities))
+	}
+	property.Fields = fields
+	return property
+}
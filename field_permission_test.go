@@ -0,0 +1,59 @@
+package crud
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type TestEmployee struct {
+	ID     int64  `json:"id" gom:"id,@"`
+	Name   string `json:"name" gom:"name"`
+	Salary int64  `json:"monthlySalary" gom:"salary"`
+}
+
+func TestFieldPermission(t *testing.T) {
+	opts := NewOptions(&TestEmployee{}, WithFieldPermissions(FieldPermission{
+		Field:    "salary",
+		Readable: []string{"hr", "admin"},
+		Writable: []string{"admin"},
+	}))
+
+	assert.True(t, opts.CanRead("name", "guest"))
+	assert.True(t, opts.CanRead("salary", "hr"))
+	assert.False(t, opts.CanRead("salary", "guest"))
+	assert.False(t, opts.CanRead("monthlySalary", "guest"))
+	assert.False(t, opts.CanRead("salaryGt", "guest"))
+	assert.False(t, opts.CanWrite("salary", "hr"))
+	assert.True(t, opts.CanWrite("salary", "guest", "admin"))
+}
+
+func TestFilterColumnsAndDocs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set("userType", "staff")
+	opts := NewOptions(&TestEmployee{}, WithFieldPermissions(FieldPermission{Field: "salary", Readable: []string{"hr"}}))
+	SetContextOptions(opts)(c)
+
+	cols, err := readableColumns(c, &TestEmployee{}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"id", "name"}, cols)
+
+	params := readableConditionParams(c, []ConditionParam{{QueryName: "nameEq", ColName: "name"}, {QueryName: "salaryGt", ColName: "salary"}})
+	assert.Len(t, params, 1)
+
+	docs := filterAPIDocs(c, []APIDoc{{
+		Parameters: []ApiProperty{{Name: "salaryGt", Location: "query"}, {Name: "nameEq", Location: "query"}},
+		Response:   generateDetailResponse("TestEmployee", GenerateApiPropertiesFromStruct(TestEmployee{})),
+		options:    opts,
+	}})
+	assert.Len(t, docs[0].Parameters, 1)
+	assert.Len(t, docs[0].Response.Content["data"].Schema.Fields, 2)
+
+	SetContextRoles("hr")(c)
+	cols, err = readableColumns(c, &TestEmployee{}, []string{"name", "salary"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"name", "salary"}, cols)
+}
@@ -0,0 +1,181 @@
+package crud
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MaskFunc 脱敏函数，输入原始值返回脱敏后的值
+type MaskFunc func(string) string
+
+// 内置的脱敏策略
+const (
+	MaskPhone    = "phone"    // 手机号：138****1234
+	MaskIDCard   = "idcard"   // 身份证号：110101********1234
+	MaskEmail    = "email"    // 邮箱：t***@example.com
+	MaskBankCard = "bankcard" // 银行卡号：6222********1234
+	MaskName     = "name"     // 姓名：张**
+	MaskAddress  = "address"  // 地址：只保留前6个字符
+	MaskAll      = "all"      // 全部替换为*
+)
+
+var (
+	maskLock       sync.RWMutex
+	maskStrategies = map[string]MaskFunc{
+		MaskPhone:    func(s string) string { return maskMiddle(s, 3, 4) },
+		MaskIDCard:   func(s string) string { return maskMiddle(s, 6, 4) },
+		MaskBankCard: func(s string) string { return maskMiddle(s, 4, 4) },
+		MaskName:     func(s string) string { return maskMiddle(s, 1, 0) },
+		MaskAddress:  func(s string) string { return maskMiddle(s, 6, 0) },
+		MaskAll:      func(s string) string { return maskMiddle(s, 0, 0) },
+		MaskEmail:    maskEmail,
+	}
+)
+
+// RegisterMaskStrategy 注册自定义脱敏策略，同名策略会被覆盖
+func RegisterMaskStrategy(name string, fn MaskFunc) {
+	maskLock.Lock()
+	defer maskLock.Unlock()
+	maskStrategies[name] = fn
+}
+
+func getMaskStrategy(name string) (MaskFunc, bool) {
+	maskLock.RLock()
+	defer maskLock.RUnlock()
+	fn, ok := maskStrategies[name]
+	return fn, ok
+}
+
+// maskMiddle 保留左右两端的字符，中间替换为*
+func maskMiddle(s string, left, right int) string {
+	runes := []rune(s)
+	if len(runes) == 0 {
+		return s
+	}
+	if left+right >= len(runes) {
+		// 字符太短时至少遮住一半
+		left = len(runes) / 4
+		right = len(runes) / 4
+	}
+	for idx := left; idx < len(runes)-right; idx++ {
+		runes[idx] = '*'
+	}
+	return string(runes)
+}
+
+func maskEmail(s string) string {
+	at := strings.LastIndex(s, "@")
+	if at <= 0 {
+		return maskMiddle(s, 1, 0)
+	}
+	return maskMiddle(s[:at], 1, 0) + s[at:]
+}
+
+// WithMask 为字段设置脱敏策略，field 可以是列名或json名称
+func WithMask(field string, strategy string) Option {
+	return func(o *Options) {
+		if o.Masks == nil {
+			o.Masks = make(map[string]string)
+		}
+		o.Masks[o.columnName(field)] = strategy
+	}
+}
+
+// WithUnmask 设置非脱敏模式的权限校验，通过校验的请求可以用 unmask=true 获取原始数据
+func WithUnmask(check func(c *gin.Context) bool) Option {
+	return func(o *Options) {
+		o.UnmaskCheck = check
+	}
+}
+
+// UnmaskForRoles 生成按用户类型或角色授予非脱敏模式的权限校验
+func UnmaskForRoles(roles ...string) func(c *gin.Context) bool {
+	return func(c *gin.Context) bool {
+		return matchIdentity(roles, callerIdentities(c))
+	}
+}
+
+// maskTagsOf 读取结构体字段上的 mask 标签，返回 列名 -> 脱敏策略
+func maskTagsOf(i any) map[string]string {
+	masks := make(map[string]string)
+	if i == nil {
+		return masks
+	}
+	t := reflect.TypeOf(i)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return masks
+	}
+	for idx := 0; idx < t.NumField(); idx++ {
+		field := t.Field(idx)
+		strategy := field.Tag.Get("mask")
+		col := strings.Split(field.Tag.Get("gom"), ",")[0]
+		if strategy != "" && col != "" && col != "-" {
+			masks[col] = strategy
+		}
+	}
+	return masks
+}
+
+// errUnmaskDenied 请求非脱敏模式但没有权限
+var errUnmaskDenied = errors.New("unmask not permitted")
+
+// shouldMask 判断当前请求是否需要脱敏
+func shouldMask(c *gin.Context, opts *Options) (bool, error) {
+	if opts == nil || len(opts.Masks) == 0 {
+		return false, nil
+	}
+	if c.Query("unmask") != "true" {
+		return true, nil
+	}
+	if opts.UnmaskCheck != nil && opts.UnmaskCheck(c) {
+		return false, nil
+	}
+	return true, errUnmaskDenied
+}
+
+// MaskData 按资源的脱敏配置对结果进行脱敏，支持结构体、map及其切片和指针
+// 列表、详情以及导出等输出数据的处理器在渲染前调用
+func MaskData(c *gin.Context, data any) error {
+	opts, _ := GetContextOptions(c)
+	mask, er := shouldMask(c, opts)
+	if er != nil || !mask {
+		return er
+	}
+	return walkStringFields(reflect.ValueOf(data), func(col string, s string) (string, bool, error) {
+		fn, ok := opts.maskOf(col)
+		if !ok {
+			return s, false, nil
+		}
+		return fn(s), true, nil
+	})
+}
+
+func (o *Options) maskOf(name string) (MaskFunc, bool) {
+	if name == "" {
+		return nil, false
+	}
+	strategy, ok := o.Masks[o.columnName(name)]
+	if !ok {
+		return nil, false
+	}
+	return getMaskStrategy(strategy)
+}
+
+// markMaskedProperties 在文档中标记脱敏字段
+func markMaskedProperties(opts *Options, properties []ApiProperty) []ApiProperty {
+	result := make([]ApiProperty, len(properties))
+	for idx, property := range properties {
+		if strategy, ok := opts.Masks[opts.columnName(property.Name)]; ok {
+			property.Mask = strategy
+		}
+		result[idx] = property
+	}
+	return result
+}
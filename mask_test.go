@@ -0,0 +1,61 @@
+package crud
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type TestContact struct {
+	ID     int64  `json:"id" gom:"id,@"`
+	Name   string `json:"name" gom:"name" mask:"name"`
+	Phone  string `json:"phone" gom:"phone" mask:"phone"`
+	Email  string `json:"email" gom:"email"`
+	IDCard string `json:"idCard" gom:"id_card"`
+}
+
+func TestMaskStrategies(t *testing.T) {
+	phone, _ := getMaskStrategy(MaskPhone)
+	email, _ := getMaskStrategy(MaskEmail)
+	idCard, _ := getMaskStrategy(MaskIDCard)
+	name, _ := getMaskStrategy(MaskName)
+	assert.Equal(t, "138****1234", phone("13812341234"))
+	assert.Equal(t, "t***@example.com", email("test@example.com"))
+	assert.Equal(t, "110101********1234", idCard("110101199001011234"))
+	assert.Equal(t, "张**", name("张三丰"))
+	assert.Equal(t, "", phone(""))
+}
+
+func TestMaskData(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	opts := NewOptions(&TestContact{}, WithMask("email", MaskEmail), WithMask("idCard", MaskIDCard), WithUnmask(UnmaskForRoles("admin")))
+	rows := []TestContact{{ID: 1, Name: "张三", Phone: "13812341234", Email: "test@example.com", IDCard: "110101199001011234"}}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/list", nil)
+	SetContextOptions(opts)(c)
+	assert.NoError(t, MaskData(c, rows))
+	assert.Equal(t, TestContact{ID: 1, Name: "张*", Phone: "138****1234", Email: "t***@example.com", IDCard: "110101********1234"}, rows[0])
+
+	row := map[string]any{"phone": "13812341234", "id_card": "110101199001011234"}
+	assert.NoError(t, MaskData(c, row))
+	assert.Equal(t, "138****1234", row["phone"])
+	assert.Equal(t, "110101********1234", row["id_card"])
+
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/list?unmask=true", nil)
+	SetContextOptions(opts)(c)
+	detail := &TestContact{Phone: "13812341234"}
+	assert.ErrorIs(t, MaskData(c, detail), errUnmaskDenied)
+
+	c.Set("userType", "admin")
+	assert.NoError(t, MaskData(c, detail))
+	assert.Equal(t, "13812341234", detail.Phone)
+
+	props := markMaskedProperties(opts, GenerateApiPropertiesFromStruct(TestContact{}))
+	assert.Equal(t, MaskName, props[1].Mask)
+	assert.Equal(t, MaskEmail, props[3].Mask)
+	assert.Empty(t, props[0].Mask)
+}
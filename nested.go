@@ -0,0 +1,184 @@
+package crud
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4"
+	"github.com/kmlixh/gom/v4/define"
+)
+
+// Parent 子资源的父资源，配置后路由为 {父资源}/:{Param}/{子资源}/...
+// 路由中的父资源主键会自动加入查询、更新、删除的条件并写入新增和更新的数据
+type Parent struct {
+	Resource   string // 父资源的路由名称，如 users
+	Param      string // 路由中父资源主键的参数名，如 userId
+	Table      string // 父资源的表名，用于校验父资源是否存在
+	Key        string // 父资源的主键列，默认为 id
+	ForeignKey string // 本资源中指向父资源的列，如 user_id
+}
+
+// WithParent 将资源注册为 parent 的子资源，Resource、Param、Table、ForeignKey 必须设置
+func WithParent(parent Parent) Option {
+	return func(o *Options) {
+		if parent.Key == "" {
+			parent.Key = "id"
+		}
+		required := [][2]string{{"Resource", parent.Resource}, {"Param", parent.Param}, {"Table", parent.Table}, {"ForeignKey", parent.ForeignKey}}
+		for _, field := range required {
+			if field[1] == "" {
+				o.addError(fmt.Errorf("parent %s could not be empty", field[0]))
+			}
+		}
+		o.Parent = &parent
+	}
+}
+
+// routeName 子资源的路由名称
+func (p *Parent) routeName(name string) string {
+	return p.Resource + "/:" + p.Param + "/" + name
+}
+
+// BindParent 校验路由中的父资源是否存在，并记录父资源主键用于后续的条件和写入
+func BindParent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		opts, ok := GetContextOptions(c)
+		if !ok || opts.Parent == nil {
+			return
+		}
+		parent := opts.Parent
+		value := c.Param(parent.Param)
+		if value == "" {
+			c.Abort()
+			RenderErr2(c, 400, parent.Param+" could not be empty")
+			return
+		}
+		db, ok := GetContextDatabase(c)
+		if !ok {
+			c.Abort()
+			RenderErr2(c, 500, "can't find database")
+			return
+		}
+		var rows []map[string]any
+		er := readTransaction(c, db, func(tx *gom.Chain) (er error) {
+			rows, er = queryRows(db, tx, parent.Table, define.Eq(parent.Key, value), 1)
+			return er
+		})
+		if er != nil {
+			c.Abort()
+			renderDBError(c, 500, er)
+			return
+		}
+		if len(rows) == 0 {
+			c.Abort()
+			RenderErr2(c, 404, "parent not found")
+			return
+		}
+		SetContextAny("parentKey", value)(c)
+	}
+}
+
+// parentCondition 父资源主键对应的条件，不是子资源时返回nil
+func parentCondition(c *gin.Context) *define.Condition {
+	opts, ok := GetContextOptions(c)
+	if !ok || opts.Parent == nil {
+		return nil
+	}
+	value, ok := GetContextAny(c, "parentKey")
+	if !ok {
+		return nil
+	}
+	return define.Eq(opts.Parent.ForeignKey, value)
+}
+
+// scopeCondition 将条件限定在路由中的父资源下
+func scopeCondition(c *gin.Context, cnd *define.Condition) *define.Condition {
+	parent := parentCondition(c)
+	if parent == nil {
+		return cnd
+	}
+	if cnd == nil {
+		return parent
+	}
+	return &define.Condition{IsSubGroup: true, SubConds: []*define.Condition{parent, cnd}}
+}
+
+// getScopedCondition 获取请求的查询条件，子资源会加上父资源的条件
+func getScopedCondition(c *gin.Context) (*define.Condition, bool) {
+	cnd, _ := getContextCondition(c)
+	cnd = scopeCondition(c, cnd)
+	return cnd, cnd != nil
+}
+
+// belongsToParent 判断主键为 id 的数据是否属于路由中的父资源，不是子资源时总是属于
+// 按当前数据判断，已删除的数据不再属于任何父资源
+func belongsToParent(c *gin.Context, i any, id string) (bool, error) {
+	if parentCondition(c) == nil {
+		return true, nil
+	}
+	db, ok := GetContextDatabase(c)
+	if !ok {
+		return false, errors.New("can't find database")
+	}
+	var rows []map[string]any
+	er := readTransaction(c, db, func(tx *gom.Chain) (er error) {
+		rows, er = queryRows(db, tx, getTableName(i), scopeCondition(c, define.Eq(primaryKeyOf(i), id)), 1)
+		return er
+	})
+	if er != nil {
+		return false, er
+	}
+	return len(rows) > 0, nil
+}
+
+// requireParent 主键为 id 的数据不属于路由中的父资源时输出 404，返回是否可以继续处理
+func requireParent(c *gin.Context, id string) bool {
+	if parentCondition(c) == nil {
+		return true
+	}
+	i, ok := GetContextEntity(c)
+	if !ok {
+		RenderErr2(c, 500, "can't find data entity")
+		return false
+	}
+	owned, er := belongsToParent(c, i, id)
+	if er != nil {
+		RenderErr2(c, 500, er.Error())
+		return false
+	}
+	if !owned {
+		RenderErr2(c, 404, "record not found")
+		return false
+	}
+	return true
+}
+
+// bindParentField 将父资源主键写入要保存的数据
+func bindParentField(c *gin.Context, fields map[string]any) error {
+	opts, ok := GetContextOptions(c)
+	if !ok || opts.Parent == nil {
+		return nil
+	}
+	value, ok := GetContextAny(c, "parentKey")
+	if !ok {
+		return errors.New("parent key not bound")
+	}
+	fields[opts.Parent.ForeignKey] = value
+	return nil
+}
+
+// nestHandlers 在每个接口的处理链中加入父资源的校验
+func nestHandlers(handlers []RouteHandler) {
+	for idx := range handlers {
+		// 位于 SetContextDatabase、SetContextOptions 之后，不影响 BeforeCommit、AfterCommit 位置的处理器
+		handlers[idx].Handlers = insertAfterContext(handlers[idx].Handlers, BindParent())
+		handlers[idx].Parameters = append([]ApiProperty{{
+			Name:        handlers[idx].Options.Parent.Param,
+			Type:        "string",
+			Required:    true,
+			Description: "父资源" + handlers[idx].Options.Parent.Resource + "的主键",
+			Location:    "path",
+		}}, handlers[idx].Parameters...)
+	}
+}
@@ -0,0 +1,100 @@
+package crud
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4/define"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNestedRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	idParam := []ConditionParam{{QueryName: "idEq", ColName: "id", Operation: define.OpEq}}
+	orders, er := NewCrud2("orders", &Order{}, nil, []string{"id", "no"}, nil, nil, idParam, []string{"no"}, []string{"no"}, nil, idParam, nil,
+		WithParent(Parent{Resource: "users", Param: "userId", Table: "users", ForeignKey: "customer_id"}))
+	assert.NoError(t, er)
+
+	r := gin.New()
+	assert.NoError(t, orders.Register(r.Group("/api")))
+	paths := make(map[string]bool)
+	for _, route := range r.Routes() {
+		paths[route.Method+" "+route.Path] = true
+	}
+	assert.True(t, paths["GET /api/users/:userId/orders/list"])
+	assert.True(t, paths["POST /api/users/:userId/orders/add"])
+
+	handler, er := orders.GetHandler(string(PathDetail))
+	assert.NoError(t, er)
+	assert.Equal(t, "userId", handler.Parameters[0].Name)
+	assert.Equal(t, "path", handler.Parameters[0].Location)
+}
+
+func TestScopeCondition(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/users/5/orders/list", nil)
+	opts := NewOptions(&Order{}, WithParent(Parent{Resource: "users", Param: "userId", Table: "users", ForeignKey: "customer_id"}))
+	SetContextOptions(opts)(c)
+
+	// 父资源没有绑定时不限定条件
+	assert.Nil(t, scopeCondition(c, nil))
+
+	SetContextAny("parentKey", "5")(c)
+	cnd := scopeCondition(c, define.Eq("no", "A").Or(define.Eq("no", "B")))
+	assert.True(t, MatchCondition(cnd, map[string]any{"customer_id": int64(5), "no": "B"}))
+	assert.False(t, MatchCondition(cnd, map[string]any{"customer_id": int64(6), "no": "B"}))
+	assert.True(t, MatchCondition(scopeCondition(c, nil), map[string]any{"customer_id": int64(5)}))
+
+	fields := map[string]any{"no": "C", "customer_id": 9}
+	assert.NoError(t, bindParentField(c, fields))
+	assert.Equal(t, "5", fields["customer_id"])
+}
+
+func TestParentValidation(t *testing.T) {
+	_, er := NewCrud2("orders", &Order{}, nil, []string{"id", "no"}, nil, nil, nil, nil, nil, nil, nil, nil,
+		WithParent(Parent{Resource: "users", Param: "userId"}))
+	assert.EqualError(t, er, "parent Table could not be empty\nparent ForeignKey could not be empty")
+}
+
+func TestNestedHistoryScopedToParent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	// 订单 10 属于用户 1，订单 20 属于用户 2
+	owners := map[string]string{"10": "1", "20": "2"}
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, int64, error) {
+		switch {
+		case strings.Contains(query, "FROM `users`"):
+			return []string{"id"}, [][]driver.Value{{args[0]}}, 0, nil
+		case strings.Contains(query, "FROM `orders`"):
+			if owners[fmt.Sprint(args[1])] == fmt.Sprint(args[0]) {
+				return []string{"id", "customer_id"}, [][]driver.Value{{args[1], args[0]}}, 0, nil
+			}
+			return []string{"id", "customer_id"}, nil, 0, nil
+		}
+		return nil, nil, 0, fmt.Errorf("unexpected query: %s", query)
+	})
+	sink := &memoryAuditSink{logs: []AuditLog{
+		{Resource: "orders", RecordId: "10", Operation: "update", Diff: `[{"field":"no","old":"A","new":"B"}]`},
+		{Resource: "orders", RecordId: "20", Operation: "update", Diff: `[{"field":"no","old":"C","new":"D"}]`},
+	}}
+	idParam := []ConditionParam{{QueryName: "idEq", ColName: "id", Operation: define.OpEq}}
+	orders, er := NewCrud2("orders", &Order{}, db, []string{"id", "no"}, nil, nil, idParam, []string{"no"}, []string{"no"}, nil, idParam, nil,
+		WithParent(Parent{Resource: "users", Param: "userId", Table: "users", ForeignKey: "customer_id"}), WithAudit(sink))
+	assert.NoError(t, er)
+	r := gin.New()
+	assert.NoError(t, orders.Register(r.Group("/api")))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/api/users/1/orders/history?id=10", nil))
+	assert.Contains(t, w.Body.String(), `"code":200`)
+	assert.Contains(t, w.Body.String(), `\"new\":\"B\"`)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/api/users/1/orders/history?id=20", nil))
+	assert.Contains(t, w.Body.String(), `"code":404`)
+	assert.NotContains(t, w.Body.String(), "new")
+}
@@ -0,0 +1,97 @@
+package crud
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// builtinOperations NewCrud2 默认生成的增删改查和表结构接口
+var builtinOperations = []DefaultRoutePath{PathList, PathDetail, PathAdd, PathUpdate, PathDelete, PathTableStruct}
+
+// ReadOnlyOperations 只读资源开放的接口
+var ReadOnlyOperations = []DefaultRoutePath{PathList, PathDetail}
+
+// WithOperations 只开放指定的内置接口，未列出的 list、detail、add、update、delete、struct 不会注册，也不会出现在 API 文档中
+// 其他接口按其 Operation 对应的内置接口决定是否开放：history、revisions 随 detail，aggregate、timeseries、facets、watch 随 list，
+// rollback、patch、多对多关联的修改和写操作的自定义操作随 update
+func WithOperations(operations ...DefaultRoutePath) Option {
+	return func(o *Options) {
+		o.Operations = append([]DefaultRoutePath{}, operations...)
+	}
+}
+
+// WithReadOnly 只开放 list 和 detail 以及随它们开放的只读接口，所有写数据的接口都不会注册
+func WithReadOnly() Option {
+	return WithOperations(ReadOnlyOperations...)
+}
+
+// WithOperationMiddleware 为指定接口添加中间件，如鉴权、限流、角色校验
+// operation 为接口的路径名称，内置接口使用 PathList 等常量，自定义操作使用其 Path
+// 内置接口的中间件同样作用于对应到它的接口，如 list 的中间件作用于 watch、aggregate，update 的中间件作用于 rollback、patch
+func WithOperationMiddleware(operation DefaultRoutePath, middlewares ...gin.HandlerFunc) Option {
+	return func(o *Options) {
+		if o.Middlewares == nil {
+			o.Middlewares = make(map[DefaultRoutePath][]gin.HandlerFunc)
+		}
+		o.Middlewares[operation] = append(o.Middlewares[operation], middlewares...)
+	}
+}
+
+// withOperation 设置接口对应的内置接口
+func withOperation(handler RouteHandler, operation DefaultRoutePath) RouteHandler {
+	handler.Operation = operation
+	return handler
+}
+
+// operationOf 接口对应的内置接口，未设置时为接口的路径
+func operationOf(handler RouteHandler) DefaultRoutePath {
+	if handler.Operation != "" {
+		return handler.Operation
+	}
+	return DefaultRoutePath(handler.Path)
+}
+
+// operationEnabled 接口对应的内置接口是否开放，对应不到内置接口的总是开放
+func (o *Options) operationEnabled(operation DefaultRoutePath) bool {
+	if o.Operations == nil {
+		return true
+	}
+	builtin := false
+	for _, op := range builtinOperations {
+		builtin = builtin || op == operation
+	}
+	if !builtin {
+		return true
+	}
+	for _, op := range o.Operations {
+		if op == operation {
+			return true
+		}
+	}
+	return false
+}
+
+// enabledHandlers 过滤掉对应的内置接口未开放的接口
+func enabledHandlers(opts *Options, handlers []RouteHandler) []RouteHandler {
+	result := make([]RouteHandler, 0, len(handlers))
+	for _, handler := range handlers {
+		if opts.operationEnabled(operationOf(handler)) {
+			result = append(result, handler)
+		}
+	}
+	return result
+}
+
+// applyMiddlewares 将接口对应的内置接口的中间件和接口自身的中间件加入处理链
+func applyMiddlewares(opts *Options, handlers []RouteHandler) {
+	for idx := range handlers {
+		middlewares := opts.Middlewares[DefaultRoutePath(handlers[idx].Path)]
+		if operation := operationOf(handlers[idx]); operation != DefaultRoutePath(handlers[idx].Path) {
+			middlewares = append(append([]gin.HandlerFunc{}, opts.Middlewares[operation]...), middlewares...)
+		}
+		if len(middlewares) == 0 {
+			continue
+		}
+		// 位于 SetContextDatabase、SetContextOptions 之后、父资源校验之前，中间件可以读取资源配置并尽早终止请求
+		handlers[idx].Handlers = insertAfterContext(handlers[idx].Handlers, middlewares...)
+	}
+}
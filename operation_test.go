@@ -0,0 +1,99 @@
+package crud
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4/define"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadOnlyOperations(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	idParam := []ConditionParam{{QueryName: "idEq", ColName: "id", Operation: define.OpEq}}
+	orders, er := NewCrud2("readonly_orders", &Order{}, nil, []string{"id", "no"}, nil, nil, idParam, []string{"no"}, []string{"no"}, nil, idParam, nil,
+		WithReadOnly())
+	assert.NoError(t, er)
+
+	r := gin.New()
+	assert.NoError(t, orders.Register(r.Group("/api")))
+	paths := make(map[string]bool)
+	for _, route := range r.Routes() {
+		paths[route.Method+" "+route.Path] = true
+	}
+	assert.Equal(t, map[string]bool{"GET /api/readonly_orders/list": true, "GET /api/readonly_orders/detail": true}, paths)
+	assert.Len(t, globalAPIRegistry.GetAPIsByGroup("readonly_orders"), 2)
+}
+
+func TestOperationMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	denied := func(c *gin.Context) {
+		RenderErr2(c, 403, "forbidden")
+	}
+	idParam := []ConditionParam{{QueryName: "idEq", ColName: "id", Operation: define.OpEq}}
+	orders, er := NewCrud2("guarded_orders", &Order{}, nil, []string{"id", "no"}, nil, nil, idParam, []string{"no"}, []string{"no"}, nil, idParam, nil,
+		WithRouteStyle(RouteStyleREST), WithOperationMiddleware(PathUpdate, denied))
+	assert.NoError(t, er)
+
+	r := gin.New()
+	assert.NoError(t, orders.Register(r.Group("/api")))
+	for _, method := range []string{"PUT", "PATCH"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, "/api/guarded_orders/1", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"code":403,"msg":"forbidden","data":null}`, w.Body.String())
+	}
+}
+
+func TestReadOnlyDropsDerivedWrites(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	idParam := []ConditionParam{{QueryName: "idEq", ColName: "id", Operation: define.OpEq}}
+	customers, er := NewCrud2("readonly_customers", &Customer{}, nil, []string{"id", "name"}, nil, nil, idParam, []string{"name"}, []string{"name"}, nil, idParam, nil,
+		WithReadOnly(), WithRevisions(&RevisionStore{}), WithWatch(NewChangeFeed(10)),
+		WithManyToMany(ManyToMany{Name: "orders", JoinTable: "customer_orders", JoinLocal: "customer_id", JoinForeign: "order_id"}))
+	assert.NoError(t, er)
+	assert.Error(t, customers.AddAction(Action{Path: "archive", Handler: func(ctx *ActionContext) (any, error) { return nil, nil }}))
+	assert.NoError(t, customers.AddAction(Action{Path: "summary", Method: "GET", Handler: func(ctx *ActionContext) (any, error) { return nil, nil }}))
+
+	r := gin.New()
+	assert.NoError(t, customers.Register(r.Group("/api")))
+	paths := make(map[string]bool)
+	for _, route := range r.Routes() {
+		paths[route.Method+" "+route.Path] = true
+	}
+	assert.Equal(t, map[string]bool{
+		"GET /api/readonly_customers/list":        true,
+		"GET /api/readonly_customers/detail":      true,
+		"GET /api/readonly_customers/revisions":   true,
+		"GET /api/readonly_customers/watch":       true,
+		"GET /api/readonly_customers/watch/ws":    true,
+		"GET /api/readonly_customers/orders/list": true,
+		"GET /api/readonly_customers/summary":     true,
+	}, paths)
+}
+
+func TestDerivedRoutesUseOperationMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	denied := func(c *gin.Context) {
+		RenderErr2(c, 403, "forbidden")
+	}
+	idParam := []ConditionParam{{QueryName: "idEq", ColName: "id", Operation: define.OpEq}}
+	customers, er := NewCrud2("guarded_customers", &Customer{}, nil, []string{"id", "name"}, nil, nil, idParam, []string{"name"}, []string{"name"}, nil, idParam, nil,
+		WithRevisions(&RevisionStore{}), WithWatch(NewChangeFeed(10)),
+		WithManyToMany(ManyToMany{Name: "orders", JoinTable: "customer_orders", JoinLocal: "customer_id", JoinForeign: "order_id"}),
+		WithOperationMiddleware(PathList, denied), WithOperationMiddleware(PathDetail, denied), WithOperationMiddleware(PathUpdate, denied))
+	assert.NoError(t, er)
+	assert.NoError(t, customers.AddAction(Action{Path: "archive", Handler: func(ctx *ActionContext) (any, error) { return nil, nil }}))
+
+	r := gin.New()
+	assert.NoError(t, customers.Register(r.Group("/api")))
+	for _, route := range []string{"GET watch", "GET watch/ws", "GET revisions?id=1", "POST rollback", "GET orders/list?id=1", "POST orders/link", "POST archive"} {
+		parts := strings.SplitN(route, " ", 2)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(parts[0], "/api/guarded_customers/"+parts[1], nil))
+		assert.JSONEq(t, `{"code":403,"msg":"forbidden","data":null}`, w.Body.String(), route)
+	}
+}
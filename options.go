@@ -0,0 +1,144 @@
+package crud
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4"
+)
+
+// Options 资源的扩展配置
+type Options struct {
+	FieldPermissions []FieldPermission                      // 字段级读写权限
+	Masks            map[string]string                      // 字段脱敏策略，列名 -> 策略名称
+	UnmaskCheck      func(c *gin.Context) bool              // 非脱敏模式的权限校验
+	Encrypted        map[string]string                      // 加密字段，列名 -> 盲索引列名(可为空)
+	KeyProvider      KeyProvider                            // 加密字段的密钥提供者
+	BlindIndexKey    []byte                                 // 盲索引的HMAC密钥
+	Resource         string                                 // 资源名称，默认为路由前缀
+	Hooks            []ChangeHook                           // 数据变更钩子
+	Audit            AuditSink                              // 审计日志存储，为空表示不开启审计
+	Revisions        *RevisionStore                         // 版本快照存储，为空表示不开启版本记录
+	Watch            *ChangeFeed                            // 变更事件流，为空表示不开启 watch 接口
+	Relations        []Relation                             // 资源的关联，通过 expand 参数加载
+	Parent           *Parent                                // 父资源，为空表示不是子资源
+	ManyToMany       []ManyToMany                           // 通过中间表建立的多对多关联
+	RouteStyle       RouteStyle                             // 路由风格，默认为 RouteStyleRPC
+	PageSize         int                                    // 列表接口默认的每页数量，为空时为 10
+	MaxPageSize      int                                    // 列表接口每页数量的上限，为空表示不限制
+	Replicas         []*gom.DB                              // 从库，列表和详情查询按轮询路由到健康的从库
+	Router           *DBRouter                              // 主从路由，为空时按 Replicas 生成
+	Resolver         *DBResolver                            // 按租户选择数据库，为空表示所有请求使用同一个数据库
+	Timeout          time.Duration                          // 所有接口的超时时间，为 0 表示不限制
+	Timeouts         map[DefaultRoutePath]time.Duration     // 接口 -> 超时时间
+	Cache            CacheStore                             // 列表和详情查询的读缓存
+	CacheTTL         time.Duration                          // 缓存的有效期
+	ETag             bool                                   // 列表和详情接口输出 ETag
+	ETagColumn       string                                 // 计算详情 ETag 的版本列，为空时按整行计算
+	Aggregate        *AggregateOptions                      // 分组统计接口的配置，为空表示不开放
+	Facets           *FacetOptions                          // 分面统计接口的配置，为空表示不开放
+	Operations       []DefaultRoutePath                     // 开放的内置接口，为空表示全部开放
+	Middlewares      map[DefaultRoutePath][]gin.HandlerFunc // 接口的中间件，接口路径名称 -> 中间件
+
+	errs          []error           // 配置中的错误，创建资源时返回
+	columnAlias   map[string]string // json名称 -> 列名
+	entity        any               // 资源的实体
+	db            *gom.DB           // 资源使用的数据库
+	queryColumns  []string          // 列表接口的查询列
+	detailColumns []string          // 详情接口的查询列，详情的 ETag 和 If-Match 按其中调用者可读的列计算
+}
+
+// Option 修改资源扩展配置的函数
+type Option func(*Options)
+
+// NewOptions 根据实体和配置函数生成资源扩展配置
+func NewOptions(i any, options ...Option) *Options {
+	opts := &Options{
+		Masks:       maskTagsOf(i),
+		Encrypted:   encryptTagsOf(i),
+		columnAlias: columnAliasOf(i),
+	}
+	for _, option := range options {
+		option(opts)
+	}
+	return opts
+}
+
+// addError 记录配置错误，Option 无法返回错误，由创建资源的函数统一返回
+func (o *Options) addError(er error) {
+	o.errs = append(o.errs, er)
+}
+
+// validate 校验资源配置，返回所有配置错误
+func (o *Options) validate() error {
+	errs := append([]error{}, o.errs...)
+	if er := o.validateEncryption(); er != nil {
+		errs = append(errs, er)
+	}
+	if o.Router != nil && o.Router.Writer() != o.db {
+		// 写操作使用资源的数据库，主库不同时读写会落到两个数据库上
+		errs = append(errs, errors.New("router primary must be the resource database"))
+	}
+	return errors.Join(errs...)
+}
+
+// WithPageSize 设置列表接口默认的每页数量和上限，超过上限的请求按上限查询
+func WithPageSize(pageSize, maxPageSize int) Option {
+	return func(o *Options) {
+		o.PageSize = pageSize
+		o.MaxPageSize = maxPageSize
+	}
+}
+
+// columnName 将json名称或列名统一转换为列名
+func (o *Options) columnName(name string) string {
+	if col, ok := o.columnAlias[name]; ok {
+		return col
+	}
+	return name
+}
+
+// columnAliasOf 从结构体的 json 和 gom 标签中提取 json名称 -> 列名 的映射
+func columnAliasOf(i any) map[string]string {
+	alias := make(map[string]string)
+	if i == nil {
+		return alias
+	}
+	t := reflect.TypeOf(i)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return alias
+	}
+	for idx := 0; idx < t.NumField(); idx++ {
+		field := t.Field(idx)
+		col := strings.Split(field.Tag.Get("gom"), ",")[0]
+		if col == "" || col == "-" {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			name = field.Name
+		}
+		if name != col {
+			alias[name] = col
+		}
+	}
+	return alias
+}
+
+func SetContextOptions(opts *Options) gin.HandlerFunc {
+	return SetContextAny("options", opts)
+}
+
+func GetContextOptions(c *gin.Context) (*Options, bool) {
+	i, ok := GetContextAny(c, "options")
+	if ok && i != nil {
+		return i.(*Options), ok
+	}
+	return nil, false
+}
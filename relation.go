@@ -0,0 +1,435 @@
+package crud
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4"
+	"github.com/kmlixh/gom/v4/define"
+)
+
+// RelationType 关联类型
+type RelationType string
+
+const (
+	HasOne    RelationType = "has_one"    // 关联资源的 ForeignKey 指向本资源的 LocalKey，返回单个对象，ForeignKey 必须设置
+	HasMany   RelationType = "has_many"   // 关联资源的 ForeignKey 指向本资源的 LocalKey，返回数组，ForeignKey 必须设置
+	BelongsTo RelationType = "belongs_to" // 本资源的 LocalKey 指向关联资源的 ForeignKey，返回单个对象，LocalKey 必须设置
+)
+
+// Relation 资源之间的关联，列表和详情接口通过 expand 参数加载关联数据
+type Relation struct {
+	Name       string       // 关联名称，即 expand 参数中的名称和结果中的字段名
+	Type       RelationType // 关联类型
+	Resource   ICrud        // 关联资源，加载时使用其列表接口的实体、查询列、数据库和扩展配置
+	LocalKey   string       // 本资源的关联列，has_one/has_many 默认为本资源主键
+	ForeignKey string       // 关联资源的关联列，belongs_to 默认为关联资源主键
+}
+
+// validate 校验关联的配置
+func (r Relation) validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("relation name could not be empty")
+	}
+	if r.Resource == nil {
+		return fmt.Errorf("relation [%s] has no resource", r.Name)
+	}
+	switch r.Type {
+	case HasOne, HasMany:
+		if r.ForeignKey == "" {
+			return fmt.Errorf("relation [%s] of type %s requires ForeignKey", r.Name, r.Type)
+		}
+	case BelongsTo:
+		if r.LocalKey == "" {
+			return fmt.Errorf("relation [%s] of type %s requires LocalKey", r.Name, r.Type)
+		}
+	default:
+		return fmt.Errorf("relation [%s] has unknown type [%s]", r.Name, r.Type)
+	}
+	target, er := r.target()
+	if er != nil {
+		return er
+	}
+	return target.expandable(r.Name)
+}
+
+// WithRelations 声明资源的关联，配置不完整的关联在创建资源时返回错误
+func WithRelations(relations ...Relation) Option {
+	return func(o *Options) {
+		for _, relation := range relations {
+			if er := relation.validate(); er != nil {
+				o.addError(er)
+			}
+		}
+		o.Relations = append(o.Relations, relations...)
+	}
+}
+
+// relationTarget 关联资源的实体、查询列和扩展配置，数据库为关联资源的数据库
+type relationTarget struct {
+	entity any
+	cols   []string
+	opts   *Options
+}
+
+// db 关联资源查询使用的数据库，关联资源按租户选择数据库时使用请求的租户数据库，配置了从库时按主从路由选择
+func (t *relationTarget) db(c *gin.Context) *gom.DB {
+	if t.opts.Resolver != nil {
+		if db, ok := GetContextAny(c, "tenantDb"); ok {
+			return db.(*gom.DB)
+		}
+	}
+	if t.opts.Router != nil && !readFromPrimary(c) {
+		return t.opts.Router.Reader()
+	}
+	return t.opts.db
+}
+
+func (r Relation) target() (*relationTarget, error) {
+	if r.Resource == nil {
+		return nil, fmt.Errorf("relation [%s] has no resource", r.Name)
+	}
+	handler, er := r.Resource.GetHandler(string(PathList))
+	if er != nil || handler.Options == nil || handler.Options.entity == nil {
+		return nil, fmt.Errorf("relation [%s] resource has no list endpoint", r.Name)
+	}
+	return &relationTarget{entity: handler.Options.entity, cols: handler.Options.queryColumns, opts: handler.Options}, nil
+}
+
+// expandable 关联数据不经过关联资源列表接口的中间件加载，列表接口未开放或配置了鉴权等中间件的资源不能通过 expand 加载
+func (t *relationTarget) expandable(name string) error {
+	if !t.opts.operationEnabled(PathList) {
+		return fmt.Errorf("relation [%s] resource has no list endpoint", name)
+	}
+	if len(t.opts.Middlewares[PathList]) > 0 {
+		return fmt.Errorf("relation [%s] resource list has middlewares and could not be expanded", name)
+	}
+	return nil
+}
+
+// localKey 获取本资源上的关联列
+func (r Relation) localKey(localEntity any) string {
+	if r.LocalKey == "" {
+		return primaryKeyOf(localEntity)
+	}
+	return r.LocalKey
+}
+
+// foreignKey 获取关联资源上的关联列，只有 belongs_to 默认为关联资源主键
+func (r Relation) foreignKey(target *relationTarget) string {
+	if r.ForeignKey == "" && r.Type == BelongsTo {
+		return primaryKeyOf(target.entity)
+	}
+	return r.ForeignKey
+}
+
+// requestedRelations 获取 expand 参数中请求的关联
+func requestedRelations(c *gin.Context) ([]Relation, error) {
+	names := expandNames(c)
+	relations := make([]Relation, 0, len(names))
+	if len(names) == 0 {
+		return relations, nil
+	}
+	opts, _ := GetContextOptions(c)
+	for _, name := range names {
+		found := false
+		if opts != nil {
+			for _, relation := range opts.Relations {
+				if relation.Name == name {
+					relations = append(relations, relation)
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown relation [%s]", name)
+		}
+	}
+	return relations, nil
+}
+
+// withRelationKeys 在查询列中补充请求的关联需要的本资源关联列
+// 补充的列只用于加载关联数据，记录在上下文中，输出前由 expandRelations 清空
+func withRelationKeys(c *gin.Context, i any, cols []string) []string {
+	if len(cols) == 0 {
+		return cols
+	}
+	relations, er := requestedRelations(c)
+	if er != nil {
+		return cols
+	}
+	added := make([]string, 0)
+	for _, relation := range relations {
+		if local := relation.localKey(i); !containsString(cols, local) {
+			cols = append(append([]string{}, cols...), local)
+			added = append(added, local)
+		}
+	}
+	if len(added) > 0 {
+		SetContextAny("relationKeys", added)(c)
+	}
+	return cols
+}
+
+// expandNames 解析 expand 参数
+func expandNames(c *gin.Context) []string {
+	names := make([]string, 0)
+	for _, name := range strings.Split(c.Query("expand"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// expandRelations 按 expand 参数加载关联数据，data 为实体的切片或指针
+// 没有 expand 参数时原样返回，否则返回附加了关联数据的 map 或 map 切片
+func expandRelations(c *gin.Context, data any) (any, error) {
+	relations, er := requestedRelations(c)
+	if er != nil || len(relations) == 0 {
+		return data, er
+	}
+	i, ok := GetContextEntity(c)
+	if !ok {
+		return nil, fmt.Errorf("can't find data entity")
+	}
+
+	val := reflect.ValueOf(data)
+	single := val.Kind() != reflect.Slice
+	items := make([]reflect.Value, 0)
+	if single {
+		items = append(items, val)
+	} else {
+		for idx := 0; idx < val.Len(); idx++ {
+			items = append(items, val.Index(idx))
+		}
+	}
+
+	// 先读取关联列的值，再清空只为加载关联数据而查询的列，调用者未选择或不可读的列不输出
+	targets := make([]*relationTarget, len(relations))
+	keys := make([][]any, len(relations))
+	for idx, relation := range relations {
+		target, er := relation.target()
+		if er != nil {
+			return nil, er
+		}
+		if er := target.expandable(relation.Name); er != nil {
+			return nil, er
+		}
+		targets[idx] = target
+		local := relation.localKey(i)
+		keys[idx] = make([]any, len(items))
+		for n, item := range items {
+			keys[idx][n], _ = columnValue(item, local)
+		}
+	}
+	if added, ok := GetContextAny(c, "relationKeys"); ok {
+		for _, item := range items {
+			for _, col := range added.([]string) {
+				clearColumn(item, col)
+			}
+		}
+	}
+	rows := make([]map[string]any, len(items))
+	for idx, item := range items {
+		row, er := toJSONMap(item.Interface())
+		if er != nil {
+			return nil, er
+		}
+		rows[idx] = row
+	}
+
+	for idx, relation := range relations {
+		values := make([]any, 0, len(items))
+		seen := make(map[string]bool)
+		for _, v := range keys[idx] {
+			if v == nil {
+				continue
+			}
+			if key := fmt.Sprint(v); !seen[key] {
+				seen[key] = true
+				values = append(values, v)
+			}
+		}
+		grouped, er := loadRelated(c, targets[idx], relation.foreignKey(targets[idx]), values)
+		if er != nil {
+			return nil, er
+		}
+		for n := range items {
+			var related []map[string]any
+			if v := keys[idx][n]; v != nil {
+				related = grouped[fmt.Sprint(v)]
+			}
+			if relation.Type == HasMany {
+				if related == nil {
+					related = make([]map[string]any, 0)
+				}
+				rows[n][relation.Name] = related
+			} else if len(related) > 0 {
+				rows[n][relation.Name] = related[0]
+			} else {
+				rows[n][relation.Name] = nil
+			}
+		}
+	}
+	if single {
+		return rows[0], nil
+	}
+	return rows, nil
+}
+
+// loadRelated 用一次 IN 查询加载关联数据，按关联列的值分组
+// 查询按关联资源的查询列、字段权限、加密和脱敏配置进行，与直接请求关联资源的列表接口一致
+// 关联列不在可读的查询列中时只用于分组，输出前清空
+func loadRelated(c *gin.Context, target *relationTarget, foreign string, values []any) (map[string][]map[string]any, error) {
+	grouped := make(map[string][]map[string]any)
+	if len(values) == 0 {
+		return grouped, nil
+	}
+	db := target.db(c)
+	if db == nil {
+		return nil, fmt.Errorf("can't find database")
+	}
+	// 临时切换为关联资源的扩展配置，复用字段权限、解密和脱敏的处理
+	localOpts, hasLocal := GetContextOptions(c)
+	SetContextOptions(target.opts)(c)
+	defer func() {
+		if hasLocal {
+			SetContextOptions(localOpts)(c)
+		}
+	}()
+
+	cols, er := readableColumns(c, target.entity, target.cols)
+	if er != nil {
+		return nil, er
+	}
+	var result *define.Result
+	er = readTransaction(c, db, func(tx *gom.Chain) error {
+		chain := tx.Table(getTableName(target.entity))
+		if len(cols) > 0 {
+			fields := cols
+			if !containsString(cols, foreign) {
+				fields = append(append([]string{}, cols...), foreign)
+			}
+			chain = chain.Fields(fields...)
+		}
+		result = chain.Where2(define.In(foreign, values...)).List()
+		return result.Error
+	})
+	if er != nil {
+		return nil, er
+	}
+	list := reflect.New(reflect.TypeOf(CreateSliceByReflect(target.entity)))
+	if er := result.Into(list.Interface()); er != nil {
+		return nil, er
+	}
+	if er := DecryptData(c, list.Interface()); er != nil {
+		return nil, er
+	}
+	if er := MaskData(c, list.Interface()); er != nil {
+		return nil, er
+	}
+	for idx := 0; idx < list.Elem().Len(); idx++ {
+		item := list.Elem().Index(idx)
+		key, _ := columnValue(item, foreign)
+		if len(cols) > 0 && !containsString(cols, foreign) {
+			clearColumn(item, foreign)
+		}
+		row, er := toJSONMap(item.Interface())
+		if er != nil {
+			return nil, er
+		}
+		grouped[fmt.Sprint(key)] = append(grouped[fmt.Sprint(key)], row)
+	}
+	return grouped, nil
+}
+
+// columnValue 按 gom 标签中的列名读取结构体字段的值
+func columnValue(val reflect.Value, col string) (any, bool) {
+	for val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+		if val.IsNil() {
+			return nil, false
+		}
+		val = val.Elem()
+	}
+	if val.Kind() != reflect.Struct {
+		return nil, false
+	}
+	t := val.Type()
+	for idx := 0; idx < t.NumField(); idx++ {
+		if strings.Split(t.Field(idx).Tag.Get("gom"), ",")[0] == col {
+			field := val.Field(idx)
+			if field.Kind() == reflect.Ptr {
+				if field.IsNil() {
+					return nil, true
+				}
+				field = field.Elem()
+			}
+			return field.Interface(), true
+		}
+	}
+	return nil, false
+}
+
+// clearColumn 按 gom 标签中的列名将结构体字段置为零值
+func clearColumn(val reflect.Value, col string) {
+	for val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+		if val.IsNil() {
+			return
+		}
+		val = val.Elem()
+	}
+	if val.Kind() != reflect.Struct {
+		return
+	}
+	t := val.Type()
+	for idx := 0; idx < t.NumField(); idx++ {
+		if strings.Split(t.Field(idx).Tag.Get("gom"), ",")[0] == col && val.Field(idx).CanSet() {
+			val.Field(idx).SetZero()
+			return
+		}
+	}
+}
+
+// toJSONMap 将实体转换为以 json 名称为键的 map，数字保持原样输出
+func toJSONMap(i any) (map[string]any, error) {
+	data, er := json.Marshal(i)
+	if er != nil {
+		return nil, er
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	row := make(map[string]any)
+	if er := decoder.Decode(&row); er != nil {
+		return nil, er
+	}
+	return row, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// generateExpandParameter 生成 expand 参数的文档
+func generateExpandParameter(relations []Relation) ApiProperty {
+	names := make([]string, 0, len(relations))
+	for _, relation := range relations {
+		names = append(names, relation.Name)
+	}
+	return ApiProperty{
+		Name:        "expand",
+		Type:        "string",
+		Description: "加载关联数据，多个用逗号分隔，可选：" + strings.Join(names, ","),
+		Location:    "query",
+	}
+}
@@ -0,0 +1,161 @@
+package crud
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4/define"
+	"github.com/stretchr/testify/assert"
+)
+
+type Customer struct {
+	ID   int64  `json:"id" gom:"id,@,auto"`
+	Name string `json:"name" gom:"name"`
+}
+
+func (Customer) TableName() string {
+	return "customers"
+}
+
+type Order struct {
+	ID         int64  `json:"id" gom:"id,@,auto"`
+	CustomerId *int64 `json:"customerId" gom:"customer_id"`
+	No         string `json:"no" gom:"no"`
+}
+
+func (Order) TableName() string {
+	return "orders"
+}
+
+func TestColumnValue(t *testing.T) {
+	customerId := int64(5)
+	order := Order{ID: 1, CustomerId: &customerId}
+	v, ok := columnValue(reflect.ValueOf(&order), "customer_id")
+	assert.True(t, ok)
+	assert.Equal(t, int64(5), v)
+	v, ok = columnValue(reflect.ValueOf(Order{}), "customer_id")
+	assert.True(t, ok)
+	assert.Nil(t, v)
+	_, ok = columnValue(reflect.ValueOf(order), "missing")
+	assert.False(t, ok)
+}
+
+func TestExpandRelations(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	customers, er := NewCrud2("customers", &Customer{}, nil, []string{"id", "name"}, nil, nil, nil, nil, nil, nil,
+		[]ConditionParam{{QueryName: "idEq", ColName: "id", Operation: define.OpEq}}, nil)
+	assert.NoError(t, er)
+	opts := NewOptions(&Order{}, WithRelations(Relation{Name: "customer", Type: BelongsTo, Resource: customers, LocalKey: "customer_id"}))
+
+	newContext := func(url string) *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", url, nil)
+		SetContextOptions(opts)(c)
+		SetContextEntity(&Order{})(c)
+		return c
+	}
+
+	c := newContext("/orders/list?expand=owner")
+	_, er = expandRelations(c, []Order{})
+	assert.EqualError(t, er, "unknown relation [owner]")
+
+	// 没有关联值时不会查询关联资源
+	c = newContext("/orders/list?expand=customer")
+	data, er := expandRelations(c, []Order{{ID: 7, No: "A-7"}})
+	assert.NoError(t, er)
+	rows := data.([]map[string]any)
+	assert.Len(t, rows, 1)
+	assert.Equal(t, "A-7", rows[0]["no"])
+	assert.Contains(t, rows[0], "customer")
+	assert.Nil(t, rows[0]["customer"])
+
+	assert.Equal(t, []string{"id", "no", "customer_id"}, withRelationKeys(c, &Order{}, []string{"id", "no"}))
+}
+
+func TestRelationValidation(t *testing.T) {
+	customers, er := NewCrud2("customers", &Customer{}, nil, []string{"id", "name"}, nil, nil, nil, nil, nil, nil,
+		[]ConditionParam{{QueryName: "idEq", ColName: "id", Operation: define.OpEq}}, nil)
+	assert.NoError(t, er)
+	newOrders := func(relation Relation) error {
+		_, er := NewCrud2("orders", &Order{}, nil, []string{"id", "no"}, nil, nil, nil, nil, nil, nil, nil, nil, WithRelations(relation))
+		return er
+	}
+	assert.EqualError(t, newOrders(Relation{Name: "items", Type: HasMany, Resource: customers}), "relation [items] of type has_many requires ForeignKey")
+	assert.EqualError(t, newOrders(Relation{Name: "customer", Type: BelongsTo, Resource: customers}), "relation [customer] of type belongs_to requires LocalKey")
+	assert.EqualError(t, newOrders(Relation{Name: "customer", Type: "one", Resource: customers, LocalKey: "customer_id"}), "relation [customer] has unknown type [one]")
+	assert.EqualError(t, newOrders(Relation{Name: "customer", Type: BelongsTo, LocalKey: "customer_id"}), "relation [customer] has no resource")
+
+	// 加载关联数据不经过关联资源列表接口的中间件，未开放列表或配置了中间件的资源不能关联
+	guarded, er := NewCrud2("customers", &Customer{}, nil, []string{"id", "name"}, nil, nil, nil, nil, nil, nil, nil, nil,
+		WithOperationMiddleware(PathList, RequireIdentity("admin")))
+	assert.NoError(t, er)
+	assert.EqualError(t, newOrders(Relation{Name: "customer", Type: BelongsTo, Resource: guarded, LocalKey: "customer_id"}),
+		"relation [customer] resource list has middlewares and could not be expanded")
+	hidden, er := NewCrud2("customers", &Customer{}, nil, []string{"id", "name"}, nil, nil, nil, nil, nil, nil, nil, nil,
+		WithOperations(PathDetail))
+	assert.NoError(t, er)
+	assert.EqualError(t, newOrders(Relation{Name: "customer", Type: BelongsTo, Resource: hidden, LocalKey: "customer_id"}),
+		"relation [customer] resource has no list endpoint")
+}
+
+func TestExpandRelationsUsesRelatedDatabase(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	queries := make([]string, 0)
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, int64, error) {
+		queries = append(queries, query)
+		return []string{"id", "name"}, [][]driver.Value{{int64(5), "Alice"}}, 0, nil
+	})
+	customers, er := NewCrud2("customers", &Customer{}, db, []string{"id", "name"}, nil, nil, nil, nil, nil, nil,
+		[]ConditionParam{{QueryName: "idEq", ColName: "id", Operation: define.OpEq}}, nil)
+	assert.NoError(t, er)
+	opts := NewOptions(&Order{}, WithRelations(Relation{Name: "customer", Type: BelongsTo, Resource: customers, LocalKey: "customer_id"}))
+
+	// 本资源的上下文中没有数据库，关联数据从关联资源的数据库加载
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/orders/detail?expand=customer", nil)
+	SetContextOptions(opts)(c)
+	SetContextEntity(&Order{})(c)
+	customerId := int64(5)
+	data, er := expandRelations(c, &Order{ID: 7, CustomerId: &customerId})
+	assert.NoError(t, er)
+	assert.Len(t, queries, 1)
+	assert.Contains(t, queries[0], "FROM `customers`")
+	customer := data.(map[string]any)["customer"].(map[string]any)
+	assert.Equal(t, "Alice", customer["name"])
+}
+
+func TestExpandRelationsHidesKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, int64, error) {
+		return []string{"name", "id"}, [][]driver.Value{{"Alice", int64(5)}}, 0, nil
+	})
+	customers, er := NewCrud2("customers", &Customer{}, db, []string{"id", "name"}, nil, nil, nil, nil, nil, nil,
+		[]ConditionParam{{QueryName: "idEq", ColName: "id", Operation: define.OpEq}}, nil,
+		WithFieldPermissions(FieldPermission{Field: "id", Readable: []string{"admin"}}))
+	assert.NoError(t, er)
+	opts := NewOptions(&Order{},
+		WithRelations(Relation{Name: "customer", Type: BelongsTo, Resource: customers, LocalKey: "customer_id"}),
+		WithFieldPermissions(FieldPermission{Field: "customer_id", Readable: []string{"admin"}}))
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/orders/detail?expand=customer", nil)
+	SetContextOptions(opts)(c)
+	SetContextEntity(&Order{})(c)
+	cols, er := readableColumns(c, &Order{}, []string{"id", "no", "customer_id"})
+	assert.NoError(t, er)
+	assert.Equal(t, []string{"id", "no", "customer_id"}, withRelationKeys(c, &Order{}, cols))
+
+	// 关联列只用于加载关联数据，调用者不可读时不输出
+	customerId := int64(5)
+	data, er := expandRelations(c, &Order{ID: 7, No: "A-7", CustomerId: &customerId})
+	assert.NoError(t, er)
+	row := data.(map[string]any)
+	assert.Nil(t, row["customerId"])
+	customer := row["customer"].(map[string]any)
+	assert.Equal(t, "Alice", customer["name"])
+	assert.Equal(t, json.Number("0"), customer["id"])
+}
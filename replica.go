@@ -0,0 +1,213 @@
+package crud
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4"
+)
+
+// HeaderReadPrimary 请求头，值为 true 或 1 时本次请求的查询也走主库，用于写后立即读取
+const HeaderReadPrimary = "X-Read-Primary"
+
+// DBRouter 主从路由，写操作使用主库，列表和详情查询按轮询在健康的从库中选择，没有健康的从库时使用主库
+// 选择从库时距上次健康检查超过 Interval 会在后台检查一次所有从库，不需要单独启动 RunHealthCheck
+type DBRouter struct {
+	Primary  *gom.DB                                     // 主库
+	Replicas []*gom.DB                                   // 从库
+	Ping     func(ctx context.Context, db *gom.DB) error // 健康检查，默认为 sql.DB 的 PingContext
+	Timeout  time.Duration                               // 单次健康检查的超时时间，默认为 2 秒
+	Interval time.Duration                               // 选择从库时触发健康检查的间隔，默认为 10 秒，为负数时只由 CheckHealth、RunHealthCheck 检查
+
+	next      uint64
+	healthy   []atomic.Bool
+	lastCheck atomic.Int64 // 上次健康检查开始的时间，UnixNano
+	once      sync.Once
+}
+
+// NewDBRouter 创建主从路由，所有从库初始为健康状态
+func NewDBRouter(primary *gom.DB, replicas ...*gom.DB) *DBRouter {
+	r := &DBRouter{Primary: primary, Replicas: replicas}
+	r.init()
+	return r
+}
+
+func (r *DBRouter) init() {
+	r.once.Do(func() {
+		r.healthy = make([]atomic.Bool, len(r.Replicas))
+		for idx := range r.healthy {
+			r.healthy[idx].Store(true)
+		}
+		r.lastCheck.Store(time.Now().UnixNano())
+	})
+}
+
+// checkIfDue 距上次健康检查超过 Interval 时在后台检查所有从库，同一时间只有一个检查
+func (r *DBRouter) checkIfDue() {
+	interval := r.Interval
+	if interval < 0 {
+		return
+	}
+	if interval == 0 {
+		interval = 10 * time.Second
+	}
+	last, now := r.lastCheck.Load(), time.Now().UnixNano()
+	if now-last < int64(interval) || !r.lastCheck.CompareAndSwap(last, now) {
+		return
+	}
+	go r.CheckHealth(context.Background())
+}
+
+// Writer 写操作使用的数据库
+func (r *DBRouter) Writer() *gom.DB {
+	return r.Primary
+}
+
+// Reader 按轮询选择一个健康的从库，没有健康的从库时返回主库
+func (r *DBRouter) Reader() *gom.DB {
+	r.init()
+	n := len(r.Replicas)
+	if n == 0 {
+		return r.Primary
+	}
+	r.checkIfDue()
+	start := atomic.AddUint64(&r.next, 1)
+	for i := 0; i < n; i++ {
+		idx := int((start + uint64(i)) % uint64(n))
+		if r.healthy[idx].Load() {
+			return r.Replicas[idx]
+		}
+	}
+	return r.Primary
+}
+
+// SetHealthy 设置从库的健康状态，可以在查询出错时由调用方标记
+func (r *DBRouter) SetHealthy(replica *gom.DB, healthy bool) {
+	r.init()
+	for idx, db := range r.Replicas {
+		if db == replica {
+			r.healthy[idx].Store(healthy)
+		}
+	}
+}
+
+// Healthy 返回健康的从库数量
+func (r *DBRouter) Healthy() int {
+	r.init()
+	count := 0
+	for idx := range r.healthy {
+		if r.healthy[idx].Load() {
+			count++
+		}
+	}
+	return count
+}
+
+// CheckHealth 检查所有从库并更新健康状态
+func (r *DBRouter) CheckHealth(ctx context.Context) {
+	r.init()
+	r.lastCheck.Store(time.Now().UnixNano())
+	ping := r.Ping
+	if ping == nil {
+		ping = func(ctx context.Context, db *gom.DB) error {
+			if db.GetDB() == nil {
+				return errors.New("database is not open")
+			}
+			return db.GetDB().PingContext(ctx)
+		}
+	}
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	var wg sync.WaitGroup
+	for idx, db := range r.Replicas {
+		wg.Add(1)
+		go func(idx int, db *gom.DB) {
+			defer wg.Done()
+			pingCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			r.healthy[idx].Store(ping(pingCtx, db) == nil)
+		}(idx, db)
+	}
+	wg.Wait()
+}
+
+// RunHealthCheck 按 interval 定期检查从库，直到 ctx 结束
+func (r *DBRouter) RunHealthCheck(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		r.CheckHealth(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// WithReplicas 为资源配置从库，列表和详情查询会路由到从库
+func WithReplicas(replicas ...*gom.DB) Option {
+	return func(o *Options) {
+		o.Replicas = append(o.Replicas, replicas...)
+	}
+}
+
+// WithDBRouter 使用已有的主从路由，便于多个资源共用同一个健康检查，路由的主库必须是资源的数据库
+func WithDBRouter(router *DBRouter) Option {
+	return func(o *Options) {
+		o.Router = router
+	}
+}
+
+// resolveRouter 按配置生成资源的主从路由，没有配置从库时返回 nil
+func (o *Options) resolveRouter(db *gom.DB) *DBRouter {
+	if o.Router == nil && len(o.Replicas) > 0 {
+		o.Router = NewDBRouter(db, o.Replicas...)
+	}
+	return o.Router
+}
+
+// ReadFromPrimary 让本次请求的查询走主库
+func ReadFromPrimary() gin.HandlerFunc {
+	return SetContextAny("readPrimary", true)
+}
+
+// readFromPrimary 判断本次请求的查询是否需要走主库
+func readFromPrimary(c *gin.Context) bool {
+	if v, ok := GetContextAny(c, "readPrimary"); ok && v.(bool) {
+		return true
+	}
+	switch strings.ToLower(c.GetHeader(HeaderReadPrimary)) {
+	case "true", "1":
+		return true
+	}
+	return false
+}
+
+// GetContextDBRouter 获取请求的主从路由
+func GetContextDBRouter(c *gin.Context) (*DBRouter, bool) {
+	i, ok := GetContextAny(c, "dbRouter")
+	if !ok {
+		return nil, false
+	}
+	return i.(*DBRouter), true
+}
+
+// GetContextReadDatabase 获取查询使用的数据库，配置了从库时按主从路由选择
+func GetContextReadDatabase(c *gin.Context) (*gom.DB, bool) {
+	if _, ok := GetContextAny(c, "tenantDb"); ok {
+		// 租户的数据库没有从库
+		return GetContextDatabase(c)
+	}
+	if router, ok := GetContextDBRouter(c); ok && !readFromPrimary(c) {
+		return router.Reader(), true
+	}
+	return GetContextDatabase(c)
+}
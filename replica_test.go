@@ -0,0 +1,91 @@
+package crud
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDBRouterReader(t *testing.T) {
+	primary, r1, r2 := &gom.DB{}, &gom.DB{}, &gom.DB{}
+	router := NewDBRouter(primary, r1, r2)
+	assert.Same(t, primary, router.Writer())
+
+	seen := map[*gom.DB]int{}
+	for i := 0; i < 4; i++ {
+		seen[router.Reader()]++
+	}
+	assert.Equal(t, 2, seen[r1])
+	assert.Equal(t, 2, seen[r2])
+
+	// 不健康的从库不参与轮询，全部不健康时回到主库
+	router.Ping = func(ctx context.Context, db *gom.DB) error {
+		if db == r1 {
+			return errors.New("down")
+		}
+		return nil
+	}
+	router.CheckHealth(context.Background())
+	assert.Equal(t, 1, router.Healthy())
+	assert.Same(t, r2, router.Reader())
+	assert.Same(t, r2, router.Reader())
+	router.SetHealthy(r2, false)
+	assert.Same(t, primary, router.Reader())
+}
+
+func TestReadDatabaseOverride(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	primary, replica := &gom.DB{}, &gom.DB{}
+	router := NewDBRouter(primary, replica)
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/users/list", nil)
+	SetContextDatabase(primary, router)(c)
+	db, _ := GetContextReadDatabase(c)
+	assert.Same(t, replica, db)
+	db, _ = GetContextDatabase(c)
+	assert.Same(t, primary, db)
+
+	c.Request.Header.Set(HeaderReadPrimary, "true")
+	db, _ = GetContextReadDatabase(c)
+	assert.Same(t, primary, db)
+
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/users/list", nil)
+	SetContextDatabase(primary, router)(c)
+	ReadFromPrimary()(c)
+	db, _ = GetContextReadDatabase(c)
+	assert.Same(t, primary, db)
+}
+
+func TestDBRouterChecksWhenDue(t *testing.T) {
+	primary, r1, r2 := &gom.DB{}, &gom.DB{}, &gom.DB{}
+	router := NewDBRouter(primary, r1, r2)
+	router.Interval = time.Millisecond
+	router.Ping = func(ctx context.Context, db *gom.DB) error {
+		if db == r1 {
+			return errors.New("down")
+		}
+		return nil
+	}
+	time.Sleep(2 * time.Millisecond)
+	router.Reader()
+	assert.Eventually(t, func() bool { return router.Healthy() == 1 }, time.Second, time.Millisecond)
+	assert.Same(t, r2, router.Reader())
+}
+
+func TestDBRouterPrimaryMismatch(t *testing.T) {
+	db, other := &gom.DB{}, &gom.DB{}
+	_, er := NewCrud2("router_orders", &Order{}, db, []string{"id", "no"}, nil, nil, nil, nil, nil, nil, nil, nil,
+		WithDBRouter(NewDBRouter(other, &gom.DB{})))
+	assert.EqualError(t, er, "router primary must be the resource database")
+	_, er = NewCrud2("router_orders", &Order{}, db, []string{"id", "no"}, nil, nil, nil, nil, nil, nil, nil, nil,
+		WithDBRouter(NewDBRouter(db, &gom.DB{})))
+	assert.NoError(t, er)
+}
@@ -0,0 +1,245 @@
+package crud
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4/define"
+)
+
+// RouteStyle 资源的路由风格
+type RouteStyle int
+
+const (
+	// RouteStyleRPC 默认风格：GET list、GET detail、POST add、POST update、POST delete
+	RouteStyleRPC RouteStyle = iota
+	// RouteStyleREST REST 风格：GET /、GET /:id、POST /、PUT|PATCH /:id、DELETE /:id
+	RouteStyleREST
+)
+
+// WithRouteStyle 设置资源的路由风格
+func WithRouteStyle(style RouteStyle) Option {
+	return func(o *Options) {
+		o.RouteStyle = style
+	}
+}
+
+// restOperation REST 风格下需要从路径中绑定主键的操作
+type restOperation int
+
+const (
+	restDetail restOperation = iota
+	restInsert
+	restUpdate
+	restPatch
+	restDelete
+)
+
+// bindRestPath 将 REST 路径中的 :id 绑定到条件或实体中，并设置成功时的 HTTP 状态码
+// 详情和删除在处理链开头记录主键，更新要在解析请求体之后写入实体，位于最后一个处理器之前
+func bindRestPath(op restOperation) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch op {
+		case restInsert:
+			SetContextAny("successStatus", http.StatusCreated)(c)
+			return
+		case restDetail, restDelete:
+			// 主键条件由 SetConditionParamAsCnd 与查询条件合并，之后的 asOf、ETag 等处理器都能拿到
+			SetContextAny("pathKey", c.Param("id"))(c)
+			if op == restDelete {
+				SetContextAny("successStatus", http.StatusNoContent)(c)
+			}
+		case restUpdate, restPatch:
+			if _, ok := GetContextTable(c); ok {
+				// 无结构体资源的更新本身只写入请求体中出现的列
+				SetContextAny("restKey", c.Param("id"))(c)
+				return
+			}
+			i, ok := GetContextEntity(c)
+			if !ok {
+				c.Abort()
+				RenderErr2(c, 500, "can't find data entity")
+				return
+			}
+			if er := setFieldFromString(i, "ID", c.Param("id")); er != nil {
+				c.Abort()
+				RenderErrs(c, er)
+				return
+			}
+			if op == restPatch {
+				if er := restrictToBodyColumns(c, i); er != nil {
+					c.Abort()
+					RenderErrs(c, er)
+					return
+				}
+			}
+		}
+	}
+}
+
+// contextPrimaryKey 获取资源的主键列，无结构体资源取自表结构
+func contextPrimaryKey(c *gin.Context) (string, bool) {
+	if schema, ok := GetContextTable(c); ok {
+		return schema.PrimaryKey, schema.PrimaryKey != ""
+	}
+	i, ok := GetContextEntity(c)
+	if !ok {
+		return "", false
+	}
+	return primaryKeyOf(i), true
+}
+
+// restrictToBodyColumns PATCH 只更新请求体中出现的字段
+func restrictToBodyColumns(c *gin.Context, i any) error {
+	body, ok := c.Get(gin.BodyBytesKey)
+	if !ok {
+		return errors.New("request body could not be empty")
+	}
+	maps := make(map[string]any)
+	if er := json.Unmarshal(body.([]byte), &maps); er != nil {
+		return er
+	}
+	transfer := define.GetTransfer(i)
+	if transfer == nil {
+		return errors.New("entity is not a struct")
+	}
+	// 只保留实体中存在且在更新白名单内的列
+	known := transfer.ToMap(i)
+	allowed := getSelectColumns(c)
+	opts, _ := GetContextOptions(c)
+	cols := make([]string, 0, len(maps))
+	for name := range maps {
+		col := name
+		if opts != nil {
+			col = opts.columnName(name)
+		}
+		if _, ok := known[col]; !ok || (len(allowed) > 0 && !containsString(allowed, col)) {
+			continue
+		}
+		if !containsString(cols, col) {
+			cols = append(cols, col)
+		}
+	}
+	if len(cols) == 0 {
+		return errors.New("no updatable fields in request body")
+	}
+	SetColumns(cols)(c)
+	return nil
+}
+
+// setFieldFromString 将字符串转换为字段的类型后写入结构体字段
+func setFieldFromString(i any, name string, value string) error {
+	val := reflect.ValueOf(i)
+	if val.Kind() == reflect.Ptr {
+		val = val.Elem()
+	}
+	field := val.FieldByName(name)
+	if !field.IsValid() || !field.CanSet() {
+		return errors.New("no " + name + " field found")
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, er := strconv.ParseInt(value, 10, 64)
+		if er != nil {
+			return errors.New("invalid id")
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, er := strconv.ParseUint(value, 10, 64)
+		if er != nil {
+			return errors.New("invalid id")
+		}
+		field.SetUint(n)
+	default:
+		return errors.New("unsupported id type")
+	}
+	return nil
+}
+
+// pathCondition REST 路径中的主键对应的条件，不是 REST 风格的详情和删除时返回nil
+func pathCondition(c *gin.Context) (*define.Condition, error) {
+	key, ok := GetContextAny(c, "pathKey")
+	if !ok {
+		return nil, nil
+	}
+	pk, ok := contextPrimaryKey(c)
+	if !ok {
+		return nil, errors.New("can't find data entity")
+	}
+	return define.Eq(pk, key), nil
+}
+
+// contextHandlerCount 处理链开头设置数据库和扩展配置的处理器数量，即 SetContextDatabase、SetContextOptions
+const contextHandlerCount = 2
+
+// insertAfterContext 在处理链开头的 SetContextDatabase、SetContextOptions 之后插入处理器
+func insertAfterContext(funcs []gin.HandlerFunc, handlers ...gin.HandlerFunc) []gin.HandlerFunc {
+	result := make([]gin.HandlerFunc, 0, len(funcs)+len(handlers))
+	result = append(result, funcs[:contextHandlerCount]...)
+	result = append(result, handlers...)
+	result = append(result, funcs[contextHandlerCount:]...)
+	return result
+}
+
+// insertBeforeLast 在处理链的最后一个处理器（执行数据库操作的处理器）之前插入处理器
+func insertBeforeLast(funcs []gin.HandlerFunc, handler gin.HandlerFunc) []gin.HandlerFunc {
+	result := make([]gin.HandlerFunc, 0, len(funcs)+1)
+	result = append(result, funcs[:len(funcs)-1]...)
+	result = append(result, handler, funcs[len(funcs)-1])
+	return result
+}
+
+// restHandlers 将默认的增删改查接口映射为 REST 风格的路由，其余接口保持不变
+func restHandlers(handlers []RouteHandler) []RouteHandler {
+	idParam := ApiProperty{Name: "id", Type: "string", Required: true, Description: "主键值", Location: "path"}
+	result := make([]RouteHandler, 0, len(handlers)+1)
+	for _, handler := range handlers {
+		switch DefaultRoutePath(handler.Path) {
+		case PathList:
+			handler.Route = "/"
+		case PathDetail:
+			handler.Route = ":id"
+			handler.Parameters = append([]ApiProperty{idParam}, handler.Parameters...)
+			handler.Handlers = insertAfterContext(handler.Handlers, bindRestPath(restDetail))
+		case PathAdd:
+			handler.Route = "/"
+			handler.Handlers = insertBeforeLast(handler.Handlers, bindRestPath(restInsert))
+			handler.Response = withStatus(handler.Response, http.StatusCreated)
+		case PathUpdate:
+			patch := handler
+			handler.Route = ":id"
+			handler.HttpMethod = http.MethodPut
+			handler.Parameters = append([]ApiProperty{idParam}, handler.Parameters...)
+			handler.Handlers = insertBeforeLast(handler.Handlers, bindRestPath(restUpdate))
+			patch.Path = string(PathPatch)
+			patch.Operation = PathUpdate
+			patch.Route = ":id"
+			patch.HttpMethod = http.MethodPatch
+			patch.Name = handler.Name + "(部分字段)"
+			patch.Description = handler.Description + "，只更新请求体中出现的字段"
+			patch.Parameters = append([]ApiProperty{idParam}, patch.Parameters...)
+			patch.Handlers = insertBeforeLast(patch.Handlers, bindRestPath(restPatch))
+			result = append(result, handler, patch)
+			continue
+		case PathDelete:
+			handler.Route = ":id"
+			handler.HttpMethod = http.MethodDelete
+			handler.Parameters = append([]ApiProperty{idParam}, handler.Parameters...)
+			handler.Handlers = insertAfterContext(handler.Handlers, bindRestPath(restDelete))
+			handler.Response = APIResponse{Description: "删除成功，无返回内容", StatusCode: http.StatusNoContent}
+		}
+		result = append(result, handler)
+	}
+	return result
+}
+
+func withStatus(response APIResponse, status int) APIResponse {
+	response.StatusCode = status
+	return response
+}
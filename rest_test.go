@@ -0,0 +1,100 @@
+package crud
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4/define"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	idParam := []ConditionParam{{QueryName: "idEq", ColName: "id", Operation: define.OpEq}}
+	orders, er := NewCrud2("rest_orders", &Order{}, nil, []string{"id", "no"}, nil, nil, idParam, []string{"no"}, []string{"no"}, nil, idParam, nil,
+		WithRouteStyle(RouteStyleREST))
+	assert.NoError(t, er)
+
+	r := gin.New()
+	assert.NoError(t, orders.Register(r.Group("/api")))
+	paths := make(map[string]bool)
+	for _, route := range r.Routes() {
+		paths[route.Method+" "+route.Path] = true
+	}
+	for _, path := range []string{"GET /api/rest_orders", "GET /api/rest_orders/:id", "POST /api/rest_orders",
+		"PUT /api/rest_orders/:id", "PATCH /api/rest_orders/:id", "DELETE /api/rest_orders/:id", "GET /api/rest_orders/struct"} {
+		assert.True(t, paths[path], path)
+	}
+	assert.False(t, paths["GET /api/rest_orders/list"])
+
+	docs := make(map[string]APIDoc)
+	for _, doc := range globalAPIRegistry.GetAPIsByGroup("rest_orders") {
+		docs[doc.Method+" "+doc.Path] = doc
+	}
+	assert.Equal(t, http.StatusCreated, docs["POST rest_orders"].Response.StatusCode)
+	assert.Equal(t, http.StatusNoContent, docs["DELETE rest_orders/:id"].Response.StatusCode)
+	assert.Equal(t, "path", docs["PATCH rest_orders/:id"].Parameters[0].Location)
+}
+
+func TestRestPatchColumns(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("PATCH", "/orders/7", strings.NewReader(`{"no":"A1","unknown":1}`))
+	c.Params = gin.Params{{Key: "id", Value: "7"}}
+	SetContextOptions(NewOptions(&Order{}))(c)
+	DefaultUnMarshFunc(&Order{})(c)
+	bindRestPath(restPatch)(c)
+	assert.False(t, c.IsAborted())
+
+	i, _ := GetContextEntity(c)
+	assert.Equal(t, int64(7), i.(*Order).ID)
+	assert.Equal(t, []string{"no"}, getSelectColumns(c))
+}
+
+func TestRenderSuccessStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	SetContextAny("successStatus", http.StatusNoContent)(c)
+	RenderOk(c, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Empty(t, w.Body.String())
+
+	// 错误响应不受成功状态码影响
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	SetContextAny("successStatus", http.StatusCreated)(c)
+	RenderErr2(c, 404, "record not found")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestRestDetailAsOfUsesPathId(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var recordIds []driver.Value
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, int64, error) {
+		switch {
+		case strings.HasPrefix(query, "SELECT * FROM `orders`"):
+			return []string{"id", "no"}, [][]driver.Value{{args[0], "A-" + fmt.Sprint(args[0])}}, 0, nil
+		case strings.Contains(query, "FROM `orders_revisions`"):
+			recordIds = append(recordIds, args[1])
+			return []string{"id"}, nil, 0, nil
+		}
+		return nil, nil, 0, fmt.Errorf("unexpected query: %s", query)
+	})
+	idParam := []ConditionParam{{QueryName: "idEq", ColName: "id", Operation: define.OpEq}}
+	orders, er := NewCrud2("rest_asof_orders", &Order{}, db, []string{"id", "no"}, nil, []string{"id", "no"}, idParam, []string{"no"}, []string{"no"}, nil, idParam, nil,
+		WithRouteStyle(RouteStyleREST), WithRevisions(&RevisionStore{DB: db}))
+	assert.NoError(t, er)
+	r := gin.New()
+	assert.NoError(t, orders.Register(r.Group("/api")))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/api/rest_asof_orders/7?asOf=2024-01-01T00:00:00Z", nil))
+	assert.Contains(t, w.Body.String(), `"code":200`)
+	assert.Equal(t, []driver.Value{"7"}, recordIds)
+}
@@ -0,0 +1,394 @@
+package crud
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4"
+	"github.com/kmlixh/gom/v4/define"
+)
+
+// Revision 一行数据在某个版本的完整快照
+type Revision struct {
+	ID        int64     `json:"id" gom:"id,@,auto"`
+	Resource  string    `json:"resource" gom:"resource"`
+	RecordId  string    `json:"recordId" gom:"record_id"`
+	Revision  int64     `json:"revision" gom:"revision"`
+	Operation string    `json:"operation" gom:"operation"`
+	Snapshot  string    `json:"snapshot" gom:"snapshot"` // 变更后的整行数据，JSON格式，删除时为变更前的数据
+	ActorId   string    `json:"actorId" gom:"actor_id"`
+	ActorType string    `json:"actorType" gom:"actor_type"`
+	CreatedAt time.Time `json:"createdAt" gom:"created_at"`
+}
+
+// Row 将快照解析为行数据
+func (r Revision) Row() (map[string]any, error) {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(r.Snapshot)))
+	decoder.UseNumber()
+	row := make(map[string]any)
+	if er := decoder.Decode(&row); er != nil {
+		return nil, er
+	}
+	for k, v := range row {
+		if n, ok := v.(json.Number); ok {
+			row[k] = n.String()
+		}
+	}
+	return row, nil
+}
+
+// RevisionStore 将数据的版本快照保存在影子表中
+// 影子表应在 (resource, record_id, revision) 上建立唯一索引，并发写入同一行时重复的版本号会使写操作失败而不是重复保存
+type RevisionStore struct {
+	DB    *gom.DB
+	Table string // 影子表名称，为空时使用 {表名}_revisions
+}
+
+func (s *RevisionStore) tableOf(table string) string {
+	if s.Table != "" {
+		return s.Table
+	}
+	return table + "_revisions"
+}
+
+// Save 在写操作所在的事务中保存变更后的快照，版本号按数据递增
+// 读取最新版本号时加锁(FOR UPDATE)，同一行数据的并发写入在此排队，不会得到相同的版本号
+func (s *RevisionStore) Save(tx *gom.Chain, change *Change) error {
+	table := s.tableOf(change.Table)
+	recordId := keyString(change.Keys)
+	row := change.After
+	if change.Operation == ChangeDelete {
+		row = change.Before
+	}
+	snapshot, er := json.Marshal(row)
+	if er != nil {
+		return er
+	}
+	sqlStr, args := s.DB.Factory.BuildSelect(table, []string{"revision"},
+		[]*define.Condition{define.Eq("resource", change.Resource).And(define.Eq("record_id", recordId))},
+		s.DB.Factory.BuildOrderBy([]define.OrderBy{{Field: "revision", Type: define.OrderDesc}}), 1, 0)
+	result := tx.RawQuery(sqlStr+" FOR UPDATE", args...)
+	if result.Error != nil {
+		return result.Error
+	}
+	var revision int64 = 1
+	if len(result.Data) > 0 {
+		last, er := strconv.ParseInt(fmt.Sprint(normalizeValue(result.Data[0]["revision"])), 10, 64)
+		if er != nil {
+			return er
+		}
+		revision = last + 1
+	}
+	return insertRow(s.DB, tx, table, map[string]any{
+		"resource":   change.Resource,
+		"record_id":  recordId,
+		"revision":   revision,
+		"operation":  string(change.Operation),
+		"snapshot":   string(snapshot),
+		"actor_id":   change.Actor.UserId,
+		"actor_type": change.Actor.UserType,
+		"created_at": change.Time,
+	})
+}
+
+// List 按版本号倒序查询一行数据的所有版本
+func (s *RevisionStore) List(table string, resource string, recordId string) ([]Revision, error) {
+	revisions := make([]Revision, 0)
+	result := s.DB.Chain().Table(s.tableOf(table)).Eq("resource", resource).Eq("record_id", recordId).OrderByDesc("revision").List()
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if er := result.Into(&revisions); er != nil {
+		return nil, er
+	}
+	return revisions, nil
+}
+
+// Get 查询一行数据的指定版本
+func (s *RevisionStore) Get(table string, resource string, recordId string, revision int64) (*Revision, error) {
+	return s.first(s.DB.Chain().Table(s.tableOf(table)).Eq("resource", resource).Eq("record_id", recordId).Eq("revision", revision))
+}
+
+// AsOf 查询一行数据在某一时刻的版本，不存在时返回nil
+func (s *RevisionStore) AsOf(table string, resource string, recordId string, at time.Time) (*Revision, error) {
+	return s.first(s.DB.Chain().Table(s.tableOf(table)).Eq("resource", resource).Eq("record_id", recordId).Le("created_at", at).OrderByDesc("revision"))
+}
+
+func (s *RevisionStore) first(chain *gom.Chain) (*Revision, error) {
+	revision := &Revision{}
+	result := chain.First()
+	if result.Error != nil {
+		if errors.Is(result.Error, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	if er := result.Into(revision); er != nil {
+		return nil, er
+	}
+	return revision, nil
+}
+
+// RevisionHook 在每次变更后保存数据快照的变更钩子
+type RevisionHook struct {
+	Store *RevisionStore
+}
+
+func (h RevisionHook) InTx(c *gin.Context, tx *gom.Chain, change *Change) error {
+	return h.Store.Save(tx, change)
+}
+
+func (h RevisionHook) AfterCommit(c *gin.Context, change *Change) {}
+
+// WithRevisions 开启版本快照，注册 revisions、rollback 接口，detail 接口支持 asOf 参数
+func WithRevisions(store *RevisionStore) Option {
+	return func(o *Options) {
+		o.Revisions = store
+		o.Hooks = append(o.Hooks, RevisionHook{Store: store})
+	}
+}
+
+// parseAsOf 解析时间参数，支持 RFC3339、"2006-01-02 15:04:05" 和秒/毫秒时间戳
+func parseAsOf(s string) (time.Time, error) {
+	if n, er := strconv.ParseInt(s, 10, 64); er == nil {
+		if n > 1e12 {
+			return time.UnixMilli(n), nil
+		}
+		return time.Unix(n, 0), nil
+	}
+	if t, er := time.Parse(time.RFC3339, s); er == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02 15:04:05", s, time.Local)
+}
+
+// revisionContext 获取版本接口需要的配置、实体和表名
+func revisionContext(c *gin.Context) (*Options, any, string, bool) {
+	opts, ok := GetContextOptions(c)
+	if !ok || opts.Revisions == nil {
+		RenderErr2(c, 500, "revisions are not enabled")
+		return nil, nil, "", false
+	}
+	i, ok := GetContextEntity(c)
+	if !ok {
+		RenderErr2(c, 500, "can't find data entity")
+		return nil, nil, "", false
+	}
+	return opts, i, getTableName(i), true
+}
+
+// visibleRevisionRow 按字段权限、解密和脱敏配置处理快照中的数据，出错时返回响应码
+func visibleRevisionRow(c *gin.Context, i any, revision Revision) (map[string]any, int, error) {
+	row, er := revision.Row()
+	if er != nil {
+		return nil, 500, er
+	}
+	cols, er := readableColumns(c, i, getSelectColumns(c))
+	if er != nil {
+		return nil, 500, er
+	}
+	if len(cols) > 0 {
+		picked := make(map[string]any, len(cols))
+		for _, col := range cols {
+			if val, ok := row[col]; ok {
+				picked[col] = val
+			}
+		}
+		row = picked
+	}
+	if er := DecryptData(c, row); er != nil {
+		return nil, 500, er
+	}
+	if er := MaskData(c, row); er != nil {
+		return nil, 403, er
+	}
+	return row, 0, nil
+}
+
+// renderRevisionRow 输出快照中调用者可见的数据，删除的版本输出空
+func renderRevisionRow(c *gin.Context, i any, revision *Revision) {
+	if revision == nil || revision.Operation == string(ChangeDelete) {
+		RenderOk(c, nil)
+		return
+	}
+	row, code, er := visibleRevisionRow(c, i, *revision)
+	if er != nil {
+		RenderErr2(c, code, er.Error())
+		return
+	}
+	RenderOk(c, row)
+}
+
+// QueryAsOf 详情接口带 asOf 参数时返回数据在该时刻的版本，参数 id 为主键值
+// 没有 id 参数时按详情的查询条件找到当前数据的主键，子资源只能查询父资源下的数据
+func QueryAsOf() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		asOf := c.Query("asOf")
+		if asOf == "" {
+			return
+		}
+		defer c.Abort()
+		at, er := parseAsOf(asOf)
+		if er != nil {
+			RenderErrs(c, er)
+			return
+		}
+		opts, i, table, ok := revisionContext(c)
+		if !ok {
+			return
+		}
+		id := c.Query("id")
+		if id == "" {
+			cond, ok := getScopedCondition(c)
+			if !ok || cond == nil {
+				RenderErrs(c, errors.New("id could not be empty"))
+				return
+			}
+			rows, er := queryRows(opts.Revisions.DB, opts.Revisions.DB.Chain(), table, cond, 1)
+			if er != nil {
+				RenderErr2(c, 500, er.Error())
+				return
+			}
+			if len(rows) == 0 {
+				RenderOk(c, nil)
+				return
+			}
+			id = keyString(map[string]any{primaryKeyOf(i): rows[0][primaryKeyOf(i)]})
+		} else if !requireParent(c, id) {
+			return
+		}
+		revision, er := opts.Revisions.AsOf(table, opts.Resource, id, at)
+		if er != nil {
+			RenderErr2(c, 500, er.Error())
+			return
+		}
+		renderRevisionRow(c, i, revision)
+	}
+}
+
+// QueryRevisions 查询一行数据的所有版本，参数 id 为主键值，快照与 asOf 一样只输出调用者可见的数据
+// 子资源只能查询父资源下的数据
+func QueryRevisions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		opts, i, table, ok := revisionContext(c)
+		if !ok {
+			return
+		}
+		id := c.Query("id")
+		if id == "" {
+			RenderErrs(c, errors.New("id could not be empty"))
+			return
+		}
+		if !requireParent(c, id) {
+			return
+		}
+		revisions, er := opts.Revisions.List(table, opts.Resource, id)
+		if er != nil {
+			RenderErr2(c, 500, er.Error())
+			return
+		}
+		for idx := range revisions {
+			row, code, er := visibleRevisionRow(c, i, revisions[idx])
+			if er != nil {
+				RenderErr2(c, code, er.Error())
+				return
+			}
+			snapshot, er := json.Marshal(row)
+			if er != nil {
+				RenderErr2(c, 500, er.Error())
+				return
+			}
+			revisions[idx].Snapshot = string(snapshot)
+		}
+		RenderOk(c, revisions)
+	}
+}
+
+// DoRollback 将一行数据恢复到指定版本，参数为 id 和 revision
+// 恢复通过正常的更新流程执行，因此同样受写入白名单、字段权限、加密和变更钩子的约束
+func DoRollback() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		opts, i, table, ok := revisionContext(c)
+		if !ok {
+			return
+		}
+		maps, er := GetMapFromRst(c)
+		if er != nil {
+			RenderErrs(c, er)
+			return
+		}
+		id := formatValue(maps["id"])
+		revisionNum, er := strconv.ParseInt(formatValue(maps["revision"]), 10, 64)
+		if maps["id"] == nil || er != nil {
+			RenderErrs(c, errors.New("id and revision could not be empty"))
+			return
+		}
+		revision, er := opts.Revisions.Get(table, opts.Resource, id, revisionNum)
+		if er != nil {
+			RenderErr2(c, 500, er.Error())
+			return
+		}
+		if revision == nil || revision.Operation == string(ChangeDelete) {
+			RenderErr2(c, 404, "revision not found")
+			return
+		}
+		current, er := queryRows(opts.Revisions.DB, opts.Revisions.DB.Chain(), table, scopeCondition(c, define.Eq(primaryKeyOf(i), id)), 1)
+		if er != nil {
+			RenderErr2(c, 500, er.Error())
+			return
+		}
+		if len(current) == 0 {
+			RenderErr2(c, 404, "record not found")
+			return
+		}
+		row, er := revision.Row()
+		if er != nil {
+			RenderErr2(c, 500, er.Error())
+			return
+		}
+		if er := DecryptData(c, row); er != nil {
+			RenderErr2(c, 500, er.Error())
+			return
+		}
+		entity := reflect.New(reflect.TypeOf(i).Elem()).Interface()
+		if er := (&define.Result{Data: []map[string]any{row}}).Into(entity); er != nil {
+			RenderErr2(c, 500, er.Error())
+			return
+		}
+		SetContextEntity(entity)(c)
+		DoUpdate()(c)
+	}
+}
+
+func GetRevisionsHandler(name, description string, parameters []ApiProperty, response APIResponse, beforeCommitFunc ...gin.HandlerFunc) RouteHandler {
+	return withOperation(GetRouteHandler(string(PathRevisions), "GET", name, description, parameters, response, append(beforeCommitFunc, QueryRevisions())...), PathDetail)
+}
+
+func GetRollbackHandler(name, description string, parameters []ApiProperty, response APIResponse, beforeCommitFunc ...gin.HandlerFunc) RouteHandler {
+	return withOperation(GetRouteHandler(string(PathRollback), "POST", name, description, parameters, response, append(beforeCommitFunc, DoRollback())...), PathUpdate)
+}
+
+func generateRevisionsResponse(modelName string) APIResponse {
+	resp := NewCodeMsgResponse("获取"+modelName+"版本列表", 200, "ok")
+	resp.Content["data"] = MediaType{
+		Schema: &ApiProperty{
+			Type:   "array",
+			Fields: GenerateApiPropertiesFromStruct(Revision{}),
+		},
+	}
+	return resp
+}
+
+func generateRollbackParameters() []ApiProperty {
+	return []ApiProperty{
+		{Name: "id", Type: "string", Required: true, Description: "主键值", Location: "body"},
+		{Name: "revision", Type: "integer", Required: true, Description: "要恢复的版本号", Location: "body"},
+	}
+}
@@ -0,0 +1,66 @@
+package crud
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4/define"
+	"github.com/stretchr/testify/assert"
+)
+
+type Article struct {
+	ID        int64     `json:"id" gom:"id,@,auto"`
+	Title     string    `json:"title" gom:"title"`
+	Views     int       `json:"views" gom:"views"`
+	UpdatedAt time.Time `json:"updatedAt" gom:"updated_at"`
+}
+
+func TestParseAsOf(t *testing.T) {
+	at, er := parseAsOf("1700000000")
+	assert.NoError(t, er)
+	assert.Equal(t, int64(1700000000), at.Unix())
+
+	at, er = parseAsOf("1700000000123")
+	assert.NoError(t, er)
+	assert.Equal(t, int64(1700000000123), at.UnixMilli())
+
+	at, er = parseAsOf("2024-01-02T03:04:05Z")
+	assert.NoError(t, er)
+	assert.Equal(t, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), at.UTC())
+
+	_, er = parseAsOf("yesterday")
+	assert.Error(t, er)
+}
+
+func TestRevisionRow(t *testing.T) {
+	updated := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	revision := Revision{Snapshot: `{"id":12,"title":"hello","views":3,"updated_at":"2024-01-02T03:04:05Z"}`}
+	row, er := revision.Row()
+	assert.NoError(t, er)
+
+	article := &Article{}
+	assert.NoError(t, (&define.Result{Data: []map[string]any{row}}).Into(article))
+	assert.Equal(t, int64(12), article.ID)
+	assert.Equal(t, "hello", article.Title)
+	assert.Equal(t, 3, article.Views)
+	assert.True(t, updated.Equal(article.UpdatedAt))
+}
+
+func TestVisibleRevisionRow(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/articles/revisions?id=12", nil)
+	c.Set("userType", "staff")
+	SetContextOptions(NewOptions(&Article{}, WithMask("title", MaskName),
+		WithFieldPermissions(FieldPermission{Field: "views", Readable: []string{"editor"}})))(c)
+
+	row, _, er := visibleRevisionRow(c, &Article{}, Revision{Snapshot: `{"id":12,"title":"hello","views":3}`})
+	assert.NoError(t, er)
+	assert.Equal(t, map[string]any{"id": "12", "title": "h****"}, row)
+
+	// 1e7 以上的主键不能被格式化为科学计数法
+	assert.Equal(t, "12345678", formatValue(float64(12345678)))
+	assert.Equal(t, "1.5", formatValue(1.5))
+}
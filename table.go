@@ -0,0 +1,676 @@
+package crud
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4"
+	"github.com/kmlixh/gom/v4/define"
+)
+
+// TableSchema 无结构体资源的表结构，来自数据库元数据
+type TableSchema struct {
+	Info       *define.TableInfo            // 数据库中的表信息
+	PrimaryKey string                       // 主键列，没有主键时为空
+	Columns    map[string]define.ColumnInfo // 列名 -> 列信息
+}
+
+// NewTableSchema 读取数据库中表的元数据
+func NewTableSchema(db *gom.DB, table string) (*TableSchema, error) {
+	if table == "" {
+		return nil, errors.New("table name cannot be empty")
+	}
+	info, er := db.GetTableInfo(table)
+	if er != nil {
+		return nil, er
+	}
+	return newTableSchema(info)
+}
+
+func newTableSchema(info *define.TableInfo) (*TableSchema, error) {
+	if len(info.Columns) == 0 {
+		return nil, fmt.Errorf("table [%s] has no columns", info.TableName)
+	}
+	schema := &TableSchema{Info: info, Columns: make(map[string]define.ColumnInfo, len(info.Columns))}
+	for _, col := range info.Columns {
+		schema.Columns[col.Name] = col
+		if col.IsPrimaryKey && schema.PrimaryKey == "" {
+			schema.PrimaryKey = col.Name
+		}
+	}
+	if schema.PrimaryKey == "" && len(info.PrimaryKeys) > 0 {
+		schema.PrimaryKey = info.PrimaryKeys[0]
+	}
+	return schema, nil
+}
+
+// ColumnNames 按表中的顺序返回所有列名
+func (s *TableSchema) ColumnNames() []string {
+	names := make([]string, 0, len(s.Info.Columns))
+	for _, col := range s.Info.Columns {
+		names = append(names, col.Name)
+	}
+	return names
+}
+
+// writableColumnNames 可以写入的列，insert 为 true 时去掉自增列，否则去掉主键
+func (s *TableSchema) writableColumnNames(insert bool) []string {
+	names := make([]string, 0, len(s.Info.Columns))
+	for _, col := range s.Info.Columns {
+		if (insert && col.IsAutoIncrement) || (!insert && col.Name == s.PrimaryKey) {
+			continue
+		}
+		names = append(names, col.Name)
+	}
+	return names
+}
+
+// ConditionParams 按列的类型生成默认的查询条件参数
+func (s *TableSchema) ConditionParams() []ConditionParam {
+	params := make([]ConditionParam, 0)
+	for _, col := range s.Info.Columns {
+		params = append(params, conditionParamsOf(col.Name, col.DataType)...)
+	}
+	return params
+}
+
+// ApiProperties 按列信息生成文档中的字段说明
+func (s *TableSchema) ApiProperties() []ApiProperty {
+	properties := make([]ApiProperty, 0, len(s.Info.Columns))
+	for _, col := range s.Info.Columns {
+		properties = append(properties, ApiProperty{
+			Name:        col.Name,
+			Type:        apiTypeOf(col.DataType),
+			Required:    !col.IsNullable && !col.IsAutoIncrement && col.DefaultValue == "",
+			Description: col.Comment,
+		})
+	}
+	return properties
+}
+
+// conditionParamsOf 按字段类型生成默认的查询条件参数，查询参数名为 列名+操作，如 idEq、nameLike
+func conditionParamsOf(col string, t reflect.Type) []ConditionParam {
+	kind := reflect.String
+	if t != nil {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		kind = t.Kind()
+	}
+	ops := []string{"Eq", "NotEq"}
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		ops = append(ops, "Gt", "Ge", "Lt", "Le", "In", "NotIn")
+	case reflect.String:
+		ops = append(ops, "Like", "LikeLeft", "LikeRight", "NotLike", "In", "NotIn")
+	case reflect.Struct:
+		if t == reflect.TypeOf(time.Time{}) {
+			ops = append(ops, "Gt", "Ge", "Lt", "Le")
+		}
+	}
+	params := make([]ConditionParam, 0, len(ops))
+	for _, op := range ops {
+		params = append(params, ConditionParam{QueryName: col + op, ColName: col, DataType: kind})
+	}
+	return params
+}
+
+// deleteConditionParams 删除只能按主键的 Eq、In 条件定位数据，避免 NotEq、Gt 等条件一次删除整张表
+func deleteConditionParams(params []ConditionParam) []ConditionParam {
+	result := make([]ConditionParam, 0, 2)
+	for _, param := range params {
+		if op := strings.TrimPrefix(param.QueryName, param.ColName); op == "Eq" || op == "In" {
+			result = append(result, param)
+		}
+	}
+	return result
+}
+
+// apiTypeOf 列类型对应的文档类型
+func apiTypeOf(t reflect.Type) string {
+	if t == nil {
+		return "string"
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Bool:
+		return "boolean"
+	default:
+		return "string"
+	}
+}
+
+// coerce 按列类型校验并转换请求中的值
+func (s *TableSchema) coerce(col define.ColumnInfo, v any) (any, error) {
+	if v == nil {
+		if !col.IsNullable && !col.IsAutoIncrement {
+			return nil, fmt.Errorf("column [%s] could not be null", col.Name)
+		}
+		return nil, nil
+	}
+	t := col.DataType
+	if t == nil {
+		return v, nil
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	text := fmt.Sprint(v)
+	if n, ok := v.(json.Number); ok {
+		text = n.String()
+	}
+	invalid := fmt.Errorf("column [%s] expects %s", col.Name, apiTypeOf(t))
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if _, ok := v.(bool); ok {
+			return nil, invalid
+		}
+		n, er := strconv.ParseInt(text, 10, 64)
+		if er != nil {
+			return nil, invalid
+		}
+		return n, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if _, ok := v.(bool); ok {
+			return nil, invalid
+		}
+		n, er := strconv.ParseUint(text, 10, 64)
+		if er != nil {
+			return nil, invalid
+		}
+		return n, nil
+	case reflect.Float32, reflect.Float64:
+		if _, ok := v.(bool); ok {
+			return nil, invalid
+		}
+		n, er := strconv.ParseFloat(text, 64)
+		if er != nil {
+			return nil, invalid
+		}
+		return n, nil
+	case reflect.Bool:
+		b, er := strconv.ParseBool(text)
+		if er != nil {
+			return nil, invalid
+		}
+		return b, nil
+	case reflect.Struct:
+		if t != reflect.TypeOf(time.Time{}) {
+			return v, nil
+		}
+		if tm, er := parseAsOf(text); er == nil {
+			return tm, nil
+		}
+		if tm, er := time.ParseInLocation("2006-01-02", text, time.Local); er == nil {
+			return tm, nil
+		}
+		return nil, fmt.Errorf("column [%s] expects a time", col.Name)
+	case reflect.String:
+		switch v.(type) {
+		case map[string]any, []any:
+			return nil, invalid
+		}
+		if col.Length > 0 && int64(utf8.RuneCountInString(text)) > col.Length {
+			return nil, fmt.Errorf("column [%s] exceeds max length %d", col.Name, col.Length)
+		}
+		return text, nil
+	}
+	return v, nil
+}
+
+// bindRow 从 JSON 请求体中读取一行数据并按表结构校验，所有错误一起返回
+// insert 为 true 时校验非空且没有默认值的列是否都已提供
+func (s *TableSchema) bindRow(c *gin.Context, insert bool) (map[string]any, error) {
+	body, er := requestBody(c)
+	if er != nil {
+		return nil, er
+	}
+	raw := make(map[string]any)
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if er := decoder.Decode(&raw); er != nil {
+		return nil, er
+	}
+	row := make(map[string]any, len(raw))
+	errs := make([]string, 0)
+	for name, v := range raw {
+		col, ok := s.Columns[name]
+		if !ok {
+			errs = append(errs, fmt.Sprintf("unknown column [%s]", name))
+			continue
+		}
+		val, er := s.coerce(col, v)
+		if er != nil {
+			errs = append(errs, er.Error())
+			continue
+		}
+		row[name] = val
+	}
+	if er := bindParentField(c, row); er != nil {
+		errs = append(errs, er.Error())
+	}
+	if insert {
+		for _, col := range s.Info.Columns {
+			_, bound := row[col.Name]
+			if _, given := raw[col.Name]; !bound && !given && !col.IsNullable && !col.IsAutoIncrement && col.DefaultValue == "" {
+				errs = append(errs, fmt.Sprintf("column [%s] is required", col.Name))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return nil, errors.New(strings.Join(errs, "; "))
+	}
+	return row, nil
+}
+
+// SetContextTable 设置无结构体资源的表结构
+func SetContextTable(schema *TableSchema) gin.HandlerFunc {
+	return SetContextAny("tableSchema", schema)
+}
+
+// GetContextTable 获取无结构体资源的表结构
+func GetContextTable(c *gin.Context) (*TableSchema, bool) {
+	schema, ok := GetContextAny(c, "tableSchema")
+	if !ok {
+		return nil, false
+	}
+	return schema.(*TableSchema), true
+}
+
+// tableContext 获取表接口需要的数据库和表结构，read 为 true 时按主从路由选择查询使用的数据库
+func tableContext(c *gin.Context, read bool) (*gom.DB, *TableSchema, bool) {
+	db, ok := GetContextDatabase(c)
+	if read {
+		db, ok = GetContextReadDatabase(c)
+	}
+	if !ok {
+		RenderErr2(c, 500, "can't find database")
+		return nil, nil, false
+	}
+	schema, ok := GetContextTable(c)
+	if !ok {
+		RenderErr2(c, 500, "can't find table schema")
+		return nil, nil, false
+	}
+	return db, schema, true
+}
+
+// tableReadColumns 按查询列和字段权限确定要读取的列
+func tableReadColumns(c *gin.Context, schema *TableSchema) ([]string, error) {
+	cols := getSelectColumns(c)
+	if len(cols) == 0 {
+		cols = schema.ColumnNames()
+	}
+	return readableColumns(c, nil, cols)
+}
+
+// tableWriteFields 按写入白名单和字段权限过滤要写入的数据，并加密配置的列
+func tableWriteFields(c *gin.Context, row map[string]any) (map[string]any, error) {
+	allowed := getSelectColumns(c)
+	opts, _ := GetContextOptions(c)
+	cols := make([]string, 0, len(row))
+	for col := range row {
+		if len(allowed) == 0 || containsString(allowed, col) || (opts != nil && opts.Parent != nil && col == opts.Parent.ForeignKey) {
+			cols = append(cols, col)
+		}
+	}
+	if len(cols) == 0 {
+		return nil, errors.New("no writable fields in request body")
+	}
+	cols, er := writableColumns(c, nil, cols)
+	if er != nil {
+		return nil, er
+	}
+	fields := make(map[string]any, len(cols))
+	for _, col := range cols {
+		fields[col] = row[col]
+	}
+	if er := encryptFields(opts, fields); er != nil {
+		return nil, er
+	}
+	return fields, nil
+}
+
+// renderTableRows 转换数据库返回的值，解密并脱敏
+func renderTableRows(c *gin.Context, rows []map[string]any) error {
+	for _, row := range rows {
+		for k, v := range row {
+			row[k] = normalizeValue(v)
+		}
+	}
+	if er := DecryptData(c, rows); er != nil {
+		return er
+	}
+	return MaskData(c, rows)
+}
+
+// QueryTableList 无结构体资源的分页列表查询
+func QueryTableList() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db, schema, ok := tableContext(c, true)
+		if !ok {
+			return
+		}
+		cols, er := tableReadColumns(c, schema)
+		if er != nil {
+			RenderErr2(c, 500, er.Error())
+			return
+		}
+		cond, hasCond := getScopedCondition(c)
+		pageNum, pageSize := getContextPageNumber(c), getContextPageSize(c)
+		var result *gom.PageInfo
+		er = readTransaction(c, db, func(tx *gom.Chain) (er error) {
+			chain := tx.Table(schema.Info.TableName).Fields(cols...)
+			if hasCond {
+				chain = chain.Where2(cond)
+			}
+			if schema.PrimaryKey != "" {
+				chain = chain.OrderBy(schema.PrimaryKey)
+			}
+			result, er = chain.Page(pageNum, pageSize).PageInfo()
+			return er
+		})
+		if er != nil {
+			renderDBError(c, 500, er)
+			return
+		}
+		rows, _ := result.List.([]map[string]interface{})
+		if rows == nil {
+			rows = make([]map[string]interface{}, 0)
+		}
+		if er := renderTableRows(c, rows); er != nil {
+			RenderErr2(c, 403, er.Error())
+			return
+		}
+		result.List = rows
+		RenderOk(c, result)
+	}
+}
+
+// QueryTableSingle 无结构体资源的详情查询
+func QueryTableSingle() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db, schema, ok := tableContext(c, true)
+		if !ok {
+			return
+		}
+		cols, er := tableReadColumns(c, schema)
+		if er != nil {
+			RenderErr2(c, 500, er.Error())
+			return
+		}
+		cond, hasCond := getScopedCondition(c)
+		var result *define.Result
+		if er := readTransaction(c, db, func(tx *gom.Chain) error {
+			chain := tx.Table(schema.Info.TableName).Fields(cols...)
+			if hasCond {
+				chain = chain.Where2(cond)
+			}
+			result = chain.Limit(1).List()
+			return nil
+		}); er != nil {
+			renderDBError(c, 500, er)
+			return
+		}
+		if result.Error != nil {
+			renderDBError(c, 500, result.Error)
+			return
+		}
+		if len(result.Data) == 0 {
+			RenderOk(c, nil)
+			return
+		}
+		if er := setContextETag(c, result.Data[0]); er != nil {
+			RenderErr2(c, 500, er.Error())
+			return
+		}
+		if er := renderTableRows(c, result.Data[:1]); er != nil {
+			RenderErr2(c, 403, er.Error())
+			return
+		}
+		RenderOk(c, result.Data[0])
+	}
+}
+
+// DoTableInsert 无结构体资源的新增
+func DoTableInsert() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db, schema, ok := tableContext(c, false)
+		if !ok {
+			return
+		}
+		row, er := schema.bindRow(c, true)
+		if er != nil {
+			RenderErr2(c, 400, er.Error())
+			return
+		}
+		fields, er := tableWriteFields(c, row)
+		if er != nil {
+			RenderErr2(c, 400, er.Error())
+			return
+		}
+		table := schema.Info.TableName
+		result := runMutation(c, db, table, schema.PrimaryKey, ChangeInsert, nil, fields[schema.PrimaryKey], func(chain *gom.Chain) *define.Result {
+			return chain.Table(table).Values(fields).Save()
+		})
+		if result.Error != nil {
+			renderDBError(c, 500, result.Error)
+			return
+		}
+		RenderOk(c, result)
+	}
+}
+
+// DoTableUpdate 无结构体资源的更新，只更新请求体中出现的列，主键来自请求体或 REST 路径
+func DoTableUpdate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db, schema, ok := tableContext(c, false)
+		if !ok {
+			return
+		}
+		row, er := schema.bindRow(c, false)
+		if er != nil {
+			RenderErr2(c, 400, er.Error())
+			return
+		}
+		id, ok := row[schema.PrimaryKey]
+		if key, has := GetContextAny(c, "restKey"); has {
+			id, ok = key, true
+		}
+		if !ok || id == nil {
+			RenderErr2(c, 400, schema.PrimaryKey+" could not be empty")
+			return
+		}
+		delete(row, schema.PrimaryKey)
+		if len(row) == 0 {
+			RenderErr2(c, 400, "no fields to update")
+			return
+		}
+		fields, er := tableWriteFields(c, row)
+		if er != nil {
+			RenderErr2(c, 400, er.Error())
+			return
+		}
+		table := schema.Info.TableName
+		cond := scopeCondition(c, define.Eq(schema.PrimaryKey, id))
+		result := runMutation(c, db, table, schema.PrimaryKey, ChangeUpdate, cond, nil, func(chain *gom.Chain) *define.Result {
+			return chain.Table(table).Where2(cond).Update(fields)
+		})
+		if result.Error != nil {
+			renderDBError(c, 500, result.Error)
+			return
+		}
+		RenderOk(c, result)
+	}
+}
+
+// DoTableDelete 无结构体资源的删除，必须带条件
+func DoTableDelete() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db, schema, ok := tableContext(c, false)
+		if !ok {
+			return
+		}
+		cond, ok := getContextCondition(c)
+		if !ok || cond == nil {
+			RenderErr2(c, 400, "delete condition could not be empty")
+			return
+		}
+		cond = scopeCondition(c, cond)
+		table := schema.Info.TableName
+		result := runMutation(c, db, table, schema.PrimaryKey, ChangeDelete, cond, nil, func(chain *gom.Chain) *define.Result {
+			return chain.Table(table).Where2(cond).Delete()
+		})
+		if result.Error != nil {
+			renderDBError(c, 500, result.Error)
+			return
+		}
+		RenderOk(c, result)
+	}
+}
+
+// DoTableInfo 输出无结构体资源的表结构
+func DoTableInfo() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		_, schema, ok := tableContext(c, false)
+		if !ok {
+			return
+		}
+		RenderOk(c, schema.Info)
+	}
+}
+
+// NewTableCrud 只按表名生成增删改查接口，数据以 map 表示，列类型、可空和主键来自数据库元数据
+// 查询条件参数按列类型生成，新增和更新按列信息校验；没有主键的表(如视图)只开放 list、detail、struct
+func NewTableCrud(prefix string, db *gom.DB, table string, options ...Option) (ICrud, error) {
+	schema, er := NewTableSchema(db, table)
+	if er != nil {
+		return nil, er
+	}
+	return newTableCrud(prefix, db, schema, tableColumns{}, options...)
+}
+
+// tableColumns 无结构体资源各接口使用的列和查询条件，为空时按表结构生成
+type tableColumns struct {
+	query      []string         // 列表查询的列
+	detail     []string         // 详情查询的列
+	insert     []string         // 新增时可写入的列
+	update     []string         // 更新时可写入的列
+	conditions []ConditionParam // 列表和详情的查询条件参数
+}
+
+func newTableCrud(prefix string, db *gom.DB, schema *TableSchema, cols tableColumns, options ...Option) (ICrud, error) {
+	table := schema.Info.TableName
+	if prefix == "" {
+		prefix = table
+	}
+	opts := NewOptions(nil, options...)
+	if opts.Resource == "" {
+		opts.Resource = prefix
+	}
+	opts.db = db
+	if er := opts.validate(); er != nil {
+		return nil, er
+	}
+	router := opts.resolveRouter(db)
+	opts.detailColumns = cols.detail
+	if schema.PrimaryKey == "" && opts.Operations == nil {
+		opts.Operations = []DefaultRoutePath{PathList, PathDetail, PathTableStruct}
+	}
+
+	properties := markMaskedProperties(opts, schema.ApiProperties())
+	params := cols.conditions
+	if params == nil {
+		params = schema.ConditionParams()
+	}
+	keyParams := make([]ConditionParam, 0)
+	if schema.PrimaryKey != "" {
+		keyParams = deleteConditionParams(conditionParamsOf(schema.PrimaryKey, schema.Columns[schema.PrimaryKey].DataType))
+	}
+	insertCols, updateCols := cols.insert, cols.update
+	if insertCols == nil {
+		insertCols = schema.writableColumnNames(true)
+	}
+	if updateCols == nil {
+		updateCols = schema.writableColumnNames(false)
+	}
+	pick := func(cols []string) []ApiProperty {
+		if len(cols) == 0 {
+			return properties
+		}
+		result := make([]ApiProperty, 0, len(cols))
+		for _, property := range properties {
+			if containsString(cols, property.Name) {
+				result = append(result, property)
+			}
+		}
+		return result
+	}
+	bodyProperties := func(cols []string, required bool) []ApiProperty {
+		result := make([]ApiProperty, 0, len(cols))
+		for _, property := range properties {
+			if containsString(cols, property.Name) {
+				property.Location = "body"
+				property.Required = required && property.Required
+				result = append(result, property)
+			}
+		}
+		return result
+	}
+	updateParameters := bodyProperties(updateCols, false)
+	if schema.PrimaryKey != "" {
+		updateParameters = append([]ApiProperty{{Name: schema.PrimaryKey, Type: apiTypeOf(schema.Columns[schema.PrimaryKey].DataType), Required: true, Description: "主键", Location: "body"}}, updateParameters...)
+	}
+
+	handlers := []RouteHandler{
+		GetRouteHandler(string(PathList), "GET", table+"列表查询", "获取"+table+"分页列表",
+			generateApiPropertys(params, "query", false),
+			generateListResponse(table, pick(cols.query)),
+			SetContextDatabase(db, router), SetContextOptions(opts), SetContextTable(schema), DoNothingFunc,
+			SetConditionParamAsCnd(params), SetColumns(cols.query), DefaultGenPageFromRstQuery, DoNothingFunc, QueryTableList()),
+		GetRouteHandler(string(PathDetail), "GET", table+"详情查询", "获取单个"+table+"详情",
+			generateApiPropertys(params, "query", false),
+			generateDetailResponse(table, pick(cols.detail)),
+			SetContextDatabase(db, router), SetContextOptions(opts), SetContextTable(schema), DoNothingFunc,
+			SetConditionParamAsCnd(params), SetColumns(cols.detail), DoNothingFunc, QueryTableSingle()),
+		GetRouteHandler(string(PathAdd), "POST", table+"新增", "新增"+table+"，按表结构校验列的类型、长度和非空",
+			bodyProperties(insertCols, true),
+			generateInsertResponse(table),
+			SetContextDatabase(db, router), SetContextOptions(opts), SetContextTable(schema), DoNothingFunc,
+			SetColumns(insertCols), DoNothingFunc, DoTableInsert()),
+		GetRouteHandler(string(PathUpdate), "POST", table+"更新", "按主键更新"+table+"，只更新请求体中出现的列",
+			updateParameters,
+			generateUpdateResponse(table),
+			SetContextDatabase(db, router), SetContextOptions(opts), SetContextTable(schema), DoNothingFunc,
+			SetColumns(updateCols), DoNothingFunc, DoTableUpdate()),
+		GetRouteHandler(string(PathDelete), "POST", table+"删除", "删除"+table,
+			generateApiPropertys(keyParams, "query", false),
+			generateDeleteResponse(table),
+			SetContextDatabase(db, router), SetContextOptions(opts), SetContextTable(schema), DoNothingFunc,
+			SetConditionParamAsCnd(keyParams), DoNothingFunc, DoTableDelete()),
+		GetRouteHandler(string(PathTableStruct), "GET", table+"表结构", "获取"+table+"表结构",
+			generateTableStructParameters(),
+			generateTableStructResponse(table),
+			SetContextDatabase(db, router), SetContextOptions(opts), SetContextTable(schema), DoNothingFunc, DoTableInfo()),
+	}
+	return buildResource(prefix, opts, handlers)
+}
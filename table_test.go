@@ -0,0 +1,137 @@
+package crud
+
+import (
+	"database/sql/driver"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4/define"
+	"github.com/stretchr/testify/assert"
+)
+
+func testTableInfo(name string, withKey bool) *define.TableInfo {
+	return &define.TableInfo{
+		TableName: name,
+		Columns: []define.ColumnInfo{
+			{Name: "id", DataType: reflect.TypeOf(int64(0)), IsPrimaryKey: withKey, IsAutoIncrement: withKey},
+			{Name: "title", DataType: reflect.TypeOf(""), Length: 8},
+			{Name: "score", DataType: reflect.TypeOf(float64(0)), IsNullable: true},
+			{Name: "published_at", DataType: reflect.TypeOf(time.Time{}), IsNullable: true},
+		},
+	}
+}
+
+func TestTableBindRow(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	schema, er := newTableSchema(testTableInfo("notes", true))
+	assert.NoError(t, er)
+	assert.Equal(t, "id", schema.PrimaryKey)
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/notes/add", strings.NewReader(`{"title":"hello","score":"9.5","published_at":"2024-01-02"}`))
+	row, er := schema.bindRow(c, true)
+	assert.NoError(t, er)
+	assert.Equal(t, "hello", row["title"])
+	assert.Equal(t, 9.5, row["score"])
+	assert.IsType(t, time.Time{}, row["published_at"])
+
+	// 所有错误一起返回
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/notes/add", strings.NewReader(`{"title":"too long title","score":true,"color":"red"}`))
+	_, er = schema.bindRow(c, true)
+	assert.EqualError(t, er, "column [score] expects number; column [title] exceeds max length 8; unknown column [color]")
+
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/notes/add", strings.NewReader(`{"score":1}`))
+	_, er = schema.bindRow(c, true)
+	assert.EqualError(t, er, "column [title] is required")
+}
+
+func TestTableCrudRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	schema, _ := newTableSchema(testTableInfo("notes", true))
+	notes, er := newTableCrud("", nil, schema, tableColumns{})
+	assert.NoError(t, er)
+	list, er := notes.GetHandler(string(PathList))
+	assert.NoError(t, er)
+	names := make([]string, 0)
+	for _, param := range list.Parameters {
+		names = append(names, param.Name)
+	}
+	assert.Contains(t, names, "scoreGe")
+	assert.Contains(t, names, "titleLike")
+	assert.Contains(t, names, "published_atLt")
+
+	// 没有主键的表只开放只读接口
+	view, _ := newTableSchema(testTableInfo("note_view", false))
+	readonly, er := newTableCrud("", nil, view, tableColumns{})
+	assert.NoError(t, er)
+	r := gin.New()
+	assert.NoError(t, readonly.Register(r.Group("/api")))
+	assert.Len(t, r.Routes(), 3)
+}
+
+func TestTableDeleteOnlyByKey(t *testing.T) {
+	schema, _ := newTableSchema(testTableInfo("notes", true))
+	notes, er := newTableCrud("", nil, schema, tableColumns{})
+	assert.NoError(t, er)
+	del, er := notes.GetHandler(string(PathDelete))
+	assert.NoError(t, er)
+	names := make([]string, 0)
+	for _, param := range del.Parameters {
+		names = append(names, param.Name)
+	}
+	assert.Equal(t, []string{"idEq", "idIn"}, names)
+}
+
+func TestTableWriteNeedsFieldsAndKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	statements := make([]string, 0)
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, int64, error) {
+		statements = append(statements, query)
+		return nil, nil, 0, nil
+	})
+	schema, _ := newTableSchema(testTableInfo("notes", true))
+	notes, er := newTableCrud("", db, schema, tableColumns{})
+	assert.NoError(t, er)
+	r := gin.New()
+	assert.NoError(t, notes.Register(r.Group("/api")))
+	update, _ := notes.GetHandler(string(PathUpdate))
+	del, _ := notes.GetHandler(string(PathDelete))
+
+	// 请求体只有主键时没有可更新的列
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(update.HttpMethod, "/api/notes/update", strings.NewReader(`{"id":1}`))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	assert.JSONEq(t, `{"code":400,"msg":"no fields to update","data":null}`, w.Body.String())
+
+	// 没有条件的删除是请求错误
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(del.HttpMethod, "/api/notes/delete", nil))
+	assert.JSONEq(t, `{"code":400,"msg":"delete condition could not be empty","data":null}`, w.Body.String())
+	assert.Empty(t, statements)
+}
+
+func TestInConditionFromQuery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	params := []ConditionParam{{QueryName: "idIn", ColName: "id"}, {QueryName: "titleNotIn", ColName: "title"}}
+	parse := func(query string) (*define.Condition, error) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/notes/list?"+query, nil)
+		cnd, _, er := MapToParamCondition(c, params)
+		return cnd, er
+	}
+	cnd, er := parse("idIn=1,%202,3")
+	assert.NoError(t, er)
+	assert.Equal(t, define.In("id", "1", "2", "3"), cnd)
+	cnd, er = parse("titleNotIn=a&titleNotIn=b,c")
+	assert.NoError(t, er)
+	assert.Equal(t, define.NotIn("title", "a", "b", "c"), cnd)
+	_, er = parse("idIn=,")
+	assert.EqualError(t, er, "condition [idIn] needs at least one value")
+}
@@ -0,0 +1,241 @@
+package crud
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4"
+	"github.com/kmlixh/gom/v4/define"
+)
+
+// HeaderTenantId 读取租户标识的请求头，配合 TenantFromHeader 使用
+const HeaderTenantId = "X-Tenant-Id"
+
+// TenantFunc 从请求中解析租户标识
+type TenantFunc func(c *gin.Context) (string, error)
+
+// TenantFromHeader 从请求头读取租户标识，请求头由客户端控制，只能用在网关已校验请求头与调用者租户一致的场景
+func TenantFromHeader(name string) TenantFunc {
+	return func(c *gin.Context) (string, error) {
+		tenant := c.GetHeader(name)
+		if tenant == "" {
+			return "", errors.New("tenant could not be empty")
+		}
+		return tenant, nil
+	}
+}
+
+// TenantFromContext 从 gin.Context 中读取鉴权中间件写入的租户标识
+func TenantFromContext(key string) TenantFunc {
+	return func(c *gin.Context) (string, error) {
+		tenant := c.GetString(key)
+		if tenant == "" {
+			return "", errors.New("tenant could not be empty")
+		}
+		return tenant, nil
+	}
+}
+
+// OpenTenantDSN 按租户生成 DSN 并打开数据库，opts 中的 MaxOpenConns 等限制每个租户的连接数
+func OpenTenantDSN(driverName string, dsn func(tenant string) (string, error), opts *define.DBOptions) func(tenant string) (*gom.DB, error) {
+	return func(tenant string) (*gom.DB, error) {
+		source, er := dsn(tenant)
+		if er != nil {
+			return nil, er
+		}
+		return gom.Open(driverName, source, opts)
+	}
+}
+
+// tenantDB 租户的数据库及其使用情况
+type tenantDB struct {
+	db       *gom.DB
+	err      error
+	ready    chan struct{}
+	refs     int
+	lastUsed time.Time
+}
+
+// DBResolver 按租户选择数据库，租户的数据库在第一次请求时打开
+// 打开的租户数量超过 MaxTenants 时关闭最久未使用且没有请求在使用的数据库
+type DBResolver struct {
+	Tenant     TenantFunc                            // 解析租户标识，必须设置，通常为 TenantFromContext 读取鉴权中间件写入的租户
+	Open       func(tenant string) (*gom.DB, error)  // 打开租户的数据库
+	Close      func(tenant string, db *gom.DB) error // 关闭租户的数据库，默认为 db.Close
+	MaxTenants int                                   // 同时打开的租户数据库数量上限，为 0 表示不限制
+
+	mu   sync.Mutex
+	pool map[string]*tenantDB
+}
+
+// NewDBResolver 创建租户数据库解析器，tenant 不能为空，否则使用该解析器的资源在创建时返回错误
+func NewDBResolver(tenant TenantFunc, open func(tenant string) (*gom.DB, error), maxTenants int) *DBResolver {
+	return &DBResolver{Tenant: tenant, Open: open, MaxTenants: maxTenants}
+}
+
+// Acquire 获取租户的数据库，使用完后必须调用 release
+func (r *DBResolver) Acquire(tenant string) (*gom.DB, func(), error) {
+	if r.Open == nil {
+		return nil, nil, errors.New("tenant database opener is nil")
+	}
+	r.mu.Lock()
+	if r.pool == nil {
+		r.pool = make(map[string]*tenantDB)
+	}
+	entry, ok := r.pool[tenant]
+	if !ok {
+		// 由第一个请求打开数据库，同一租户的其他请求等待打开完成
+		entry = &tenantDB{ready: make(chan struct{})}
+		r.pool[tenant] = entry
+	}
+	entry.refs++
+	r.mu.Unlock()
+
+	if !ok {
+		db, er := r.Open(tenant)
+		r.mu.Lock()
+		entry.db, entry.err = db, er
+		if er != nil {
+			delete(r.pool, tenant)
+		}
+		close(entry.ready)
+		r.mu.Unlock()
+	}
+	<-entry.ready
+	if entry.err != nil {
+		r.mu.Lock()
+		entry.refs--
+		r.mu.Unlock()
+		return nil, nil, entry.err
+	}
+
+	r.mu.Lock()
+	r.evictLocked()
+	r.mu.Unlock()
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			entry.refs--
+			entry.lastUsed = time.Now()
+			r.evictLocked()
+		})
+	}
+	return entry.db, release, nil
+}
+
+// evictLocked 关闭超出上限的空闲租户数据库，调用方需持有锁
+func (r *DBResolver) evictLocked() {
+	for r.MaxTenants > 0 && len(r.pool) > r.MaxTenants {
+		var oldest string
+		var victim *tenantDB
+		for tenant, entry := range r.pool {
+			if entry.refs > 0 || entry.db == nil {
+				continue
+			}
+			if victim == nil || entry.lastUsed.Before(victim.lastUsed) {
+				oldest, victim = tenant, entry
+			}
+		}
+		if victim == nil {
+			// 所有租户都在使用中，暂时超出上限，等请求结束后再关闭
+			return
+		}
+		delete(r.pool, oldest)
+		go r.closeDB(oldest, victim.db)
+	}
+}
+
+func (r *DBResolver) closeDB(tenant string, db *gom.DB) error {
+	if r.Close != nil {
+		return r.Close(tenant, db)
+	}
+	return db.Close()
+}
+
+// Opened 返回当前打开的租户数据库数量
+func (r *DBResolver) Opened() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.pool)
+}
+
+// CloseAll 关闭所有租户的数据库
+func (r *DBResolver) CloseAll() error {
+	r.mu.Lock()
+	pool := r.pool
+	r.pool = nil
+	r.mu.Unlock()
+	var errs []error
+	for tenant, entry := range pool {
+		<-entry.ready
+		if entry.db != nil {
+			if er := r.closeDB(tenant, entry.db); er != nil {
+				errs = append(errs, er)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Middleware 解析请求的租户并绑定其数据库，之后的 GetContextDatabase 都返回租户的数据库
+// 可以用在路由分组上，也可以通过 WithDBResolver 加入单个资源的处理链
+func (r *DBResolver) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := GetContextAny(c, "tenantDb"); ok {
+			return
+		}
+		if r.Tenant == nil {
+			// 不从请求头推断租户，避免调用者读取其他租户的数据
+			RenderErr2(c, 500, "tenant resolver has no Tenant func")
+			return
+		}
+		tenant, er := r.Tenant(c)
+		if er != nil {
+			RenderErr2(c, 400, er.Error())
+			return
+		}
+		db, release, er := r.Acquire(tenant)
+		if er != nil {
+			RenderErr2(c, 500, er.Error())
+			return
+		}
+		defer release()
+		SetContextAny("tenant", tenant)(c)
+		SetContextAny("tenantDb", db)(c)
+		c.Next()
+	}
+}
+
+// GetContextTenant 获取请求的租户标识
+func GetContextTenant(c *gin.Context) (string, bool) {
+	tenant, ok := GetContextAny(c, "tenant")
+	if !ok {
+		return "", false
+	}
+	return tenant.(string), true
+}
+
+// WithDBResolver 资源按租户选择数据库，解析器必须设置 Tenant
+func WithDBResolver(resolver *DBResolver) Option {
+	return func(o *Options) {
+		if resolver != nil && resolver.Tenant == nil {
+			o.addError(errors.New("tenant resolver has no Tenant func"))
+		}
+		o.Resolver = resolver
+	}
+}
+
+// resolveHandlers 在每个接口的处理链中加入租户数据库的解析
+func resolveHandlers(opts *Options, handlers []RouteHandler) {
+	if opts.Resolver == nil {
+		return
+	}
+	for idx := range handlers {
+		// 位于 SetContextDatabase、SetContextOptions 之后，在父资源校验等需要查询数据库的处理器之前
+		handlers[idx].Handlers = insertAfterContext(handlers[idx].Handlers, opts.Resolver.Middleware())
+	}
+}
@@ -0,0 +1,101 @@
+package crud
+
+import (
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDBResolverPool(t *testing.T) {
+	var mu sync.Mutex
+	opened := make(map[string]int)
+	closed := make(chan string, 4)
+	resolver := NewDBResolver(nil, func(tenant string) (*gom.DB, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		opened[tenant]++
+		return &gom.DB{}, nil
+	}, 2)
+	resolver.Close = func(tenant string, db *gom.DB) error {
+		closed <- tenant
+		return nil
+	}
+
+	a, releaseA, er := resolver.Acquire("a")
+	assert.NoError(t, er)
+	again, releaseAgain, _ := resolver.Acquire("a")
+	assert.Same(t, a, again)
+	releaseAgain()
+	_, releaseB, _ := resolver.Acquire("b")
+	releaseB()
+
+	// a 仍在使用中，超出上限时关闭最久未使用的 b
+	_, releaseC, _ := resolver.Acquire("c")
+	assert.Equal(t, "b", <-closed)
+	assert.Equal(t, 2, resolver.Opened())
+	releaseC()
+	releaseA()
+	assert.Equal(t, 1, opened["a"])
+
+	assert.NoError(t, resolver.CloseAll())
+	assert.Equal(t, 0, resolver.Opened())
+}
+
+func TestDBResolverMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tenantDb := &gom.DB{}
+	resolver := NewDBResolver(TenantFromContext("tenantId"), func(tenant string) (*gom.DB, error) {
+		return tenantDb, nil
+	}, 0)
+	auth := func(c *gin.Context) {
+		if tenant := c.GetHeader("X-Test-Token"); tenant != "" {
+			c.Set("tenantId", tenant)
+		}
+	}
+
+	var got *gom.DB
+	r := gin.New()
+	r.GET("/notes", SetContextDatabase(&gom.DB{}), auth, resolver.Middleware(), func(c *gin.Context) {
+		got, _ = GetContextDatabase(c)
+		tenant, _ := GetContextTenant(c)
+		RenderOk(c, tenant)
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/notes", nil)
+	req.Header.Set("X-Test-Token", "acme")
+	r.ServeHTTP(w, req)
+	assert.Same(t, tenantDb, got)
+	assert.JSONEq(t, `{"code":200,"msg":"ok","data":"acme"}`, w.Body.String())
+
+	// 请求头中的租户不会被采用
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/notes", nil)
+	req.Header.Set(HeaderTenantId, "other")
+	r.ServeHTTP(w, req)
+	assert.JSONEq(t, `{"code":400,"msg":"tenant could not be empty","data":null}`, w.Body.String())
+}
+
+func TestDBResolverRequiresTenant(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	resolver := NewDBResolver(nil, func(tenant string) (*gom.DB, error) {
+		return &gom.DB{}, nil
+	}, 0)
+	_, er := NewCrud2("tenant_orders", &Order{}, nil, []string{"id", "no"}, nil, nil, nil, nil, nil, nil, nil, nil, WithDBResolver(resolver))
+	assert.EqualError(t, er, "tenant resolver has no Tenant func")
+
+	r := gin.New()
+	r.GET("/notes", resolver.Middleware(), func(c *gin.Context) {
+		RenderOk(c, nil)
+	})
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/notes", nil)
+	req.Header.Set(HeaderTenantId, "acme")
+	r.ServeHTTP(w, req)
+	assert.JSONEq(t, `{"code":500,"msg":"tenant resolver has no Tenant func","data":null}`, w.Body.String())
+	assert.Equal(t, 0, resolver.Opened())
+}
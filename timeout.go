@@ -0,0 +1,152 @@
+package crud
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4"
+	"github.com/kmlixh/gom/v4/define"
+)
+
+const (
+	// CodeTimeout 查询超过资源或接口配置的超时时间
+	CodeTimeout = 504
+	// CodeCanceled 客户端在查询完成前断开了连接
+	CodeCanceled = 499
+)
+
+// WithTimeout 资源所有接口的超时时间，包括数据库查询和写入，watch 等长连接推送的接口除外
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *Options) {
+		o.Timeout = timeout
+	}
+}
+
+// WithOperationTimeout 单个接口的超时时间，优先于 WithTimeout，内置接口的超时时间同样作用于对应到它的接口，如 update 的超时时间作用于 patch
+func WithOperationTimeout(op DefaultRoutePath, timeout time.Duration) Option {
+	return func(o *Options) {
+		if o.Timeouts == nil {
+			o.Timeouts = make(map[DefaultRoutePath]time.Duration)
+		}
+		o.Timeouts[op] = timeout
+	}
+}
+
+// streamingPaths 长连接推送的接口，不继承 list 和资源的超时时间
+var streamingPaths = []DefaultRoutePath{PathWatch, PathWatchWs}
+
+// operationTimeout 接口生效的超时时间，为 0 表示不限制
+func (o *Options) operationTimeout(handler RouteHandler) time.Duration {
+	if timeout, ok := o.Timeouts[DefaultRoutePath(handler.Path)]; ok {
+		return timeout
+	}
+	if containsString(routePathNames(streamingPaths), handler.Path) {
+		// 推送在超时后会被中断，只使用为其单独设置的超时时间
+		return 0
+	}
+	if timeout, ok := o.Timeouts[operationOf(handler)]; ok {
+		return timeout
+	}
+	return o.Timeout
+}
+
+// RequestTimeout 为请求的 context 设置超时时间，之后的数据库操作在超时后终止
+func RequestTimeout(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// applyTimeouts 将超时处理加入配置了超时时间的接口
+func applyTimeouts(opts *Options, handlers []RouteHandler) {
+	for idx := range handlers {
+		timeout := opts.operationTimeout(handlers[idx])
+		if timeout <= 0 {
+			continue
+		}
+		// 位于 SetContextDatabase、SetContextOptions 之后，超时时间覆盖鉴权、父资源校验等所有查询
+		handlers[idx].Handlers = insertAfterContext(handlers[idx].Handlers, RequestTimeout(timeout))
+	}
+}
+
+// requestContext 请求的 context，没有请求时为 context.Background
+func requestContext(c *gin.Context) context.Context {
+	if c.Request == nil {
+		return context.Background()
+	}
+	return c.Request.Context()
+}
+
+// readTransaction 以请求的 context 执行只读的查询，客户端断开或超时后驱动中断正在执行的查询
+// 请求设置了超时时间时在只读事务中执行；查询需要使用 fn 的 tx 生成
+func readTransaction(c *gin.Context, db *gom.DB, fn func(tx *gom.Chain) error) error {
+	ctx := requestContext(c)
+	if er := ctx.Err(); er != nil {
+		return er
+	}
+	bound, release, er := requestDB(ctx, db)
+	if er != nil {
+		return er
+	}
+	defer release()
+	guarded := func(tx *gom.Chain) error {
+		if er := fn(tx); er != nil {
+			return er
+		}
+		return ctx.Err()
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		return guarded(bound.Chain())
+	}
+	return bound.Chain().TransactionWithOptions(define.TransactionOptions{ReadOnly: true}, guarded)
+}
+
+// transaction 以请求的 context 在事务中执行写操作，客户端断开或超时后语句中断、事务回滚，请求结束前不会提交
+func transaction(c *gin.Context, db *gom.DB, fn func(tx *gom.Chain) error) error {
+	ctx := requestContext(c)
+	if er := ctx.Err(); er != nil {
+		return er
+	}
+	bound, release, er := requestDB(ctx, db)
+	if er != nil {
+		return er
+	}
+	defer release()
+	return bound.Chain().Transaction(func(tx *gom.Chain) error {
+		if er := fn(tx); er != nil {
+			return er
+		}
+		return ctx.Err()
+	})
+}
+
+// contextErrorCode 请求超时或取消时返回对应的响应码
+func contextErrorCode(c *gin.Context, er error) (int, string, bool) {
+	ctxErr := requestContext(c).Err()
+	switch {
+	case errors.Is(er, context.DeadlineExceeded), errors.Is(ctxErr, context.DeadlineExceeded):
+		return CodeTimeout, "request timeout", true
+	case errors.Is(er, context.Canceled), errors.Is(ctxErr, context.Canceled):
+		return CodeCanceled, "request canceled", true
+	}
+	return 0, "", false
+}
+
+// renderDBError 输出数据库操作的错误，超时和取消使用单独的响应码，If-Match 不相符时返回 412
+func renderDBError(c *gin.Context, code int, er error) {
+	if errors.Is(er, ErrPreconditionFailed) {
+		c.AbortWithStatusJSON(http.StatusPreconditionFailed, CodeMsg{Code: http.StatusPreconditionFailed, Msg: er.Error()})
+		return
+	}
+	if ctxCode, msg, ok := contextErrorCode(c, er); ok {
+		RenderErr2(c, ctxCode, msg)
+		return
+	}
+	RenderErr2(c, code, er.Error())
+}
@@ -0,0 +1,117 @@
+package crud
+
+import (
+	"context"
+	"database/sql/driver"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4"
+	"github.com/kmlixh/gom/v4/define"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOperationTimeout(t *testing.T) {
+	opts := &Options{}
+	WithTimeout(time.Second)(opts)
+	WithOperationTimeout(PathUpdate, 3*time.Second)(opts)
+	assert.Equal(t, time.Second, opts.operationTimeout(RouteHandler{Path: string(PathList)}))
+	assert.Equal(t, 3*time.Second, opts.operationTimeout(RouteHandler{Path: string(PathUpdate)}))
+	assert.Equal(t, 3*time.Second, opts.operationTimeout(RouteHandler{Path: string(PathPatch), Operation: PathUpdate}))
+	assert.Equal(t, 3*time.Second, opts.operationTimeout(RouteHandler{Path: string(PathRollback), Operation: PathUpdate}))
+
+	handlers := []RouteHandler{
+		{Path: string(PathList), Handlers: []gin.HandlerFunc{nil, nil, nil}},
+		{Path: string(PathWatch), Operation: PathList, Handlers: []gin.HandlerFunc{nil, nil, nil}},
+		{Path: string(PathWatchWs), Operation: PathList, Handlers: []gin.HandlerFunc{nil, nil, nil}},
+	}
+	applyTimeouts(opts, handlers)
+	assert.Len(t, handlers[0].Handlers, 4)
+	// 推送接口不继承超时时间
+	assert.Len(t, handlers[1].Handlers, 3)
+	assert.Len(t, handlers[2].Handlers, 3)
+}
+
+func TestRequestTimeout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	statements := make([]string, 0)
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, int64, error) {
+		statements = append(statements, query)
+		if query == "SELECT 1" {
+			time.Sleep(50 * time.Millisecond)
+		}
+		return []string{"id"}, [][]driver.Value{{int64(1)}}, 0, nil
+	})
+	r.GET("/slow", RequestTimeout(10*time.Millisecond), func(c *gin.Context) {
+		er := readTransaction(c, db, func(tx *gom.Chain) error {
+			return tx.RawQuery("SELECT 1").Error
+		})
+		renderDBError(c, 500, er)
+	})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/slow", nil))
+	assert.JSONEq(t, `{"code":504,"msg":"request timeout","data":null}`, w.Body.String())
+	// 查询在只读事务中执行，超时后事务回滚
+	assert.Equal(t, []string{"START TRANSACTION READ ONLY", "SELECT 1"}, statements)
+
+	// 客户端断开后不再执行写操作
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.Request = httptest.NewRequest("POST", "/notes/add", nil).WithContext(ctx)
+	executed := false
+	result := runMutation(c, nil, "notes", "id", ChangeInsert, nil, nil, func(chain *gom.Chain) *define.Result {
+		executed = true
+		return &define.Result{}
+	})
+	assert.False(t, executed)
+	code, msg, ok := contextErrorCode(c, result.Error)
+	assert.True(t, ok)
+	assert.Equal(t, CodeCanceled, code)
+	assert.Equal(t, "request canceled", msg)
+}
+
+func TestCancelInterruptsQuery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	started, release := make(chan string, 1), make(chan struct{})
+	defer close(release)
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, int64, error) {
+		if query == "START TRANSACTION READ ONLY" {
+			return nil, nil, 0, nil
+		}
+		started <- query
+		<-release
+		return []string{"id"}, nil, 1, nil
+	})
+	run := func(name string, fn func(c *gin.Context) error) {
+		ctx, cancel := context.WithCancel(context.Background())
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/orders/list", nil).WithContext(ctx)
+		done := make(chan error, 1)
+		go func() {
+			done <- fn(c)
+		}()
+		<-started
+		// 客户端在查询执行中断开
+		cancel()
+		select {
+		case er := <-done:
+			assert.ErrorIs(t, er, context.Canceled, name)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: running statement was not interrupted", name)
+		}
+	}
+	run("read", func(c *gin.Context) error {
+		return readTransaction(c, db, func(tx *gom.Chain) error {
+			return tx.RawQuery("SELECT SLEEP(60)").Error
+		})
+	})
+	run("write", func(c *gin.Context) error {
+		return transaction(c, db, func(tx *gom.Chain) error {
+			return tx.RawExecute("UPDATE orders SET no = ?", "A1").Error
+		})
+	})
+}
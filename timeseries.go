@@ -0,0 +1,297 @@
+package crud
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4"
+	"github.com/kmlixh/gom/v4/define"
+)
+
+// PathTimeseries 按时间分桶统计接口的名称
+const PathTimeseries DefaultRoutePath = "timeseries"
+
+// bucketLayout 分桶时间的格式，SQL 和 Go 两端按同样的格式生成桶的键
+const bucketLayout = "2006-01-02 15:04:05"
+
+// timeIntervals 支持的分桶粒度
+var timeIntervals = []string{"minute", "hour", "day", "week", "month", "year"}
+
+// errOffsetChanged MySQL 按固定偏移量转换时区，时间范围内偏移量发生变化时无法正确分桶，需要在变化处拆分范围
+var errOffsetChanged = errors.New("changes its UTC offset within the range, split the range at the change")
+
+// timeseriesQuery 解析后的分桶统计请求
+type timeseriesQuery struct {
+	Field    string
+	Interval string
+	Location *time.Location
+	From     time.Time
+	To       time.Time
+	Metrics  []aggregateMetric
+}
+
+// truncate 将时间截断到所在桶的起点，周从周一开始
+func (q *timeseriesQuery) truncate(t time.Time) time.Time {
+	t = t.In(q.Location)
+	y, m, d := t.Date()
+	switch q.Interval {
+	case "minute":
+		return time.Date(y, m, d, t.Hour(), t.Minute(), 0, 0, q.Location)
+	case "hour":
+		return time.Date(y, m, d, t.Hour(), 0, 0, 0, q.Location)
+	case "week":
+		return time.Date(y, m, d-(int(t.Weekday())+6)%7, 0, 0, 0, 0, q.Location)
+	case "month":
+		return time.Date(y, m, 1, 0, 0, 0, 0, q.Location)
+	case "year":
+		return time.Date(y, 1, 1, 0, 0, 0, 0, q.Location)
+	}
+	return time.Date(y, m, d, 0, 0, 0, 0, q.Location)
+}
+
+// next 下一个桶的起点，n 为负数时向前
+func (q *timeseriesQuery) next(t time.Time, n int) time.Time {
+	switch q.Interval {
+	case "minute":
+		return t.Add(time.Duration(n) * time.Minute)
+	case "hour":
+		return t.Add(time.Duration(n) * time.Hour)
+	case "week":
+		return t.AddDate(0, 0, 7*n)
+	case "month":
+		return t.AddDate(0, n, 0)
+	case "year":
+		return t.AddDate(n, 0, 0)
+	}
+	return t.AddDate(0, 0, n)
+}
+
+// buckets 时间范围内所有桶的起点
+func (q *timeseriesQuery) buckets(max int) ([]time.Time, error) {
+	buckets := make([]time.Time, 0)
+	for t := q.truncate(q.From); t.Before(q.To); t = q.next(t, 1) {
+		if len(buckets) >= max {
+			return nil, fmt.Errorf("time range exceeds %d buckets", max)
+		}
+		buckets = append(buckets, t)
+	}
+	return buckets, nil
+}
+
+// parseTimeParam 解析时间参数，支持 RFC3339、2006-01-02 15:04:05 和 2006-01-02，后两种按 loc 解析
+func parseTimeParam(value string, loc *time.Location) (time.Time, error) {
+	if t, er := time.Parse(time.RFC3339, value); er == nil {
+		return t, nil
+	}
+	for _, layout := range []string{bucketLayout, "2006-01-02"} {
+		if t, er := time.ParseInLocation(layout, value, loc); er == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("time [%s] should be RFC3339 or 2006-01-02", value)
+}
+
+// fixedOffset 时间范围内时区的 UTC 偏移量，范围内偏移量发生变化（如夏令时切换）时返回 false
+func (q *timeseriesQuery) fixedOffset() (string, bool) {
+	from := q.From.In(q.Location)
+	_, offset := from.Zone()
+	for t := from; ; {
+		_, end := t.ZoneBounds()
+		if end.IsZero() || !end.Before(q.To) {
+			return from.Format("-07:00"), true
+		}
+		if _, next := end.Zone(); next != offset {
+			return "", false
+		}
+		t = end
+	}
+}
+
+// parseTimeseriesQuery 按白名单和调用者可读的列解析分桶统计的请求参数，列可以使用 json 名称，没有指定范围时返回截至当前的 30 个桶
+func parseTimeseriesQuery(c *gin.Context, opts *Options) (*timeseriesQuery, error) {
+	readable, er := statColumns(c)
+	if er != nil {
+		return nil, er
+	}
+	query := &timeseriesQuery{Field: c.Query("field"), Interval: c.DefaultQuery("interval", "day"), Location: time.UTC}
+	if query.Field == "" {
+		return nil, errors.New("field could not be empty")
+	}
+	col, ok := allowedColumn(opts, opts.Aggregate.TimeFields, readable, query.Field)
+	if !ok {
+		return nil, fmt.Errorf("column [%s] could not be bucketed", query.Field)
+	}
+	query.Field = col
+	if !containsString(timeIntervals, query.Interval) {
+		return nil, fmt.Errorf("interval [%s] should be one of %s", query.Interval, strings.Join(timeIntervals, ","))
+	}
+	if tz := c.Query("tz"); tz != "" {
+		loc, er := time.LoadLocation(tz)
+		if er != nil {
+			return nil, fmt.Errorf("unknown time zone [%s]", tz)
+		}
+		query.Location = loc
+	}
+
+	metrics := splitParam(c.Query("metric"))
+	if len(metrics) == 0 {
+		metrics = []string{"count"}
+	}
+	for _, item := range metrics {
+		metric, er := parseAggregateMetric(c, item, opts, readable)
+		if er != nil {
+			return nil, er
+		}
+		query.Metrics = append(query.Metrics, metric)
+	}
+
+	query.To = time.Now()
+	if to := c.Query("to"); to != "" {
+		t, er := parseTimeParam(to, query.Location)
+		if er != nil {
+			return nil, er
+		}
+		query.To = t
+	}
+	query.From = query.next(query.truncate(query.To), -29)
+	if from := c.Query("from"); from != "" {
+		t, er := parseTimeParam(from, query.Location)
+		if er != nil {
+			return nil, er
+		}
+		query.From = t
+	}
+	if !query.From.Before(query.To) {
+		return nil, errors.New("from should be before to")
+	}
+	return query, nil
+}
+
+// bucketExpression 按数据库类型生成截断时间的 SQL，结果为 bucketLayout 格式的本地时间
+// 列中的时间按 UTC 存储；MySQL 按固定的 UTC 偏移量转换时区，不依赖时区表，范围内偏移量发生变化时返回错误
+func (q *timeseriesQuery) bucketExpression(db *gom.DB) (string, error) {
+	col := quoteIdentifier(db, q.Field)
+	switch db.Factory.GetType() {
+	case "mysql":
+		offset, ok := q.fixedOffset()
+		if !ok {
+			return "", fmt.Errorf("time zone [%s] %w", q.Location, errOffsetChanged)
+		}
+		local := "CONVERT_TZ(" + col + ", '+00:00', '" + offset + "')"
+		formats := map[string]string{
+			"minute": "DATE_FORMAT(" + local + ", '%Y-%m-%d %H:%i:00')",
+			"hour":   "DATE_FORMAT(" + local + ", '%Y-%m-%d %H:00:00')",
+			"day":    "DATE_FORMAT(" + local + ", '%Y-%m-%d 00:00:00')",
+			"week":   "DATE_FORMAT(DATE_SUB(" + local + ", INTERVAL WEEKDAY(" + local + ") DAY), '%Y-%m-%d 00:00:00')",
+			"month":  "DATE_FORMAT(" + local + ", '%Y-%m-01 00:00:00')",
+			"year":   "DATE_FORMAT(" + local + ", '%Y-01-01 00:00:00')",
+		}
+		return formats[q.Interval], nil
+	case "postgres":
+		local := "(" + col + " AT TIME ZONE 'UTC' AT TIME ZONE '" + strings.ReplaceAll(q.Location.String(), "'", "''") + "')"
+		return "to_char(date_trunc('" + q.Interval + "', " + local + "), 'YYYY-MM-DD HH24:MI:SS')", nil
+	}
+	return "", fmt.Errorf("timeseries is not supported on [%s]", db.Factory.GetType())
+}
+
+// build 生成分桶统计的 SQL，查询条件加上时间范围
+func (q *timeseriesQuery) build(db *gom.DB, table string, cond *define.Condition) (string, []any, error) {
+	bucket, er := q.bucketExpression(db)
+	if er != nil {
+		return "", nil, er
+	}
+	fields := []string{bucket + " AS " + quoteIdentifier(db, "bucket")}
+	for _, metric := range q.Metrics {
+		fields = append(fields, metric.expression(db)+" AS "+quoteIdentifier(db, metric.Alias))
+	}
+	ranged := define.Ge(q.Field, q.From.UTC()).And(define.Lt(q.Field, q.To.UTC()))
+	if cond != nil {
+		ranged = &define.Condition{IsSubGroup: true, SubConds: []*define.Condition{cond, ranged}}
+	}
+	sqlStr, args := db.Factory.BuildSelect(table, fields, []*define.Condition{ranged}, "", 0, 0)
+	return sqlStr + " GROUP BY " + bucket, args, nil
+}
+
+// fill 按桶的起点补齐没有数据的桶，count 和 sum 补 0，其余统计补 null
+func (q *timeseriesQuery) fill(buckets []time.Time, rows []map[string]any) []map[string]any {
+	found := make(map[string]map[string]any, len(rows))
+	for _, row := range rows {
+		if key, ok := normalizeValue(row["bucket"]).(string); ok {
+			found[key] = row
+		}
+	}
+	result := make([]map[string]any, 0, len(buckets))
+	for _, t := range buckets {
+		point := map[string]any{"bucket": t.Format(time.RFC3339)}
+		row, ok := found[t.Format(bucketLayout)]
+		for _, metric := range q.Metrics {
+			switch {
+			case ok:
+				point[metric.Alias] = numericValue(row[metric.Alias])
+			case metric.Func == "count" || metric.Func == "sum":
+				point[metric.Alias] = 0
+			default:
+				point[metric.Alias] = nil
+			}
+		}
+		result = append(result, point)
+	}
+	return result
+}
+
+// DoTimeseries 按时间分桶统计，查询条件来自列表接口的条件参数，返回补齐空桶后的序列
+func DoTimeseries() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db, ok := GetContextReadDatabase(c)
+		if !ok {
+			RenderErr2(c, 500, "can't find database")
+			return
+		}
+		opts, ok := GetContextOptions(c)
+		if !ok || opts.Aggregate == nil {
+			RenderErr2(c, 500, "aggregate is not configured")
+			return
+		}
+		table, ok := contextTableName(c)
+		if !ok {
+			RenderErr2(c, 500, "can't find data entity")
+			return
+		}
+		query, er := parseTimeseriesQuery(c, opts)
+		if er != nil {
+			RenderErr2(c, 400, er.Error())
+			return
+		}
+		buckets, er := query.buckets(opts.Aggregate.maxBuckets())
+		if er != nil {
+			RenderErr2(c, 400, er.Error())
+			return
+		}
+		cond, _ := getScopedCondition(c)
+		sqlStr, args, er := query.build(db, table, cond)
+		if errors.Is(er, errOffsetChanged) {
+			RenderErr2(c, 400, er.Error())
+			return
+		}
+		if er != nil {
+			RenderErr2(c, 500, er.Error())
+			return
+		}
+		var result *define.Result
+		if er := readTransaction(c, db, func(tx *gom.Chain) error {
+			result = tx.RawQuery(sqlStr, args...)
+			return nil
+		}); er != nil {
+			renderDBError(c, 500, er)
+			return
+		}
+		if result.Error != nil {
+			renderDBError(c, 500, result.Error)
+			return
+		}
+		RenderOk(c, query.fill(buckets, result.Data))
+	}
+}
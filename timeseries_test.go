@@ -0,0 +1,79 @@
+package crud
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4"
+	"github.com/kmlixh/gom/v4/factory/mysql"
+	"github.com/kmlixh/gom/v4/factory/postgres"
+	"github.com/stretchr/testify/assert"
+)
+
+type seriesOrder struct {
+	ID        int64     `json:"id" gom:"id,@,auto"`
+	Amount    float64   `json:"amount" gom:"amount"`
+	CreatedAt time.Time `json:"createdAt" gom:"created_at"`
+}
+
+func TestTimeseriesQuery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	resource := NewOptions(&seriesOrder{})
+	WithAggregate(AggregateOptions{Columns: []string{"amount"}, TimeFields: []string{"created_at"}, MaxBuckets: 10})(resource)
+	opts := resource.Aggregate
+	parse := func(query string) (*timeseriesQuery, error) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/orders/timeseries?"+query, nil)
+		SetContextOptions(resource)(c)
+		return parseTimeseriesQuery(c, resource)
+	}
+
+	q, er := parse("field=created_at&interval=day&tz=Asia/Shanghai&metric=count,sum:amount&from=2024-01-01&to=2024-01-04")
+	assert.NoError(t, er)
+	buckets, er := q.buckets(opts.maxBuckets())
+	assert.NoError(t, er)
+	assert.Len(t, buckets, 3)
+	assert.Equal(t, "2024-01-01T00:00:00+08:00", buckets[0].Format("2006-01-02T15:04:05Z07:00"))
+
+	// 没有数据的桶补齐
+	points := q.fill(buckets, []map[string]any{{"bucket": []byte("2024-01-02 00:00:00"), "count": int64(3), "sum_amount": []byte("12.50")}})
+	assert.Equal(t, map[string]any{"bucket": "2024-01-01T00:00:00+08:00", "count": 0, "sum_amount": 0}, points[0])
+	assert.Equal(t, map[string]any{"bucket": "2024-01-02T00:00:00+08:00", "count": int64(3), "sum_amount": 12.5}, points[1])
+
+	sqlStr, args, er := q.build(&gom.DB{Factory: &mysql.Factory{}}, "orders", nil)
+	assert.NoError(t, er)
+	assert.True(t, strings.HasPrefix(sqlStr, "SELECT DATE_FORMAT(CONVERT_TZ(`created_at`, '+00:00', '+08:00'), '%Y-%m-%d 00:00:00') AS `bucket`, COUNT(*) AS `count`, SUM(`amount`) AS `sum_amount` FROM `orders` WHERE "), sqlStr)
+	assert.True(t, strings.HasSuffix(sqlStr, " GROUP BY DATE_FORMAT(CONVERT_TZ(`created_at`, '+00:00', '+08:00'), '%Y-%m-%d 00:00:00')"), sqlStr)
+	assert.Len(t, args, 2)
+
+	sqlStr, _, er = q.build(&gom.DB{Factory: &postgres.Factory{}}, "orders", nil)
+	assert.NoError(t, er)
+	assert.Contains(t, sqlStr, `to_char(date_trunc('day', ("created_at" AT TIME ZONE 'UTC' AT TIME ZONE 'Asia/Shanghai')), 'YYYY-MM-DD HH24:MI:SS') AS "bucket"`)
+
+	// 列可以使用 json 名称
+	q, er = parse("field=createdAt&from=2024-01-01&to=2024-01-04")
+	assert.NoError(t, er)
+	assert.Equal(t, "created_at", q.Field)
+
+	// 范围跨过夏令时切换时 MySQL 无法按固定偏移量分桶，不跨过时使用范围内的偏移量
+	q, _ = parse("field=created_at&tz=America/New_York&from=2024-03-01&to=2024-03-20")
+	_, _, er = q.build(&gom.DB{Factory: &mysql.Factory{}}, "orders", nil)
+	assert.EqualError(t, er, "time zone [America/New_York] changes its UTC offset within the range, split the range at the change")
+	_, _, er = q.build(&gom.DB{Factory: &postgres.Factory{}}, "orders", nil)
+	assert.NoError(t, er)
+	q, _ = parse("field=created_at&tz=America/New_York&from=2024-03-11&to=2024-03-20")
+	sqlStr, _, er = q.build(&gom.DB{Factory: &mysql.Factory{}}, "orders", nil)
+	assert.NoError(t, er)
+	assert.Contains(t, sqlStr, "CONVERT_TZ(`created_at`, '+00:00', '-04:00')")
+
+	_, er = parse("field=updated_at")
+	assert.EqualError(t, er, "column [updated_at] could not be bucketed")
+	_, er = parse("field=created_at&interval=second")
+	assert.Error(t, er)
+	q, _ = parse("field=created_at&interval=hour&from=2024-01-01&to=2024-01-02")
+	_, er = q.buckets(opts.maxBuckets())
+	assert.EqualError(t, er, "time range exceeds 10 buckets")
+}
@@ -174,16 +174,60 @@ func CheckTokenGin(c *gin.Context) {
 		c.Abort()
 		return
 	}
-	userId, _, err := store.GetToken(token)
+	userId, userType, err := store.GetToken(token)
 	if err != nil || userId == "" {
 		RenderJson(c, 401, "unauthorized", nil)
 		c.Abort()
 		return
 	}
 	c.Set("userId", userId)
+	c.Set("userType", userType)
 	c.Next()
 }
 
+// GetContextUserId 获取当前请求的用户ID
+func GetContextUserId(c *gin.Context) string {
+	return c.GetString("userId")
+}
+
+// GetContextUserType 获取当前请求的用户类型
+func GetContextUserType(c *gin.Context) string {
+	return c.GetString("userType")
+}
+
+// SetContextRoles 设置当前请求用户的角色，用于字段级权限等判断
+func SetContextRoles(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("roles", roles)
+	}
+}
+
+// GetContextRoles 获取当前请求用户的角色
+func GetContextRoles(c *gin.Context) []string {
+	return c.GetStringSlice("roles")
+}
+
+// RequireIdentity 要求请求已登录，identities 不为空时用户类型或角色之一必须在其中
+func RequireIdentity(identities ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if GetContextUserId(c) == "" {
+			RenderJson(c, 401, "unauthorized", nil)
+			return
+		}
+		if len(identities) == 0 {
+			return
+		}
+		for _, identity := range callerIdentities(c) {
+			for _, allowed := range identities {
+				if identity != "" && identity == allowed {
+					return
+				}
+			}
+		}
+		RenderJson(c, 403, "forbidden", nil)
+	}
+}
+
 func GetTokensOfUser(userId string, userType string) []string {
 	return store.GetTokensOfUser(userId, userType)
 }
@@ -1,6 +1,12 @@
 package crud
 
-import "reflect"
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
 
 func GetType(i any) reflect.Type {
 	t := reflect.TypeOf(i)
@@ -12,3 +18,81 @@ func GetType(i any) reflect.Type {
 	}
 	return t
 }
+
+// formatValue 将请求参数或查询结果中的值转换为字符串，JSON 解析出的 float64 不使用科学计数法
+func formatValue(v any) string {
+	switch n := v.(type) {
+	case float64:
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(n), 'f', -1, 32)
+	case json.Number:
+		return n.String()
+	}
+	return fmt.Sprint(normalizeValue(v))
+}
+
+// walkStringFields 遍历结构体、map及其切片和指针中的字符串字段，用 fn 的返回值替换原值
+// 结构体字段按 gom 标签中的列名、map 按键名传给 fn，fn 返回 false 表示不修改
+func walkStringFields(val reflect.Value, fn func(col string, s string) (string, bool, error)) error {
+	switch val.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !val.IsNil() {
+			return walkStringFields(val.Elem(), fn)
+		}
+	case reflect.Slice, reflect.Array:
+		if val.Type().Elem().Kind() == reflect.Uint8 {
+			return nil
+		}
+		for idx := 0; idx < val.Len(); idx++ {
+			if er := walkStringFields(val.Index(idx), fn); er != nil {
+				return er
+			}
+		}
+	case reflect.Map:
+		if val.Type().Key().Kind() != reflect.String {
+			return nil
+		}
+		for _, key := range val.MapKeys() {
+			var s string
+			switch v := val.MapIndex(key).Interface().(type) {
+			case string:
+				s = v
+			case []byte:
+				s = string(v)
+			default:
+				continue
+			}
+			newVal, ok, er := fn(key.String(), s)
+			if er != nil {
+				return er
+			}
+			if ok {
+				val.SetMapIndex(key, reflect.ValueOf(newVal))
+			}
+		}
+	case reflect.Struct:
+		t := val.Type()
+		for idx := 0; idx < t.NumField(); idx++ {
+			col := strings.Split(t.Field(idx).Tag.Get("gom"), ",")[0]
+			if col == "" || col == "-" {
+				continue
+			}
+			field := val.Field(idx)
+			if field.Kind() == reflect.Ptr && !field.IsNil() {
+				field = field.Elem()
+			}
+			if field.Kind() != reflect.String || !field.CanSet() {
+				continue
+			}
+			newVal, ok, er := fn(col, field.String())
+			if er != nil {
+				return er
+			}
+			if ok {
+				field.SetString(newVal)
+			}
+		}
+	}
+	return nil
+}
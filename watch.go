@@ -0,0 +1,467 @@
+package crud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4/define"
+	"golang.org/x/net/websocket"
+)
+
+// FeedEvent 带有序号的变更事件，序号用于断线后通过 Last-Event-ID 续传
+type FeedEvent struct {
+	Seq uint64
+	ChangeEvent
+}
+
+// ChangeFeed 基于内存的变更事件流，为 watch 接口提供数据，实现了 EventPublisher
+// 保留最近 history 条事件用于续传，多个资源可以共用一个 ChangeFeed
+// 序号和历史只保存在当前进程中：进程重启后序号从1重新开始，多个实例之间的序号互不相关，
+// 续传只对连回同一进程的客户端有效，多实例部署时需要会话保持，或在续传失败后重新拉取列表
+type ChangeFeed struct {
+	Heartbeat      time.Duration // 心跳间隔，默认15秒
+	AllowedOrigins []string      // 允许建立 WebSocket 连接的 Origin，如 https://app.example.com，* 表示全部；默认只允许同源
+
+	mu          sync.Mutex
+	seq         uint64
+	history     []FeedEvent
+	size        int
+	subscribers map[int]chan FeedEvent
+	nextId      int
+}
+
+// NewChangeFeed 创建变更事件流，history 为保留用于续传的事件数量，默认1000
+func NewChangeFeed(history int) *ChangeFeed {
+	if history <= 0 {
+		history = 1000
+	}
+	return &ChangeFeed{
+		Heartbeat:   15 * time.Second,
+		size:        history,
+		subscribers: make(map[int]chan FeedEvent),
+	}
+}
+
+func (f *ChangeFeed) Publish(ctx context.Context, events ...ChangeEvent) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, event := range events {
+		f.seq++
+		feedEvent := FeedEvent{Seq: f.seq, ChangeEvent: event}
+		f.history = append(f.history, feedEvent)
+		if len(f.history) > f.size {
+			f.history = f.history[len(f.history)-f.size:]
+		}
+		for id, ch := range f.subscribers {
+			select {
+			case ch <- feedEvent:
+			default:
+				// 消费太慢的订阅者直接断开，由客户端通过 Last-Event-ID 续传
+				delete(f.subscribers, id)
+				close(ch)
+			}
+		}
+	}
+	return nil
+}
+
+// Subscribe 订阅序号大于 lastSeq 的事件，返回仍在历史中的积压事件、后续事件的通道和取消订阅的函数
+func (f *ChangeFeed) Subscribe(lastSeq uint64) ([]FeedEvent, <-chan FeedEvent, func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	backlog := make([]FeedEvent, 0)
+	if lastSeq > 0 {
+		for _, event := range f.history {
+			if event.Seq > lastSeq {
+				backlog = append(backlog, event)
+			}
+		}
+	}
+	id := f.nextId
+	f.nextId++
+	ch := make(chan FeedEvent, 64)
+	f.subscribers[id] = ch
+	return backlog, ch, func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if _, ok := f.subscribers[id]; ok {
+			delete(f.subscribers, id)
+			close(ch)
+		}
+	}
+}
+
+// WithWatch 开启 watch 接口，写操作成功后的变更事件通过 feed 推送给订阅者
+func WithWatch(feed *ChangeFeed) Option {
+	return func(o *Options) {
+		o.Watch = feed
+		o.Hooks = append(o.Hooks, EventHook{Publisher: feed})
+	}
+}
+
+// WatchMessage watch 接口推送的消息
+type WatchMessage struct {
+	Id       uint64         `json:"id"`
+	Type     string         `json:"type"` // insert/update/delete，心跳为 heartbeat
+	Resource string         `json:"resource,omitempty"`
+	Keys     map[string]any `json:"keys,omitempty"`
+	Row      map[string]any `json:"row,omitempty"`
+	Time     *time.Time     `json:"time,omitempty"`
+}
+
+// watcher 按 list 接口相同的查询条件、字段权限、解密和脱敏配置过滤事件
+type watcher struct {
+	opts *Options
+	cond *define.Condition
+	cols []string
+}
+
+func newWatcher(c *gin.Context) (*watcher, error) {
+	opts, ok := GetContextOptions(c)
+	if !ok || opts.Watch == nil {
+		return nil, fmt.Errorf("watch is not enabled")
+	}
+	i, ok := GetContextEntity(c)
+	if !ok {
+		return nil, fmt.Errorf("can't find data entity")
+	}
+	cols, er := readableColumns(c, i, getSelectColumns(c))
+	if er != nil {
+		return nil, er
+	}
+	cond, _ := getScopedCondition(c)
+	return &watcher{opts: opts, cond: cond, cols: cols}, nil
+}
+
+// message 将事件转换为推送的消息，不属于该资源或不满足条件的事件返回nil
+func (w *watcher) message(c *gin.Context, event FeedEvent) (*WatchMessage, error) {
+	if event.Resource != w.opts.Resource || !MatchCondition(w.cond, event.Row) {
+		return nil, nil
+	}
+	row := make(map[string]any, len(event.Row))
+	if len(w.cols) > 0 {
+		for _, col := range w.cols {
+			if val, ok := event.Row[col]; ok {
+				row[col] = val
+			}
+		}
+	} else {
+		for k, v := range event.Row {
+			row[k] = v
+		}
+	}
+	if er := DecryptData(c, row); er != nil {
+		return nil, er
+	}
+	if er := MaskData(c, row); er != nil {
+		return nil, er
+	}
+	return &WatchMessage{
+		Id:       event.Seq,
+		Type:     string(event.Operation),
+		Resource: event.Resource,
+		Keys:     event.Keys,
+		Row:      row,
+		Time:     &event.Time,
+	}, nil
+}
+
+// run 推送积压事件和后续事件，直到客户端断开或 send 返回错误，ready 在订阅成功后调用
+func (w *watcher) run(c *gin.Context, lastSeq uint64, done <-chan struct{}, ready func(), send func(*WatchMessage) error) error {
+	backlog, events, cancel := w.opts.Watch.Subscribe(lastSeq)
+	defer cancel()
+	if ready != nil {
+		ready()
+	}
+	for _, event := range backlog {
+		if er := w.push(c, event, send); er != nil {
+			return er
+		}
+	}
+	heartbeat := w.opts.Watch.Heartbeat
+	if heartbeat <= 0 {
+		heartbeat = 15 * time.Second
+	}
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-c.Request.Context().Done():
+			return nil
+		case <-ticker.C:
+			if er := send(&WatchMessage{Type: "heartbeat"}); er != nil {
+				return er
+			}
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if er := w.push(c, event, send); er != nil {
+				return er
+			}
+		}
+	}
+}
+
+func (w *watcher) push(c *gin.Context, event FeedEvent, send func(*WatchMessage) error) error {
+	msg, er := w.message(c, event)
+	if er != nil || msg == nil {
+		return er
+	}
+	return send(msg)
+}
+
+func lastEventId(c *gin.Context) uint64 {
+	id := c.GetHeader("Last-Event-ID")
+	if id == "" {
+		id = c.Query("lastEventId")
+	}
+	seq, _ := strconv.ParseUint(id, 10, 64)
+	return seq
+}
+
+// WatchSSE 以 Server-Sent Events 推送变更，支持通过 Last-Event-ID 请求头或 lastEventId 参数续传
+func WatchSSE() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		w, er := newWatcher(c)
+		if er != nil {
+			RenderErr2(c, 500, er.Error())
+			return
+		}
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no")
+		_ = w.run(c, lastEventId(c), nil, func() {
+			c.Status(200)
+			c.Writer.Flush()
+		}, func(msg *WatchMessage) error {
+			if msg.Type == "heartbeat" {
+				_, er := fmt.Fprint(c.Writer, ": heartbeat\n\n")
+				c.Writer.Flush()
+				return er
+			}
+			data, er := json.Marshal(msg)
+			if er != nil {
+				return er
+			}
+			if _, er := fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", msg.Id, msg.Type, data); er != nil {
+				return er
+			}
+			c.Writer.Flush()
+			return nil
+		})
+	}
+}
+
+// WatchWebSocket 以 WebSocket 推送变更，每条消息为一个 WatchMessage，通过 lastEventId 参数续传
+func WatchWebSocket() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		w, er := newWatcher(c)
+		if er != nil {
+			RenderErr2(c, 500, er.Error())
+			return
+		}
+		lastSeq := lastEventId(c)
+		websocket.Server{Handshake: func(config *websocket.Config, r *http.Request) error {
+			return checkOrigin(r, w.opts.Watch.AllowedOrigins)
+		}, Handler: func(ws *websocket.Conn) {
+			done := make(chan struct{})
+			go func() {
+				// 客户端不需要发送消息，读取失败即认为连接已断开
+				var discard string
+				for websocket.Message.Receive(ws, &discard) == nil {
+				}
+				close(done)
+			}()
+			_ = w.run(c, lastSeq, done, nil, func(msg *WatchMessage) error {
+				return websocket.JSON.Send(ws, msg)
+			})
+		}}.ServeHTTP(c.Writer, c.Request)
+		c.Abort()
+	}
+}
+
+// checkOrigin 校验 WebSocket 握手的 Origin，避免其他站点的页面带着用户的 Cookie 订阅变更
+// 没有 Origin 的请求来自非浏览器客户端，不做限制
+func checkOrigin(r *http.Request, allowed []string) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	u, er := url.Parse(origin)
+	if er != nil {
+		return fmt.Errorf("invalid origin [%s]", origin)
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return nil
+	}
+	for _, item := range allowed {
+		if item == "*" || strings.EqualFold(strings.TrimSuffix(item, "/"), origin) {
+			return nil
+		}
+	}
+	return fmt.Errorf("origin [%s] is not allowed", origin)
+}
+
+func GetWatchHandler(name, description string, parameters []ApiProperty, response APIResponse, beforeCommitFunc ...gin.HandlerFunc) RouteHandler {
+	return withOperation(GetRouteHandler(string(PathWatch), "GET", name, description, parameters, response, append(beforeCommitFunc, WatchSSE())...), PathList)
+}
+
+func GetWatchWebSocketHandler(name, description string, parameters []ApiProperty, response APIResponse, beforeCommitFunc ...gin.HandlerFunc) RouteHandler {
+	return withOperation(GetRouteHandler(string(PathWatchWs), "GET", name, description, parameters, response, append(beforeCommitFunc, WatchWebSocket())...), PathList)
+}
+
+func generateWatchResponse(modelName string, resultProperties []ApiProperty) APIResponse {
+	return APIResponse{
+		Description: modelName + "变更事件流",
+		Content: map[string]MediaType{
+			"text/event-stream": {
+				Schema: &ApiProperty{
+					Type: "object",
+					Fields: []ApiProperty{
+						{Name: "id", Type: "integer", Description: "事件序号"},
+						{Name: "type", Type: "string", Description: "insert/update/delete，心跳为 heartbeat"},
+						{Name: "resource", Type: "string", Description: "资源名称"},
+						{Name: "keys", Type: "object", Description: "主键"},
+						{Name: "row", Type: "object", Description: "变更后的数据，删除时为删除前的数据", Fields: resultProperties},
+						{Name: "time", Type: "string", Description: "变更时间"},
+					},
+				},
+			},
+		},
+	}
+}
+
+// MatchCondition 在内存中判断一行数据是否满足条件，cnd 为空时总是满足
+func MatchCondition(cnd *define.Condition, row map[string]any) bool {
+	if cnd == nil {
+		return true
+	}
+	if cnd.IsSubGroup {
+		for _, sub := range cnd.SubConds {
+			if sub != nil && !MatchCondition(sub, row) {
+				return false
+			}
+		}
+		return true
+	}
+	matched := true
+	if cnd.Field != "" {
+		matched = matchOne(cnd, row)
+	}
+	for _, sub := range cnd.SubConds {
+		if sub == nil {
+			continue
+		}
+		if sub.JoinType == define.JoinOr {
+			matched = matched || MatchCondition(sub, row)
+		} else {
+			matched = matched && MatchCondition(sub, row)
+		}
+	}
+	return matched
+}
+
+func matchOne(cnd *define.Condition, row map[string]any) bool {
+	val, exists := row[cnd.Field]
+	val = normalizeValue(val)
+	switch cnd.Op {
+	case define.OpIsNull:
+		return !exists || val == nil
+	case define.OpIsNotNull:
+		return exists && val != nil
+	}
+	if val == nil {
+		return false
+	}
+	switch cnd.Op {
+	case define.OpEq:
+		return compareValues(val, cnd.Value) == 0
+	case define.OpNe:
+		return compareValues(val, cnd.Value) != 0
+	case define.OpGt:
+		return compareValues(val, cnd.Value) > 0
+	case define.OpGe:
+		return compareValues(val, cnd.Value) >= 0
+	case define.OpLt:
+		return compareValues(val, cnd.Value) < 0
+	case define.OpLe:
+		return compareValues(val, cnd.Value) <= 0
+	case define.OpIn, define.OpNotIn:
+		found := false
+		if values, ok := cnd.Value.([]interface{}); ok {
+			for _, v := range values {
+				if compareValues(val, v) == 0 {
+					found = true
+					break
+				}
+			}
+		}
+		return found == (cnd.Op == define.OpIn)
+	case define.OpLike, define.OpNotLike:
+		return likeMatch(fmt.Sprint(val), fmt.Sprint(cnd.Value)) == (cnd.Op == define.OpLike)
+	}
+	return false
+}
+
+// compareValues 比较两个值，都能转换为数字时按数字比较，时间按时间比较，否则按字符串比较
+func compareValues(a, b any) int {
+	if ta, ok := a.(time.Time); ok {
+		if tb, er := parseAsOf(fmt.Sprint(normalizeValue(b))); er == nil {
+			return ta.Compare(tb)
+		}
+		if tb, ok := b.(time.Time); ok {
+			return ta.Compare(tb)
+		}
+	}
+	sa, sb := fmt.Sprint(normalizeValue(a)), fmt.Sprint(normalizeValue(b))
+	fa, erA := strconv.ParseFloat(sa, 64)
+	fb, erB := strconv.ParseFloat(sb, 64)
+	if erA == nil && erB == nil {
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	}
+	if ba, ok := a.(bool); ok {
+		if bb, er := strconv.ParseBool(sb); er == nil && ba == bb {
+			return 0
+		}
+		return 1
+	}
+	return strings.Compare(sa, sb)
+}
+
+// likeMatch 按 SQL LIKE 的规则匹配，支持 % 和 _
+func likeMatch(s, pattern string) bool {
+	var sb strings.Builder
+	sb.WriteString("(?s)^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			sb.WriteString(".*")
+		case '_':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	matched, _ := regexp.MatchString(sb.String(), s)
+	return matched
+}
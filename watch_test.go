@@ -0,0 +1,110 @@
+package crud
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4/define"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/websocket"
+)
+
+func TestMatchCondition(t *testing.T) {
+	row := map[string]any{"id": int64(3), "title": "hello world", "views": int64(12), "deleted_at": nil}
+	assert.True(t, MatchCondition(nil, row))
+	assert.True(t, MatchCondition(define.Eq("id", "3"), row))
+	assert.False(t, MatchCondition(define.Gt("views", "12"), row))
+	assert.True(t, MatchCondition(define.Like("title", "hello%"), row))
+	assert.True(t, MatchCondition(define.In("id", 1, 3), row))
+	assert.True(t, MatchCondition(define.NewCondition("deleted_at", define.OpIsNull, nil), row))
+	assert.True(t, MatchCondition(define.Eq("id", 4).Or(define.Ge("views", 10)), row))
+	assert.False(t, MatchCondition(define.Eq("id", 3).And(define.Lt("views", 10)), row))
+}
+
+func TestChangeFeedResume(t *testing.T) {
+	feed := NewChangeFeed(2)
+	for idx := 0; idx < 3; idx++ {
+		assert.NoError(t, feed.Publish(context.Background(), ChangeEvent{Resource: "articles", Operation: ChangeInsert}))
+	}
+	backlog, _, cancel := feed.Subscribe(1)
+	defer cancel()
+	assert.Len(t, backlog, 2)
+	assert.Equal(t, uint64(2), backlog[0].Seq)
+	assert.Equal(t, uint64(3), backlog[1].Seq)
+}
+
+func TestWatchSSE(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	feed := NewChangeFeed(10)
+	opts := NewOptions(&Article{}, WithWatch(feed))
+	opts.Resource = "articles"
+	params := []ConditionParam{{QueryName: "viewsGe", ColName: "views", Operation: define.OpGe}}
+	r := gin.New()
+	r.GET("/articles/watch", SetContextOptions(opts), SetContextEntity(&Article{}), SetConditionParamAsCnd(params), SetColumns([]string{"id", "title"}), WatchSSE())
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	// 连接前已经发生的事件通过 Last-Event-ID 续传
+	_ = feed.Publish(context.Background(), ChangeEvent{Resource: "articles", Operation: ChangeInsert, Row: map[string]any{"id": 1, "title": "old", "views": 1}})
+	_ = feed.Publish(context.Background(), ChangeEvent{Resource: "articles", Operation: ChangeUpdate, Row: map[string]any{"id": 2, "title": "resumed", "views": 9}})
+
+	req, _ := http.NewRequest("GET", server.URL+"/articles/watch?viewsGe=5", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, er := http.DefaultClient.Do(req)
+	assert.NoError(t, er)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	go func() {
+		_ = feed.Publish(context.Background(), ChangeEvent{Resource: "others", Operation: ChangeInsert, Row: map[string]any{"id": 3, "views": 9}})
+		_ = feed.Publish(context.Background(), ChangeEvent{Resource: "articles", Operation: ChangeInsert, Row: map[string]any{"id": 4, "title": "cold", "views": 2}})
+		_ = feed.Publish(context.Background(), ChangeEvent{Resource: "articles", Operation: ChangeDelete, Row: map[string]any{"id": 5, "title": "live", "views": 7}})
+	}()
+
+	reader := bufio.NewReader(resp.Body)
+	data := make([]string, 0)
+	ids := make([]string, 0)
+	for len(data) < 2 {
+		line, er := reader.ReadString('\n')
+		if !assert.NoError(t, er) {
+			return
+		}
+		if strings.HasPrefix(line, "id: ") {
+			ids = append(ids, strings.TrimSpace(strings.TrimPrefix(line, "id: ")))
+		}
+		if strings.HasPrefix(line, "data: ") {
+			data = append(data, line)
+		}
+	}
+	assert.Equal(t, []string{"2", "5"}, ids)
+	assert.Contains(t, data[0], `"title":"resumed"`)
+	assert.Contains(t, data[1], `"type":"delete"`)
+	assert.NotContains(t, data[1], `"views"`)
+}
+
+func TestWatchWebSocketOrigin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	feed := NewChangeFeed(10)
+	feed.AllowedOrigins = []string{"https://app.example.com"}
+	opts := NewOptions(&Article{}, WithWatch(feed))
+	r := gin.New()
+	r.GET("/articles/watch/ws", SetContextOptions(opts), SetContextEntity(&Article{}), SetColumns([]string{"id", "title"}), WatchWebSocket())
+	server := httptest.NewServer(r)
+	defer server.Close()
+	wsUrl := "ws" + strings.TrimPrefix(server.URL, "http") + "/articles/watch/ws"
+
+	_, er := websocket.Dial(wsUrl, "", "https://evil.example.com")
+	assert.Error(t, er)
+
+	for _, origin := range []string{"https://app.example.com", server.URL} {
+		ws, er := websocket.Dial(wsUrl, "", origin)
+		if assert.NoError(t, er, origin) {
+			ws.Close()
+		}
+	}
+}
@@ -0,0 +1,461 @@
+package crud
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kmlixh/gom/v4"
+	"github.com/kmlixh/gom/v4/define"
+)
+
+// Webhook 请求头
+const (
+	WebhookHeaderDelivery  = "X-Webhook-Delivery"  // 投递记录ID
+	WebhookHeaderEvent     = "X-Webhook-Event"     // 事件名称，格式为 {resource}.{operation}
+	WebhookHeaderTimestamp = "X-Webhook-Timestamp" // 签名时间，unix秒
+	WebhookHeaderSignature = "X-Webhook-Signature" // 签名，格式为 sha256={hex}
+)
+
+// WebhookSubscription Webhook 订阅
+type WebhookSubscription struct {
+	ID         int64     `json:"id" gom:"id,@,auto"`
+	Url        string    `json:"url" gom:"url"`
+	Secret     string    `json:"secret" gom:"secret" mask:"all"`
+	Resources  string    `json:"resources" gom:"resources"`   // 订阅的资源，逗号分隔，为空或*表示全部
+	Operations string    `json:"operations" gom:"operations"` // 订阅的变更类型，逗号分隔，为空或*表示全部
+	Active     bool      `json:"active" gom:"active"`
+	CreatedAt  time.Time `json:"createdAt" gom:"created_at"`
+}
+
+func (WebhookSubscription) TableName() string {
+	return "crud_webhook_subscription"
+}
+
+// Match 判断订阅是否包含资源的变更类型
+func (s WebhookSubscription) Match(resource string, op ChangeType) bool {
+	return s.Active && matchList(s.Resources, resource) && matchList(s.Operations, string(op))
+}
+
+func matchList(list string, val string) bool {
+	list = strings.TrimSpace(list)
+	if list == "" || list == "*" {
+		return true
+	}
+	for _, item := range strings.Split(list, ",") {
+		if strings.TrimSpace(item) == val {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery Webhook 投递记录
+type WebhookDelivery struct {
+	ID             int64      `json:"id" gom:"id,@,auto"`
+	SubscriptionId int64      `json:"subscriptionId" gom:"subscription_id"`
+	EventId        string     `json:"eventId" gom:"event_id"`
+	Event          string     `json:"event" gom:"event"`
+	Url            string     `json:"url" gom:"url"`
+	Payload        string     `json:"payload" gom:"payload"`
+	Attempts       int        `json:"attempts" gom:"attempts"`
+	StatusCode     int        `json:"statusCode" gom:"status_code"`
+	Error          string     `json:"error" gom:"error"`
+	Success        bool       `json:"success" gom:"success"`
+	CreatedAt      time.Time  `json:"createdAt" gom:"created_at"`
+	DeliveredAt    *time.Time `json:"deliveredAt" gom:"delivered_at"` // 投递成功的时间
+}
+
+func (WebhookDelivery) TableName() string {
+	return "crud_webhook_delivery"
+}
+
+// SignWebhook 计算 Webhook 签名：HMAC-SHA256(secret, timestamp + "." + body)
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook 供接收方校验签名，tolerance 大于0时拒绝超过该时间的请求
+func VerifyWebhook(secret string, r *http.Request, body []byte, tolerance time.Duration) error {
+	timestamp, er := strconv.ParseInt(r.Header.Get(WebhookHeaderTimestamp), 10, 64)
+	if er != nil {
+		return errors.New("invalid webhook timestamp")
+	}
+	if tolerance > 0 && time.Since(time.Unix(timestamp, 0)).Abs() > tolerance {
+		return errors.New("webhook timestamp out of tolerance")
+	}
+	if !hmac.Equal([]byte(SignWebhook(secret, timestamp, body)), []byte(r.Header.Get(WebhookHeaderSignature))) {
+		return errors.New("invalid webhook signature")
+	}
+	return nil
+}
+
+// Webhooks 将变更事件投递给订阅者，实现了 EventPublisher
+// 可以通过 WithEventPublisher 或 Outbox 接入资源，投递在后台进行，失败时按指数退避重试
+// 重试在进程内存中等待，进程重启后不会继续，尽力而为；仍未成功的投递记录 success 为 false，可以通过 Redeliver 重新投递
+type Webhooks struct {
+	DB           *gom.DB
+	Client       *http.Client
+	MaxAttempts  int           // 最大尝试次数，默认5
+	BaseDelay    time.Duration // 首次重试的等待时间，默认1秒，之后每次翻倍
+	MaxDelay     time.Duration // 重试等待时间的上限，默认5分钟
+	AllowPrivate bool          // 允许投递到回环、内网、链路本地等地址，默认拒绝
+	wg           sync.WaitGroup
+}
+
+// NewWebhooks 创建 Webhook 投递器，订阅和投递记录分别保存在 crud_webhook_subscription、crud_webhook_delivery 表中
+// 默认的 Client 在建立连接时校验解析出的地址，避免域名重新解析到内网地址
+func NewWebhooks(db *gom.DB) *Webhooks {
+	w := &Webhooks{
+		DB:          db,
+		MaxAttempts: 5,
+		BaseDelay:   time.Second,
+		MaxDelay:    5 * time.Minute,
+	}
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, er := net.SplitHostPort(address)
+			if er != nil {
+				return er
+			}
+			if ip := net.ParseIP(host); !w.AllowPrivate && ip != nil && deniedWebhookIP(ip) {
+				return fmt.Errorf("webhook address [%s] is not allowed", host)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	w.Client = &http.Client{Timeout: 10 * time.Second, Transport: transport}
+	return w
+}
+
+// deniedWebhookIP 回环、内网、链路本地、组播和未指定地址不允许作为 Webhook 地址
+func deniedWebhookIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
+
+// ValidateWebhookURL 校验订阅地址：只允许 http、https，主机解析出的地址都不能是回环、内网、链路本地等地址
+func ValidateWebhookURL(raw string) error {
+	u, er := url.Parse(raw)
+	if er != nil {
+		return fmt.Errorf("invalid webhook url [%s]", raw)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("webhook url [%s] should be http or https", raw)
+	}
+	host := u.Hostname()
+	if host == "" {
+		return fmt.Errorf("webhook url [%s] has no host", raw)
+	}
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		addrs, er := net.LookupIP(host)
+		if er != nil {
+			return fmt.Errorf("could not resolve webhook host [%s]", host)
+		}
+		ips = addrs
+	}
+	for _, ip := range ips {
+		if deniedWebhookIP(ip) {
+			return fmt.Errorf("webhook host [%s] is not allowed", host)
+		}
+	}
+	return nil
+}
+
+// Backoff 第 attempt 次失败后的等待时间
+func (w *Webhooks) Backoff(attempt int) time.Duration {
+	delay := w.BaseDelay
+	for idx := 1; idx < attempt && delay < w.MaxDelay; idx++ {
+		delay *= 2
+	}
+	if w.MaxDelay > 0 && delay > w.MaxDelay {
+		delay = w.MaxDelay
+	}
+	return delay
+}
+
+// Wait 等待后台投递全部结束
+func (w *Webhooks) Wait() {
+	w.wg.Wait()
+}
+
+func (w *Webhooks) Publish(ctx context.Context, events ...ChangeEvent) error {
+	subs := make([]WebhookSubscription, 0)
+	result := w.DB.Chain().Table(WebhookSubscription{}.TableName()).Eq("active", true).List()
+	if result.Error != nil {
+		return result.Error
+	}
+	if er := result.Into(&subs); er != nil {
+		return er
+	}
+	for _, event := range events {
+		payload, er := json.Marshal(event)
+		if er != nil {
+			return er
+		}
+		for _, sub := range subs {
+			if !sub.Match(event.Resource, event.Operation) {
+				continue
+			}
+			delivery := &WebhookDelivery{
+				SubscriptionId: sub.ID,
+				EventId:        event.Id,
+				Event:          event.Resource + "." + string(event.Operation),
+				Url:            sub.Url,
+				Payload:        string(payload),
+				CreatedAt:      time.Now(),
+			}
+			if er := w.createDelivery(delivery); er != nil {
+				return er
+			}
+			w.wg.Add(1)
+			go func(sub WebhookSubscription, delivery *WebhookDelivery) {
+				defer w.wg.Done()
+				w.deliver(context.Background(), sub, delivery, w.MaxAttempts, w.saveDelivery)
+			}(sub, delivery)
+		}
+	}
+	return nil
+}
+
+// send 发送一次请求，返回状态码
+func (w *Webhooks) send(ctx context.Context, sub WebhookSubscription, delivery *WebhookDelivery) (int, error) {
+	if !w.AllowPrivate {
+		if er := ValidateWebhookURL(sub.Url); er != nil {
+			return 0, er
+		}
+	}
+	body := []byte(delivery.Payload)
+	req, er := http.NewRequestWithContext(ctx, http.MethodPost, sub.Url, bytes.NewReader(body))
+	if er != nil {
+		return 0, er
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookHeaderDelivery, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(WebhookHeaderEvent, delivery.Event)
+	req.Header.Set(WebhookHeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookHeaderSignature, SignWebhook(sub.Secret, timestamp, body))
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, er := client.Do(req)
+	if er != nil {
+		return 0, er
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook receiver responded %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// deliver 最多尝试 attempts 次投递，每次尝试后通过 record 保存投递记录
+func (w *Webhooks) deliver(ctx context.Context, sub WebhookSubscription, delivery *WebhookDelivery, attempts int, record func(*WebhookDelivery) error) {
+	for idx := 1; idx <= attempts; idx++ {
+		status, er := w.send(ctx, sub, delivery)
+		delivery.Attempts++
+		delivery.StatusCode = status
+		delivery.Error = ""
+		if er != nil {
+			delivery.Error = er.Error()
+		} else {
+			now := time.Now()
+			delivery.Success = true
+			delivery.DeliveredAt = &now
+		}
+		if record != nil {
+			_ = record(delivery)
+		}
+		if delivery.Success || idx == attempts {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(w.Backoff(idx)):
+		}
+	}
+}
+
+func (w *Webhooks) createDelivery(delivery *WebhookDelivery) error {
+	result := w.DB.Chain().Table(WebhookDelivery{}.TableName()).Values(map[string]any{
+		"subscription_id": delivery.SubscriptionId,
+		"event_id":        delivery.EventId,
+		"event":           delivery.Event,
+		"url":             delivery.Url,
+		"payload":         delivery.Payload,
+		"attempts":        0,
+		"status_code":     0,
+		"error":           "",
+		"success":         false,
+		"created_at":      delivery.CreatedAt,
+	}).Save()
+	if result.Error != nil {
+		return result.Error
+	}
+	delivery.ID = result.ID
+	return nil
+}
+
+func (w *Webhooks) saveDelivery(delivery *WebhookDelivery) error {
+	result := w.DB.Chain().Table(WebhookDelivery{}.TableName()).Eq("id", delivery.ID).Update(map[string]any{
+		"attempts":     delivery.Attempts,
+		"status_code":  delivery.StatusCode,
+		"error":        delivery.Error,
+		"success":      delivery.Success,
+		"delivered_at": delivery.DeliveredAt,
+	})
+	return result.Error
+}
+
+// Redeliver 立即重新投递一条投递记录，只尝试一次，返回更新后的投递记录
+func (w *Webhooks) Redeliver(ctx context.Context, deliveryId int64) (*WebhookDelivery, error) {
+	delivery := &WebhookDelivery{}
+	result := w.DB.Chain().Table(delivery.TableName()).Eq("id", deliveryId).First()
+	if result.Error != nil {
+		if errors.Is(result.Error, sql.ErrNoRows) {
+			return nil, errors.New("delivery not found")
+		}
+		return nil, result.Error
+	}
+	if er := result.Into(delivery); er != nil {
+		return nil, er
+	}
+	sub := &WebhookSubscription{}
+	result = w.DB.Chain().Table(sub.TableName()).Eq("id", delivery.SubscriptionId).First()
+	if result.Error != nil {
+		if errors.Is(result.Error, sql.ErrNoRows) {
+			return nil, errors.New("subscription not found")
+		}
+		return nil, result.Error
+	}
+	if er := result.Into(sub); er != nil {
+		return nil, er
+	}
+	delivery.Success = false
+	delivery.DeliveredAt = nil
+	var saveErr error
+	w.deliver(ctx, *sub, delivery, 1, func(d *WebhookDelivery) error {
+		saveErr = w.saveDelivery(d)
+		return saveErr
+	})
+	return delivery, saveErr
+}
+
+// DoRedeliver 重新投递接口，参数 id 为投递记录ID
+func (w *Webhooks) DoRedeliver() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		maps, er := GetMapFromRst(c)
+		if er != nil {
+			RenderErrs(c, er)
+			return
+		}
+		id, er := strconv.ParseInt(formatValue(maps["id"]), 10, 64)
+		if er != nil {
+			RenderErrs(c, errors.New("id could not be empty"))
+			return
+		}
+		delivery, er := w.Redeliver(c, id)
+		if er != nil {
+			RenderErr2(c, 500, er.Error())
+			return
+		}
+		RenderOk(c, delivery)
+	}
+}
+
+// DeliveryCrud 生成投递记录的查询和重新投递接口：{prefix}/list、{prefix}/redeliver
+func (w *Webhooks) DeliveryCrud(prefix string) (ICrud, error) {
+	i := &WebhookDelivery{}
+	conditionParams := []ConditionParam{
+		{QueryName: "subscription_idEq", ColName: "subscription_id", Operation: define.OpEq, DataType: reflect.Int64},
+		{QueryName: "event_idEq", ColName: "event_id", Operation: define.OpEq, DataType: reflect.String},
+		{QueryName: "successEq", ColName: "success", Operation: define.OpEq, DataType: reflect.Bool},
+	}
+	properties := GenerateApiPropertiesFromStruct(i)
+	listHandler := GetQueryListHandler(
+		"Webhook投递记录列表查询",
+		"获取Webhook投递记录分页列表",
+		generateApiPropertys(conditionParams, "query", false),
+		generateListResponse("WebhookDelivery", properties),
+		SetContextDatabase(w.DB),
+		SetContextEntity(i),
+		SetConditionParamAsCnd(conditionParams),
+		DefaultGenPageFromRstQuery,
+	)
+	redeliverResp := NewCodeMsgResponse("重新投递Webhook", 200, "ok")
+	redeliverResp.Content["data"] = MediaType{Schema: &ApiProperty{Type: "object", Fields: properties}}
+	redeliverHandler := GetRouteHandler(
+		string(PathRedeliver),
+		"POST",
+		"Webhook重新投递",
+		"立即重新投递一条Webhook投递记录",
+		[]ApiProperty{{Name: "id", Type: "integer", Required: true, Description: "投递记录ID", Location: "body"}},
+		redeliverResp,
+		w.DoRedeliver(),
+	)
+	return GenHandlerRegister(prefix, listHandler, redeliverHandler)
+}
+
+// webhookUrlHook 新增、修改订阅时校验订阅地址，不合法时回滚
+type webhookUrlHook struct{}
+
+func (webhookUrlHook) InTx(c *gin.Context, tx *gom.Chain, change *Change) error {
+	if change.After == nil {
+		return nil
+	}
+	return ValidateWebhookURL(fmt.Sprint(normalizeValue(change.After["url"])))
+}
+
+func (webhookUrlHook) AfterCommit(c *gin.Context, change *Change) {}
+
+// NewWebhookSubscriptionCrud 生成管理 Webhook 订阅的增删改查接口，secret 在查询结果中脱敏，订阅地址按 ValidateWebhookURL 校验
+func NewWebhookSubscriptionCrud(prefix string, db *gom.DB, options ...Option) (ICrud, error) {
+	cols := []string{"id", "url", "secret", "resources", "operations", "active", "created_at"}
+	writeCols := []string{"url", "secret", "resources", "operations", "active"}
+	idParam := []ConditionParam{{QueryName: "idEq", ColName: "id", Operation: define.OpEq, DataType: reflect.Int64}}
+	return NewCrud2(
+		prefix,
+		&WebhookSubscription{},
+		db,
+		cols,
+		[]ConditionParam{{QueryName: "activeEq", ColName: "active", Operation: define.OpEq, DataType: reflect.Bool}},
+		cols,
+		idParam,
+		writeCols,
+		writeCols,
+		idParam,
+		idParam,
+		nil,
+		append([]Option{WithChangeHooks(webhookUrlHook{})}, options...)...,
+	)
+}
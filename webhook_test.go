@@ -0,0 +1,99 @@
+package crud
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookDeliver(t *testing.T) {
+	var calls int32
+	var verifyErr error
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		verifyErr = VerifyWebhook("s3cret", r, body, time.Minute)
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	hooks := &Webhooks{Client: receiver.Client(), AllowPrivate: true, MaxAttempts: 5, BaseDelay: time.Millisecond, MaxDelay: 4 * time.Millisecond}
+	sub := WebhookSubscription{ID: 1, Url: receiver.URL, Secret: "s3cret", Active: true}
+	delivery := &WebhookDelivery{ID: 9, Event: "users.update", Payload: `{"id":"e1"}`}
+	records := 0
+	hooks.deliver(context.Background(), sub, delivery, hooks.MaxAttempts, func(d *WebhookDelivery) error {
+		records++
+		return nil
+	})
+
+	assert.NoError(t, verifyErr)
+	assert.Equal(t, int32(3), calls)
+	assert.Equal(t, 3, records)
+	assert.Equal(t, 3, delivery.Attempts)
+	assert.True(t, delivery.Success)
+	assert.Equal(t, http.StatusNoContent, delivery.StatusCode)
+	assert.NotNil(t, delivery.DeliveredAt)
+}
+
+func TestWebhookBackoffAndMatch(t *testing.T) {
+	hooks := &Webhooks{BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+	assert.Equal(t, time.Second, hooks.Backoff(1))
+	assert.Equal(t, 2*time.Second, hooks.Backoff(2))
+	assert.Equal(t, 4*time.Second, hooks.Backoff(3))
+	assert.Equal(t, 5*time.Second, hooks.Backoff(10))
+
+	sub := WebhookSubscription{Resources: "users, orders", Operations: "insert,delete", Active: true}
+	assert.True(t, sub.Match("orders", ChangeDelete))
+	assert.False(t, sub.Match("orders", ChangeUpdate))
+	assert.False(t, sub.Match("items", ChangeInsert))
+	sub.Active = false
+	assert.False(t, sub.Match("users", ChangeInsert))
+}
+
+func TestVerifyWebhookRejectsTampering(t *testing.T) {
+	r := httptest.NewRequest("POST", "/hook", nil)
+	r.Header.Set(WebhookHeaderTimestamp, "1")
+	r.Header.Set(WebhookHeaderSignature, SignWebhook("k", 1, []byte("a")))
+	assert.Error(t, VerifyWebhook("k", r, []byte("a"), time.Minute))
+	assert.NoError(t, VerifyWebhook("k", r, []byte("a"), 0))
+	assert.Error(t, VerifyWebhook("k", r, []byte("b"), 0))
+}
+
+func TestWebhookRejectsPrivateTargets(t *testing.T) {
+	assert.NoError(t, ValidateWebhookURL("https://93.184.216.34/hook"))
+	for _, raw := range []string{
+		"ftp://93.184.216.34/hook",
+		"http:///hook",
+		"http://127.0.0.1:8080/hook",
+		"http://10.0.0.8/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[::1]/hook",
+		"http://0.0.0.0/hook",
+	} {
+		assert.Error(t, ValidateWebhookURL(raw), raw)
+	}
+
+	// 默认的 Client 在连接时拒绝内网地址
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+	hooks := NewWebhooks(nil)
+	delivery := &WebhookDelivery{ID: 1, Event: "users.update", Payload: `{}`}
+	hooks.deliver(context.Background(), WebhookSubscription{Url: receiver.URL, Active: true}, delivery, 1, nil)
+	assert.False(t, delivery.Success)
+	assert.Contains(t, delivery.Error, "is not allowed")
+
+	hooks.AllowPrivate = true
+	hooks.deliver(context.Background(), WebhookSubscription{Url: receiver.URL, Active: true}, delivery, 1, nil)
+	assert.True(t, delivery.Success)
+}